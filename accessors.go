@@ -0,0 +1,173 @@
+package imx
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"imx/formats"
+)
+
+// lazyEXIF is the pending decode of an EXIF block deferred with
+// WithLazyEXIF.
+type lazyEXIF struct {
+	once sync.Once
+	opts formats.Options
+}
+
+// LoadEXIF decodes the EXIF block deferred with WithLazyEXIF into EXIF,
+// and fills the fields derived from it: Orientation and an EXIF
+// resolution in DPIX and DPIY. Only the first call decodes; without
+// WithLazyEXIF EXIF was decoded during extraction. It returns EXIF.
+func (md *ImageMetadata) LoadEXIF() map[string]interface{} {
+	if md.lazyEXIF == nil {
+		return md.EXIF
+	}
+	md.lazyEXIF.once.Do(func() {
+		exif, err := formats.DecodeEXIF(md.rawEXIF, md.lazyEXIF.opts)
+		if err != nil || len(exif) == 0 {
+			return
+		}
+		// The derived fields are worked out on a copy, whose accessors
+		// would otherwise come back here
+		decoded := &ImageMetadata{EXIF: exif, Additional: md.Additional, Orientation: md.Orientation}
+		decoded.setResolution()
+		decoded.setOrientation()
+		md.EXIF = exif
+		md.DPIX, md.DPIY, md.Orientation = decoded.DPIX, decoded.DPIY, decoded.Orientation
+	})
+	return md.EXIF
+}
+
+// FlatEXIF returns EXIF as one flat map, with the IFD0, ExifIFD and GPS tags
+// at the top level. It is EXIF itself unless WithGroupedEXIF grouped it by
+// directory, in which case the groups are merged into a new map.
+func (md *ImageMetadata) FlatEXIF() map[string]interface{} {
+	exif := md.LoadEXIF()
+	if _, grouped := exif["IFD0"].(map[string]interface{}); grouped {
+		return formats.FlattenEXIF(exif)
+	}
+	return exif
+}
+
+// EXIFString returns the EXIF value for key as a string. Values that are
+// not strings, other than those with a String method such as Rational,
+// report false.
+func (md *ImageMetadata) EXIFString(key string) (string, bool) {
+	return lookupString(md.FlatEXIF(), key)
+}
+
+// EXIFInt returns the EXIF value for key as an integer. Any integer type is
+// accepted, as are floats and rationals with a whole-number value.
+func (md *ImageMetadata) EXIFInt(key string) (int64, bool) {
+	return lookupInt(md.FlatEXIF(), key)
+}
+
+// EXIFFloat returns the EXIF value for key as a float. Any numeric type is
+// accepted; rationals with a zero denominator report false.
+func (md *ImageMetadata) EXIFFloat(key string) (float64, bool) {
+	return lookupFloat(md.FlatEXIF(), key)
+}
+
+// AdditionalString is EXIFString for the Additional map.
+func (md *ImageMetadata) AdditionalString(key string) (string, bool) {
+	return lookupString(md.Additional, key)
+}
+
+// AdditionalInt is EXIFInt for the Additional map.
+func (md *ImageMetadata) AdditionalInt(key string) (int64, bool) {
+	return lookupInt(md.Additional, key)
+}
+
+// AdditionalFloat is EXIFFloat for the Additional map.
+func (md *ImageMetadata) AdditionalFloat(key string) (float64, bool) {
+	return lookupFloat(md.Additional, key)
+}
+
+func lookupString(m map[string]interface{}, key string) (string, bool) {
+	switch v := m[key].(type) {
+	case string:
+		return v, true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return "", false
+}
+
+func lookupInt(m map[string]interface{}, key string) (int64, bool) {
+	switch v := m[key].(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return uintToInt(uint64(v))
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return uintToInt(v)
+	case float32:
+		return floatToInt(float64(v))
+	case float64:
+		return floatToInt(v)
+	case Rational:
+		return ratioToInt(v.Num, v.Den)
+	case SRational:
+		return ratioToInt(v.Num, v.Den)
+	}
+	return 0, false
+}
+
+func lookupFloat(m map[string]interface{}, key string) (float64, bool) {
+	switch v := m[key].(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case Rational:
+		if v.Den == 0 {
+			return 0, false
+		}
+		return v.Float64(), true
+	case SRational:
+		if v.Den == 0 {
+			return 0, false
+		}
+		return v.Float64(), true
+	}
+	if n, ok := lookupInt(m, key); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func uintToInt(v uint64) (int64, bool) {
+	if v > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+func floatToInt(v float64) (int64, bool) {
+	if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+func ratioToInt(num, den int64) (int64, bool) {
+	if den == 0 || num%den != 0 {
+		return 0, false
+	}
+	return num / den, true
+}
@@ -0,0 +1,65 @@
+package imx
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult is the outcome of extracting metadata from one file of a batch.
+type BatchResult struct {
+	Path     string
+	Metadata *ImageMetadata
+	Err      error
+}
+
+// MetadataBatch extracts metadata from every path using up to concurrency
+// files at a time. Results are returned in the order of paths, and a file
+// that fails only sets the Err of its own BatchResult.
+func MetadataBatch(paths []string, concurrency int, opts ...Option) ([]BatchResult, error) {
+	return MetadataBatchContext(context.Background(), paths, concurrency, opts...)
+}
+
+// MetadataBatchContext is like MetadataBatch but stops starting new files
+// once ctx is done. Files that were not processed report ctx.Err() in their
+// BatchResult, which is also returned as the error.
+func MetadataBatchContext(ctx context.Context, paths []string, concurrency int, opts ...Option) ([]BatchResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+
+	results := make([]BatchResult, len(paths))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				md, err := MetadataFromFileContext(ctx, paths[j], opts...)
+				results[j] = BatchResult{Path: paths[j], Metadata: md, Err: err}
+			}
+		}()
+	}
+
+	next := 0
+feed:
+	for ; next < len(paths); next++ {
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := next; i < len(paths); i++ {
+		results[i] = BatchResult{Path: paths[i], Err: ctx.Err()}
+	}
+
+	return results, ctx.Err()
+}
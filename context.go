@@ -0,0 +1,33 @@
+package imx
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails every Read with ctx.Err() once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// contextReadSeeker adds Seek to contextReader so format parsers, which
+// read and seek once per segment or chunk, stop at their next I/O call.
+type contextReadSeeker struct {
+	contextReader
+	s io.Seeker
+}
+
+func (c *contextReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.s.Seek(offset, whence)
+}
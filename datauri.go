@@ -0,0 +1,66 @@
+package imx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// isDataURI reports whether uri uses the data: scheme.
+func isDataURI(uri string) bool {
+	return len(uri) >= 5 && strings.EqualFold(uri[:5], "data:")
+}
+
+// decodeDataURI returns the payload of a data: URI (RFC 2397), either
+// base64 or percent-encoded. Payloads that would decode to more than
+// maxBytes fail with ErrTooLarge; maxBytes <= 0 means no limit.
+func decodeDataURI(uri string, maxBytes int64) ([]byte, error) {
+	header, payload, ok := strings.Cut(uri[5:], ",")
+	if !ok {
+		return nil, fmt.Errorf("%w: data URI has no comma", ErrInvalidSource)
+	}
+
+	isBase64 := false
+	if rest, found := strings.CutSuffix(header, ";base64"); found {
+		header, isBase64 = rest, true
+	}
+	if header != "" && !strings.HasPrefix(header, ";") {
+		if _, _, err := mime.ParseMediaType(header); err != nil {
+			return nil, fmt.Errorf("%w: data URI media type: %v", ErrInvalidSource, err)
+		}
+	}
+
+	// Even a base64 payload may itself be percent-encoded
+	if strings.Contains(payload, "%") {
+		unescaped, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: data URI payload: %v", ErrInvalidSource, err)
+		}
+		payload = unescaped
+	}
+
+	if !isBase64 {
+		if maxBytes > 0 && int64(len(payload)) > maxBytes {
+			return nil, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrTooLarge, len(payload), maxBytes)
+		}
+		return []byte(payload), nil
+	}
+
+	payload = strings.TrimRight(payload, "=")
+	if maxBytes > 0 {
+		if n := int64(base64.RawStdEncoding.DecodedLen(len(payload))); n > maxBytes {
+			return nil, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrTooLarge, n, maxBytes)
+		}
+	}
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		// Some encoders use the URL-safe alphabet
+		data, err = base64.RawURLEncoding.DecodeString(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: data URI payload: %v", ErrInvalidSource, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package imx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"imx/formats"
+)
+
+// DetectFormat identifies the image format from the leading bytes of r
+// without extracting any metadata. It returns ErrUnsupportedFormat when no
+// signature matches, also wrapping ErrUnsupportedContainer for containers
+// such as AVI that hold something other than an image.
+//
+// The header is peeked through a bufio.Reader. Pass a *bufio.Reader to keep
+// the peeked bytes available for later reads; any other reader has up to
+// formats.MaxHeaderSize bytes consumed. Formats recognized only by their
+// footer, such as TGA, need DetectFormatFromBytes or a full extraction.
+//
+// Only CR2 among the camera RAW formats has a header signature of its own.
+// NEF, ARW and DNG files are TIFF files told apart by their IFD0 tags, so
+// DetectFormat reports them as FormatTIFF while extraction reports the RAW
+// format.
+func DetectFormat(r io.Reader) (Format, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, formats.MaxHeaderSize)
+	}
+
+	header, err := br.Peek(formats.MaxHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+
+	format := Format(formats.Detect(header))
+	if format == "" {
+		return "", unsupportedFormatError(header)
+	}
+	return format, nil
+}
+
+// DetectFormatFromBytes identifies the image format of data, checking
+// footer signatures when the header is not recognized. It returns an empty
+// Format when nothing matches. Like DetectFormat, it reports NEF, ARW and
+// DNG files as FormatTIFF.
+func DetectFormatFromBytes(data []byte) Format {
+	header := data
+	if len(header) > formats.MaxHeaderSize {
+		header = header[:formats.MaxHeaderSize]
+	}
+	if format := formats.Detect(header); format != "" {
+		return Format(format)
+	}
+
+	format, err := formats.DetectTrailer(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	return Format(format)
+}
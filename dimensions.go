@@ -0,0 +1,149 @@
+package imx
+
+import "strconv"
+
+// Megapixels returns the pixel count in millions, or 0 when the dimensions
+// are unknown.
+func (md *ImageMetadata) Megapixels() float64 {
+	if md.Width <= 0 || md.Height <= 0 {
+		return 0
+	}
+	return float64(md.Width) * float64(md.Height) / 1e6
+}
+
+// AspectRatio returns the width-to-height ratio reduced to lowest terms,
+// e.g. 16, 9 for a 1920x1080 image. Both values are 0 when the dimensions
+// are unknown.
+func (md *ImageMetadata) AspectRatio() (w, h int) {
+	if md.Width <= 0 || md.Height <= 0 {
+		return 0, 0
+	}
+	d := gcd(md.Width, md.Height)
+	return md.Width / d, md.Height / d
+}
+
+// AspectRatioString formats AspectRatio as "w:h", e.g. "16:9". It returns an
+// empty string when the dimensions are unknown.
+func (md *ImageMetadata) AspectRatioString() string {
+	w, h := md.AspectRatio()
+	if w == 0 {
+		return ""
+	}
+	return strconv.Itoa(w) + ":" + strconv.Itoa(h)
+}
+
+// DisplayDimensions returns the width and height as the image is meant to
+// be displayed. EXIF orientations 5 to 8 rotate the image by 90 degrees, so
+// the stored Width and Height are swapped for them.
+func (md *ImageMetadata) DisplayDimensions() (w, h int) {
+	orientation := md.Orientation
+	if orientation == OrientationUnknown {
+		if v, ok := md.EXIFInt("Orientation"); ok {
+			orientation = Orientation(v)
+		}
+	}
+	if orientation >= 5 && orientation <= 8 {
+		return md.Height, md.Width
+	}
+	return md.Width, md.Height
+}
+
+// setOrientation fills Orientation from EXIF, or from the orientation a
+// JPEG XL header stores in Additional. Values outside 1 to 8 are ignored.
+func (md *ImageMetadata) setOrientation() {
+	v, ok := md.EXIFInt("Orientation")
+	if !ok {
+		v, ok = md.AdditionalInt("Orientation")
+	}
+	if ok && v >= 1 && v <= 8 {
+		md.Orientation = Orientation(v)
+	}
+}
+
+// Unit is a length unit for PrintSize.
+type Unit int
+
+const (
+	UnitInch Unit = iota
+	UnitCentimeter
+	UnitMillimeter
+)
+
+// unitsPerInch converts inches to each Unit.
+var unitsPerInch = map[Unit]float64{
+	UnitInch:       1,
+	UnitCentimeter: 2.54,
+	UnitMillimeter: 25.4,
+}
+
+// PrintSize returns the physical size of the image at its stored
+// resolution, Width/DPIX by Height/DPIY, in unit. ok is false when the
+// resolution or the dimensions are unknown, or unit is not one of the Unit
+// constants.
+func (md *ImageMetadata) PrintSize(unit Unit) (w, h float64, ok bool) {
+	scale, known := unitsPerInch[unit]
+	if !known || md.DPIX <= 0 || md.DPIY <= 0 || md.Width <= 0 || md.Height <= 0 {
+		return 0, 0, false
+	}
+	return float64(md.Width) / md.DPIX * scale, float64(md.Height) / md.DPIY * scale, true
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// setResolution fills DPIX and DPIY from the EXIF resolution tags, falling
+// back to the format's own density: JFIF, PNG pHYs, BMP pixels per meter
+// or the PCX header.
+func (md *ImageMetadata) setResolution() {
+	if x, y, ok := md.exifResolution(); ok {
+		md.DPIX, md.DPIY = x, y
+		return
+	}
+
+	// Metric densities convert exactly rather than from the rounded DPI
+	for _, keys := range [][2]string{{"PixelsPerUnitX", "PixelsPerUnitY"}, {"XPixelsPerMeter", "YPixelsPerMeter"}} {
+		x, okX := md.AdditionalFloat(keys[0])
+		y, okY := md.AdditionalFloat(keys[1])
+		if _, meters := md.Additional["DPIX"]; meters && okX && okY && x > 0 && y > 0 {
+			md.DPIX, md.DPIY = x*0.0254, y*0.0254
+			return
+		}
+	}
+	if unit, _ := md.AdditionalString("DensityUnit"); unit == "dpcm" {
+		x, _ := md.AdditionalFloat("XDensity")
+		y, _ := md.AdditionalFloat("YDensity")
+		md.DPIX, md.DPIY = x*2.54, y*2.54
+		return
+	}
+
+	x, okX := md.AdditionalFloat("DPIX")
+	y, okY := md.AdditionalFloat("DPIY")
+	if okX && okY && x > 0 && y > 0 {
+		md.DPIX, md.DPIY = x, y
+	}
+}
+
+// exifResolution converts XResolution and YResolution to dots per inch
+// using ResolutionUnit, which defaults to inches.
+func (md *ImageMetadata) exifResolution() (x, y float64, ok bool) {
+	x, okX := md.EXIFFloat("XResolution")
+	y, okY := md.EXIFFloat("YResolution")
+	if !okX || !okY || x <= 0 || y <= 0 {
+		return 0, 0, false
+	}
+	unit, ok := md.EXIFInt("ResolutionUnit")
+	if !ok {
+		unit = 2
+	}
+	switch unit {
+	case 2: // inches
+		return x, y, true
+	case 3: // centimeters
+		return x * 2.54, y * 2.54, true
+	}
+	return 0, 0, false
+}
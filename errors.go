@@ -6,9 +6,32 @@ var (
 	// ErrUnsupportedFormat is returned when the image format cannot be detected.
 	ErrUnsupportedFormat = errors.New("imx: unsupported format")
 
+	// ErrUnsupportedContainer is returned alongside ErrUnsupportedFormat when
+	// the input is a known container holding something other than an image,
+	// such as an AVI or WAVE file in a RIFF container.
+	ErrUnsupportedContainer = errors.New("imx: unsupported container")
+
 	// ErrInvalidSource is returned when the provided data source cannot be read.
 	ErrInvalidSource = errors.New("imx: invalid source")
 
 	// ErrFetchFailed indicates that fetching a remote resource failed.
 	ErrFetchFailed = errors.New("imx: fetch failed")
+
+	// ErrFetchLimit is returned by MetadataFromURL when the metadata extends
+	// past the prefix downloaded under the WithFetchLimit cap.
+	ErrFetchLimit = errors.New("imx: metadata beyond fetch limit")
+
+	// ErrTooLarge is returned when the input exceeds the WithMaxBytes limit.
+	ErrTooLarge = errors.New("imx: input too large")
+
+	// ErrPartial is returned together with the metadata read before a parse
+	// error, e.g. the dimensions of a JPEG that is corrupt further on.
+	// Callers that want all or nothing can treat it like any other error.
+	ErrPartial = errors.New("imx: partial metadata")
+
+	// ErrNoThumbnail is returned when the image has no embedded EXIF thumbnail.
+	ErrNoThumbnail = errors.New("imx: no embedded thumbnail")
+
+	// ErrNoPreview is returned when a camera RAW file has no embedded JPEG preview.
+	ErrNoPreview = errors.New("imx: no embedded preview")
 )
@@ -32,6 +32,9 @@ func main() {
 	fmt.Printf("Color Depth: %d-bit\n", md.ColorDepth)
 	fmt.Printf("Color Space: %s\n", md.ColorSpace)
 	fmt.Printf("Has ICC Profile: %t\n", md.HasICCProfile)
+	if md.IsAnimated {
+		fmt.Printf("Animation: %d frames, %s, loop count %d\n", md.FrameCount, md.Duration, md.LoopCount)
+	}
 
 	// Helper to pretty-print maps if data exists.
 	printMap := func(title string, data map[string]interface{}) {
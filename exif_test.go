@@ -0,0 +1,1160 @@
+package imx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"imx/formats"
+)
+
+// testIFD describes an Image File Directory for buildTIFF
+type testIFD struct {
+	entries []testEntry
+	next    *testIFD
+	header  []byte // written before the IFD; pointers address the header
+}
+
+// testEntry is a single IFD entry; sub turns it into a pointer to another IFD
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+	sub   *testIFD
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+func shortEntry(tag uint16, v uint16) testEntry {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, v)
+	return testEntry{tag: tag, typ: 3, count: 1, data: data}
+}
+
+func shortsEntry(tag uint16, vals ...uint16) testEntry {
+	data := make([]byte, 2*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint16(data[i*2:], v)
+	}
+	return testEntry{tag: tag, typ: 3, count: uint32(len(vals)), data: data}
+}
+
+func longEntry(tag uint16, v uint32) testEntry {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return testEntry{tag: tag, typ: 4, count: 1, data: data}
+}
+
+func rationalEntry(tag uint16, pairs ...uint32) testEntry {
+	data := make([]byte, 4*len(pairs))
+	for i, v := range pairs {
+		binary.LittleEndian.PutUint32(data[i*4:], v)
+	}
+	return testEntry{tag: tag, typ: 5, count: uint32(len(pairs) / 2), data: data}
+}
+
+func undefinedEntry(tag uint16, b []byte) testEntry {
+	return testEntry{tag: tag, typ: 7, count: uint32(len(b)), data: b}
+}
+
+func byteEntry(tag uint16, b []byte) testEntry {
+	return testEntry{tag: tag, typ: 1, count: uint32(len(b)), data: b}
+}
+
+func pointerEntry(tag uint16, sub *testIFD) testEntry {
+	return testEntry{tag: tag, typ: 4, count: 1, sub: sub}
+}
+
+// buildTIFF lays out a little-endian TIFF structure starting at IFD0
+func buildTIFF(ifd0 *testIFD) []byte {
+	buf := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	return writeTestIFD(buf, ifd0)
+}
+
+func writeTestIFD(buf []byte, ifd *testIFD) []byte {
+	buf = append(buf, ifd.header...)
+	start := len(buf)
+	n := len(ifd.entries)
+	buf = append(buf, make([]byte, 2+12*n+4)...)
+	binary.LittleEndian.PutUint16(buf[start:], uint16(n))
+
+	for i, e := range ifd.entries {
+		p := start + 2 + 12*i
+		binary.LittleEndian.PutUint16(buf[p:], e.tag)
+		binary.LittleEndian.PutUint16(buf[p+2:], e.typ)
+		binary.LittleEndian.PutUint32(buf[p+4:], e.count)
+
+		switch {
+		case e.sub != nil:
+			off := len(buf)
+			buf = writeTestIFD(buf, e.sub)
+			binary.LittleEndian.PutUint32(buf[p+8:], uint32(off))
+		case len(e.data) <= 4:
+			copy(buf[p+8:p+12], e.data)
+		default:
+			off := len(buf)
+			buf = append(buf, e.data...)
+			if len(buf)%2 == 1 {
+				buf = append(buf, 0)
+			}
+			binary.LittleEndian.PutUint32(buf[p+8:], uint32(off))
+		}
+	}
+
+	if ifd.next != nil {
+		off := len(buf)
+		buf = writeTestIFD(buf, ifd.next)
+		binary.LittleEndian.PutUint32(buf[start+2+12*n:], uint32(off))
+	}
+
+	return buf
+}
+
+// createJPEGWithEXIF embeds a TIFF structure in an APP1 segment of the minimal JPEG
+func createJPEGWithEXIF(tiff []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	base := createMinimalJPEG()
+	jpeg := append([]byte{}, base[:2]...)
+	jpeg = append(jpeg, app1...)
+	return append(jpeg, base[2:]...)
+}
+
+// selfReferencingTIFF builds a Canon EXIF block whose ExifIFD, GPS and
+// Interop pointers, MakerNote and next-IFD link all lead back to IFD0
+func selfReferencingTIFF() []byte {
+	entries := []testEntry{asciiEntry(0x010F, "Canon")}
+	for i := 0; i < 4; i++ {
+		entries = append(entries,
+			pointerEntry(0x8769, &testIFD{}),
+			pointerEntry(0x8825, &testIFD{}),
+			pointerEntry(0xA005, &testIFD{}),
+			undefinedEntry(0x927C, make([]byte, 16)),
+		)
+	}
+	tiff := buildTIFF(&testIFD{entries: entries})
+	for i := 1; i < len(entries); i++ {
+		binary.LittleEndian.PutUint32(tiff[8+2+12*i+8:], 8)
+	}
+	binary.LittleEndian.PutUint32(tiff[8+2+12*len(entries):], 8)
+	return tiff
+}
+
+// fanOutTIFF builds a chain of ten IFDs, each pointing at the next six times
+func fanOutTIFF() []byte {
+	const depth, fanOut = 10, 6
+	size := 2 + 12*fanOut + 4
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	for k := 0; k < depth; k++ {
+		ifd := make([]byte, size)
+		binary.LittleEndian.PutUint16(ifd, fanOut)
+		for i := 0; i < fanOut; i++ {
+			p := ifd[2+12*i:]
+			if k == depth-1 {
+				binary.LittleEndian.PutUint16(p, 0x0112)
+				binary.LittleEndian.PutUint16(p[2:], 3)
+				binary.LittleEndian.PutUint32(p[4:], 1)
+				binary.LittleEndian.PutUint16(p[8:], 1)
+				continue
+			}
+			binary.LittleEndian.PutUint16(p, []uint16{0x8769, 0x8825, 0xA005}[i%3])
+			binary.LittleEndian.PutUint16(p[2:], 4)
+			binary.LittleEndian.PutUint32(p[4:], 1)
+			binary.LittleEndian.PutUint32(p[8:], uint32(8+size*(k+1)))
+		}
+		tiff = append(tiff, ifd...)
+	}
+	return tiff
+}
+
+func TestEXIF_GPSTextTags(t *testing.T) {
+	gps := &testIFD{entries: []testEntry{
+		undefinedEntry(0x001B, []byte("ASCII\x00\x00\x00CELLID\x00")),
+		undefinedEntry(0x001C, []byte("UNICODE\x00B\x00e\x00r\x00n\x00")),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"),
+		pointerEntry(0x8825, gps),
+	}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	if got := md.EXIF["Make"]; got != "Canon" {
+		t.Errorf("Make = %v, want Canon", got)
+	}
+	if got := md.EXIF["GPSProcessingMethod"]; got != "CELLID" {
+		t.Errorf("GPSProcessingMethod = %q, want CELLID", got)
+	}
+	if got := md.EXIF["GPSAreaInformation"]; got != "Bern" {
+		t.Errorf("GPSAreaInformation = %q, want Bern", got)
+	}
+}
+
+func TestEXIF_GPSCoordinates(t *testing.T) {
+	gps := &testIFD{entries: []testEntry{
+		asciiEntry(0x0001, "N"),
+		rationalEntry(0x0002, 46, 1, 57, 1, 3648, 100),
+		asciiEntry(0x0003, "W"),
+		rationalEntry(0x0004, 7, 1, 26, 1, 2256, 100),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8825, gps)}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	lat, ok := md.EXIF["GPSLatitude"].(float64)
+	if want := 46 + 57.0/60 + 36.48/3600; !ok || math.Abs(lat-want) > 1e-9 {
+		t.Errorf("GPSLatitude = %v, want %v", md.EXIF["GPSLatitude"], want)
+	}
+	lon, ok := md.EXIF["GPSLongitude"].(float64)
+	if want := -(7 + 26.0/60 + 22.56/3600); !ok || math.Abs(lon-want) > 1e-9 {
+		t.Errorf("GPSLongitude = %v, want %v", md.EXIF["GPSLongitude"], want)
+	}
+	if dms, ok := md.EXIF["GPSLatitudeDMS"].([]Rational); !ok || len(dms) != 3 || dms[2] != (Rational{Num: 3648, Den: 100}) {
+		t.Errorf("GPSLatitudeDMS = %v, want [46/1 57/1 3648/100]", md.EXIF["GPSLatitudeDMS"])
+	}
+}
+
+func TestImageMetadata_GeoURI(t *testing.T) {
+	position := []testEntry{
+		asciiEntry(0x0001, "N"),
+		rationalEntry(0x0002, 46, 1, 57, 1, 3648, 100),
+		asciiEntry(0x0003, "W"),
+		rationalEntry(0x0004, 7, 1, 26, 1, 2256, 100),
+	}
+	tests := []struct {
+		name    string
+		entries []testEntry
+		geo     string
+		maps    string
+	}{
+		{"position", position, "geo:46.960133,-7.439600", "https://maps.google.com/?q=46.960133,-7.439600"},
+		{
+			"with altitude",
+			append(append([]testEntry(nil), position...), rationalEntry(0x0006, 5405, 10)),
+			"geo:46.960133,-7.439600,540.5",
+			"https://maps.google.com/?q=46.960133,-7.439600",
+		},
+		{"latitude only", position[:2], "", ""},
+		{"zero denominator", []testEntry{rationalEntry(0x0002, 46, 0, 0, 1, 0, 1), rationalEntry(0x0004, 7, 1, 0, 1, 0, 1)}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8825, &testIFD{entries: tt.entries})}})
+			md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			geo, ok := md.GeoURI()
+			if geo != tt.geo || ok != (tt.geo != "") {
+				t.Errorf("GeoURI() = %q, %v, want %q", geo, ok, tt.geo)
+			}
+			maps, ok := md.GoogleMapsURL()
+			if maps != tt.maps || ok != (tt.maps != "") {
+				t.Errorf("GoogleMapsURL() = %q, %v, want %q", maps, ok, tt.maps)
+			}
+		})
+	}
+}
+
+func TestEXIF_GPSAltitudeTimeSpeed(t *testing.T) {
+	gps := &testIFD{entries: []testEntry{
+		byteEntry(0x0005, []byte{1}),
+		rationalEntry(0x0006, 125, 10),
+		rationalEntry(0x0007, 14, 1, 5, 1, 30, 1),
+		asciiEntry(0x000C, "N"),
+		rationalEntry(0x000D, 10, 1),
+		asciiEntry(0x001D, "2024:03:09"),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8825, gps)}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	if got := md.EXIF["GPSAltitude"]; got != -12.5 {
+		t.Errorf("GPSAltitude = %v, want -12.5", got)
+	}
+	if got := md.EXIF["GPSDateTime"]; got != "2024-03-09T14:05:30Z" {
+		t.Errorf("GPSDateTime = %v, want 2024-03-09T14:05:30Z", got)
+	}
+	if got, ok := md.EXIF["GPSSpeed"].(float64); !ok || math.Abs(got-18.52) > 1e-9 {
+		t.Errorf("GPSSpeed = %v, want 18.52", md.EXIF["GPSSpeed"])
+	}
+
+	// Without a ref, altitude is above sea level and speed is in km/h
+	gps.entries = []testEntry{rationalEntry(0x0006, 300, 1), rationalEntry(0x000D, 40, 1)}
+	md, err = MetadataFromBytes(createJPEGWithEXIF(buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8825, gps)}})))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if got := md.EXIF["GPSAltitude"]; got != 300.0 {
+		t.Errorf("GPSAltitude = %v, want 300", got)
+	}
+	if got := md.EXIF["GPSSpeed"]; got != 40.0 {
+		t.Errorf("GPSSpeed = %v, want 40", got)
+	}
+}
+
+func TestEXIF_ThumbnailIFD(t *testing.T) {
+	ifd0 := &testIFD{
+		entries: []testEntry{asciiEntry(0x010F, "Canon")},
+		next: &testIFD{entries: []testEntry{
+			shortEntry(0x0103, 6),
+			longEntry(0x0201, 4096),
+			longEntry(0x0202, 1234),
+		}},
+	}
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(buildTIFF(ifd0)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	ifd1, ok := md.EXIF["IFD1"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("IFD1 = %v, want a map", md.EXIF["IFD1"])
+	}
+	if ifd1["ThumbnailOffset"] != uint32(4096) || ifd1["ThumbnailLength"] != uint32(1234) || ifd1["Compression"] != uint16(6) {
+		t.Errorf("IFD1 = %v", ifd1)
+	}
+	if _, ok := md.EXIF["ThumbnailOffset"]; ok {
+		t.Error("IFD1 tags leaked into IFD0")
+	}
+
+	// A next-IFD pointer back to IFD0 is not followed
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon")}})
+	binary.LittleEndian.PutUint32(tiff[8+2+12:], 8)
+	md, err = MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.EXIF["IFD1"]; ok {
+		t.Error("IFD1 parsed from a pointer back to IFD0")
+	}
+}
+
+func TestImageMetadata_Thumbnail(t *testing.T) {
+	thumb := []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xD9}
+	thumbIFD := func(offset uint32) []byte {
+		return buildTIFF(&testIFD{
+			entries: []testEntry{asciiEntry(0x010F, "Canon")},
+			next: &testIFD{entries: []testEntry{
+				shortEntry(0x0103, 6),
+				longEntry(0x0201, offset),
+				longEntry(0x0202, uint32(len(thumb))),
+			}},
+		})
+	}
+	// The layout does not depend on the offset value, so the thumbnail can go at the end
+	tiff := thumbIFD(uint32(len(thumbIFD(0))))
+	tiff = append(tiff, thumb...)
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	got, err := md.Thumbnail()
+	if err != nil {
+		t.Fatalf("Thumbnail() error = %v", err)
+	}
+	if !bytes.Equal(got, thumb) {
+		t.Errorf("Thumbnail() = % X, want % X", got, thumb)
+	}
+
+	// Offsets past the end of the EXIF data are an error
+	md, err = MetadataFromBytes(createJPEGWithEXIF(thumbIFD(1 << 20)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, err := md.Thumbnail(); err == nil || errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("Thumbnail() error = %v, want out of range error", err)
+	}
+
+	md, err = MetadataFromBytes(createMinimalJPEG())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, err := md.Thumbnail(); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("Thumbnail() error = %v, want ErrNoThumbnail", err)
+	}
+}
+
+func TestEXIF_Rationals(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		rationalEntry(0x829A, 1, 200),
+		rationalEntry(0x829D, 28, 0),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	exposure, ok := md.EXIF["ExposureTime"].(Rational)
+	if !ok || exposure != (Rational{Num: 1, Den: 200}) {
+		t.Fatalf("ExposureTime = %#v, want 1/200", md.EXIF["ExposureTime"])
+	}
+	if exposure.Float64() != 0.005 {
+		t.Errorf("Float64() = %v, want 0.005", exposure.Float64())
+	}
+	if b, err := json.Marshal(exposure); err != nil || string(b) != `"1/200"` {
+		t.Errorf("json.Marshal() = %s, %v, want \"1/200\"", b, err)
+	}
+	if got := md.FlatMap()["EXIF.ExposureTime"]; got != "1/200" {
+		t.Errorf("FlatMap EXIF.ExposureTime = %q, want 1/200", got)
+	}
+
+	// A zero denominator is preserved rather than collapsed to 0
+	fNumber, ok := md.EXIF["FNumber"].(Rational)
+	if !ok || fNumber.Den != 0 || !math.IsInf(fNumber.Float64(), 1) {
+		t.Errorf("FNumber = %#v, want 28/0", md.EXIF["FNumber"])
+	}
+}
+
+func TestEXIF_APEXValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		tv           testEntry
+		av           testEntry
+		shutter      string
+		aperture     string
+		wantRational bool
+	}{
+		{
+			name:     "1/250 at f/2.8",
+			tv:       testEntry{tag: 0x9201, typ: 10, count: 1, data: []byte{0x1F, 0x1F, 0, 0, 0xE8, 0x03, 0, 0}}, // 7967/1000
+			av:       rationalEntry(0x9202, 3, 1),
+			shutter:  "1/250",
+			aperture: "f/2.8",
+		},
+		{
+			name:     "long exposure",
+			tv:       testEntry{tag: 0x9201, typ: 10, count: 1, data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 1, 0, 0, 0}}, // -1/1
+			av:       rationalEntry(0x9202, 6, 1),
+			shutter:  "2",
+			aperture: "f/8.0",
+		},
+		{
+			name:         "unsigned shutter speed",
+			tv:           rationalEntry(0x9201, 6, 1),
+			av:           rationalEntry(0x9202, 0, 1),
+			shutter:      "1/64",
+			aperture:     "f/1.0",
+			wantRational: true,
+		},
+		{
+			name: "zero denominators",
+			tv:   testEntry{tag: 0x9201, typ: 10, count: 1, data: make([]byte, 8)},
+			av:   rationalEntry(0x9202, 3, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exifIFD := &testIFD{entries: []testEntry{tt.tv, tt.av}}
+			tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+			md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			shutter, _ := md.EXIF["ShutterSpeed"].(string)
+			aperture, _ := md.EXIF["Aperture"].(string)
+			if shutter != tt.shutter || aperture != tt.aperture {
+				t.Errorf("ShutterSpeed, Aperture = %q, %q, want %q, %q", shutter, aperture, tt.shutter, tt.aperture)
+			}
+			// The raw values are kept
+			if _, ok := md.EXIF["ApertureValue"].(Rational); !ok {
+				t.Errorf("ApertureValue = %#v, want a Rational", md.EXIF["ApertureValue"])
+			}
+			if _, ok := md.EXIF["ShutterSpeedValue"].(Rational); ok != tt.wantRational {
+				t.Errorf("ShutterSpeedValue = %#v", md.EXIF["ShutterSpeedValue"])
+			}
+		})
+	}
+}
+
+func TestImageMetadata_EXIFFormatted(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		rationalEntry(0x829A, 1, 200),
+		rationalEntry(0x829D, 28, 10),
+		shortEntry(0x8827, 100),
+		{tag: 0x9201, typ: 10, count: 1, data: []byte{0x1F, 0x1F, 0, 0, 0xE8, 0x03, 0, 0}},
+		{tag: 0x9204, typ: 10, count: 1, data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 3, 0, 0, 0}},
+		shortEntry(0x9207, 5),
+		shortEntry(0x9209, 0x10),
+		rationalEntry(0x920A, 50, 1),
+		shortEntry(0xA405, 75),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6), pointerEntry(0x8769, exifIFD),
+	}})
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	got := md.EXIFFormatted()
+	want := map[string]string{
+		"Make":                  "Canon",
+		"Orientation":           "Rotate 90 CW",
+		"ExposureTime":          "1/200",
+		"FNumber":               "f/2.8",
+		"ISO":                   "100",
+		"ShutterSpeedValue":     "1/250",
+		"ExposureBiasValue":     "-0.33 EV",
+		"MeteringMode":          "Multi-segment",
+		"Flash":                 "Off, Did not fire",
+		"FocalLength":           "50.0 mm",
+		"FocalLengthIn35mmFilm": "75 mm",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("EXIFFormatted()[%q] = %q, want %q", key, got[key], value)
+		}
+	}
+	for _, key := range []string{"OrientationDescription", "ShutterSpeed"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("EXIFFormatted() kept derived key %q", key)
+		}
+	}
+
+	// The raw values are untouched
+	if md.EXIF["Flash"] != uint16(0x10) || md.EXIF["FlashDescription"] != "Off, Did not fire" {
+		t.Errorf("EXIF Flash = %#v, %#v", md.EXIF["Flash"], md.EXIF["FlashDescription"])
+	}
+	if _, ok := md.EXIF["ExposureTime"].(Rational); !ok {
+		t.Errorf("EXIF ExposureTime = %#v, want a Rational", md.EXIF["ExposureTime"])
+	}
+}
+
+func TestEXIF_NULSeparatedASCII(t *testing.T) {
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"),
+		{tag: 0x0110, typ: 2, count: 8, data: []byte("EOS R\x00\x00\x00")},
+		{tag: 0x8298, typ: 2, count: 17, data: []byte("J. Doe\x00ACME Ltd\x00\x00")},
+		{tag: 0x013B, typ: 2, count: 5, data: []byte("\x00Ann\x00")},
+	}})
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	if md.EXIF["Make"] != "Canon" || md.EXIF["Model"] != "EOS R" {
+		t.Errorf("Make, Model = %#v, %#v, want plain strings", md.EXIF["Make"], md.EXIF["Model"])
+	}
+	copyright, ok := md.EXIF["Copyright"].([]string)
+	if !ok || len(copyright) != 2 || copyright[0] != "J. Doe" || copyright[1] != "ACME Ltd" {
+		t.Errorf("Copyright = %#v, want [J. Doe ACME Ltd]", md.EXIF["Copyright"])
+	}
+	// Empty entries are dropped, leaving a single string
+	if md.EXIF["Artist"] != "Ann" {
+		t.Errorf("Artist = %#v, want Ann", md.EXIF["Artist"])
+	}
+	if got := md.EXIFFormatted()["Copyright"]; got != "J. Doe, ACME Ltd" {
+		t.Errorf("EXIFFormatted Copyright = %q", got)
+	}
+}
+
+func TestEXIF_UndefinedTags(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		undefinedEntry(0x9000, []byte("0230")),
+		undefinedEntry(0x9101, []byte{1, 2, 3, 0}),
+		undefinedEntry(0xA000, []byte("0100")),
+		undefinedEntry(0xA302, []byte{0xDE, 0xAD, 0xBE, 0xEF, 0x00, 0x01}),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	if md.EXIF["ExifVersion"] != "0230" || md.EXIF["FlashpixVersion"] != "0100" {
+		t.Errorf("ExifVersion, FlashpixVersion = %#v, %#v, want 0230, 0100", md.EXIF["ExifVersion"], md.EXIF["FlashpixVersion"])
+	}
+	if md.EXIF["ComponentsConfiguration"] != "Y,Cb,Cr,-" {
+		t.Errorf("ComponentsConfiguration = %#v, want Y,Cb,Cr,-", md.EXIF["ComponentsConfiguration"])
+	}
+
+	pattern, ok := md.EXIF["CFAPattern"].(Undefined)
+	if !ok || pattern.Size() != 6 || pattern.Hex() != "deadbeef0001" {
+		t.Fatalf("CFAPattern = %#v, want a 6-byte Undefined", md.EXIF["CFAPattern"])
+	}
+	if b, err := json.Marshal(pattern); err != nil || string(b) != `{"size":6,"hex":"deadbeef0001"}` {
+		t.Errorf("json.Marshal() = %s, %v", b, err)
+	}
+	if got := md.FlatMap()["EXIF.CFAPattern"]; got != "deadbeef0001" {
+		t.Errorf("FlatMap EXIF.CFAPattern = %q", got)
+	}
+}
+
+func TestEXIF_InteropIFD(t *testing.T) {
+	interop := &testIFD{entries: []testEntry{
+		asciiEntry(0x0001, "R98"),
+		undefinedEntry(0x0002, []byte("0100")),
+	}}
+	exifIFD := &testIFD{entries: []testEntry{undefinedEntry(0x9000, []byte("0231")), pointerEntry(0xA005, interop)}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	got, ok := md.EXIF["Interop"].(map[string]interface{})
+	if !ok || got["InteropIndex"] != "R98" || got["InteropVersion"] != "0100" {
+		t.Fatalf("Interop = %#v, want InteropIndex R98 and InteropVersion 0100", md.EXIF["Interop"])
+	}
+	// Interop tag IDs overlap GPS ones, so they stay out of the top level
+	if _, ok := md.EXIF["GPSLatitudeRef"]; ok {
+		t.Error("InteropIndex read as GPSLatitudeRef")
+	}
+	if got := md.FlatMap()["EXIF.Interop.InteropIndex"]; got != "R98" {
+		t.Errorf("FlatMap EXIF.Interop.InteropIndex = %q", got)
+	}
+	if err := Validate(bytes.NewReader(createJPEGWithSOFAndEXIF(tiff))); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestEXIF_GroupedOutput(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		rationalEntry(0x829A, 1, 200),
+		asciiEntry(0x9003, "2024:03:09 14:05:30"),
+		pointerEntry(0xA005, &testIFD{entries: []testEntry{asciiEntry(0x0001, "R98")}}),
+	}}
+	gps := &testIFD{entries: []testEntry{
+		asciiEntry(0x0001, "N"),
+		rationalEntry(0x0002, 46, 1, 57, 1, 3648, 100),
+		asciiEntry(0x0003, "E"),
+		rationalEntry(0x0004, 7, 1, 26, 1, 2256, 100),
+	}}
+	tiff := buildTIFF(&testIFD{
+		entries: []testEntry{
+			shortEntry(0x0100, 4000), asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6),
+			pointerEntry(0x8769, exifIFD), pointerEntry(0x8825, gps),
+		},
+		next: &testIFD{entries: []testEntry{shortEntry(0x0100, 160), shortEntry(0x0103, 6)}},
+	})
+	data := createJPEGWithEXIF(tiff)
+
+	md, err := MetadataFromBytes(data, WithGroupedEXIF())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	group := func(name string) map[string]interface{} {
+		g, ok := md.EXIF[name].(map[string]interface{})
+		if !ok {
+			t.Fatalf("EXIF[%q] = %#v, want a map", name, md.EXIF[name])
+		}
+		return g
+	}
+	// ImageWidth appears in both IFD0 and IFD1 without colliding
+	if ifd0 := group("IFD0"); ifd0["Make"] != "Canon" || ifd0["ImageWidth"] != uint16(4000) || ifd0["ExposureTime"] != nil {
+		t.Errorf("IFD0 = %v", ifd0)
+	}
+	if ifd1 := group("IFD1"); ifd1["ImageWidth"] != uint16(160) {
+		t.Errorf("IFD1 = %v", ifd1)
+	}
+	if exif := group("ExifIFD"); exif["ExposureTime"] != (Rational{Num: 1, Den: 200}) || exif["Make"] != nil {
+		t.Errorf("ExifIFD = %v", exif)
+	}
+	if _, ok := group("GPS")["GPSLatitude"].(float64); !ok {
+		t.Errorf("GPS = %v", group("GPS"))
+	}
+	if interop := group("Interop"); interop["InteropIndex"] != "R98" {
+		t.Errorf("Interop = %v", interop)
+	}
+
+	// Accessors and derived fields read through the groups
+	if got, _ := md.EXIFString("Make"); got != "Canon" || md.Orientation != OrientationRotate90 {
+		t.Errorf("EXIFString(Make) = %q, Orientation = %v", got, md.Orientation)
+	}
+	if _, ok := md.DateTimeOriginal(); !ok {
+		t.Error("DateTimeOriginal() not found in ExifIFD")
+	}
+	if _, ok := md.GeoURI(); !ok {
+		t.Error("GeoURI() not found in GPS")
+	}
+
+	// The flat view matches the default output
+	flat, err := MetadataFromBytes(data)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if got, want := fmt.Sprint(md.FlatEXIF()), fmt.Sprint(flat.EXIF); got != want {
+		t.Errorf("FlatEXIF() = %s, want %s", got, want)
+	}
+}
+
+func TestImageMetadata_EffectiveFocalLength(t *testing.T) {
+	// An APS-C sensor of 22.3x14.9 mm recording 6000x4000 pixels
+	apsc := []testEntry{
+		rationalEntry(0x920A, 50, 1),
+		shortEntry(0xA002, 6000),
+		shortEntry(0xA003, 4000),
+		rationalEntry(0xA20E, 6834080, 1000),
+		rationalEntry(0xA20F, 6818792, 1000),
+		shortEntry(0xA210, 2),
+	}
+	tests := []struct {
+		name    string
+		entries []testEntry
+		want    float64
+		ok      bool
+	}{
+		{"recorded", []testEntry{rationalEntry(0x920A, 50, 1), shortEntry(0xA405, 75)}, 75, true},
+		{"derived", apsc, 50 * math.Hypot(36, 24) / math.Hypot(22.3, 14.9), true},
+		{
+			"derived in centimeters",
+			[]testEntry{rationalEntry(0x920A, 50, 1), shortEntry(0xA002, 6000), shortEntry(0xA003, 4000),
+				rationalEntry(0xA20E, 2690583, 1000), shortEntry(0xA210, 3)},
+			50 * math.Hypot(36, 24) / math.Hypot(22.3, 22.3*4000/6000),
+			true,
+		},
+		{"no sensor size", []testEntry{rationalEntry(0x920A, 50, 1)}, 0, false},
+		{"unknown unit", append(append([]testEntry(nil), apsc[:5]...), shortEntry(0xA210, 9)), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, &testIFD{entries: tt.entries})}})
+			md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			got, ok := md.EffectiveFocalLength()
+			if ok != tt.ok || math.Abs(got-tt.want) > 0.05 {
+				t.Errorf("EffectiveFocalLength() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEXIF_UserComment(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"ASCII", []byte("ASCII\x00\x00\x00Hello world   \x00"), "Hello world"},
+		{"Unicode", []byte("UNICODE\x00G\x00r\x00\xfc\x00\xdf\x00e\x00"), "Grüße"},
+		{"Undefined", append(make([]byte, 8), []byte("caf\xe9\x00\x00")...), "café"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exifIFD := &testIFD{entries: []testEntry{undefinedEntry(0x9286, tt.data)}}
+			tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+
+			md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if got := md.EXIF["UserComment"]; got != tt.want {
+				t.Errorf("UserComment = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEXIF_OrientationDescription(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  string
+	}{
+		{1, "Horizontal (normal)"},
+		{6, "Rotate 90 CW"},
+		{8, "Rotate 270 CW"},
+		{0, "Unknown"},
+		{9, "Unknown"},
+	}
+
+	for _, tt := range tests {
+		tiff := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0112, tt.value)}})
+		md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+		if err != nil {
+			t.Fatalf("MetadataFromBytes() error = %v", err)
+		}
+		if got := md.EXIF["Orientation"]; got != tt.value {
+			t.Errorf("Orientation = %v, want %d", got, tt.value)
+		}
+		if got := md.EXIF["OrientationDescription"]; got != tt.want {
+			t.Errorf("OrientationDescription(%d) = %v, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestImageMetadata_DateTimeOriginal(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		asciiEntry(0x9003, "2024:03:09 14:05:30"),
+		asciiEntry(0x9004, "2024:03:09 14:06:00"),
+		asciiEntry(0x9010, "+01:00"),
+		asciiEntry(0x9011, "+09:00"),
+		asciiEntry(0x9291, "25"),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x0132, "    :  :     :  :  "),
+		pointerEntry(0x8769, exifIFD),
+	}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	got, ok := md.DateTimeOriginal()
+	want := time.Date(2024, 3, 9, 14, 5, 30, 250_000_000, time.FixedZone("", 9*60*60))
+	if !ok || !got.Equal(want) {
+		t.Errorf("DateTimeOriginal() = %v, %v, want %v", got, ok, want)
+	}
+
+	// Falls back to OffsetTime when there is no OffsetTimeDigitized
+	got, ok = md.DateTimeDigitized()
+	want = time.Date(2024, 3, 9, 14, 6, 0, 0, time.FixedZone("", 60*60))
+	if !ok || !got.Equal(want) {
+		t.Errorf("DateTimeDigitized() = %v, %v, want %v", got, ok, want)
+	}
+
+	// Blank date fields are malformed
+	if got, ok := md.DateTime(); ok {
+		t.Errorf("DateTime() = %v, want ok=false", got)
+	}
+}
+
+func TestEXIF_TagDictionary(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		shortEntry(0x8822, 3),
+		rationalEntry(0x920A, 50, 1),
+		shortEntry(0xA001, 1),
+		longEntry(0xA002, 6000),
+		shortEntry(0xA405, 75),
+		asciiEntry(0xA433, "Canon"),
+		asciiEntry(0xA434, "EF50mm f/1.8 STM"),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"ExposureProgram":       uint16(3),
+		"FocalLength":           Rational{Num: 50, Den: 1},
+		"ColorSpace":            uint16(1),
+		"PixelXDimension":       uint32(6000),
+		"FocalLengthIn35mmFilm": uint16(75),
+		"LensMake":              "Canon",
+		"LensModel":             "EF50mm f/1.8 STM",
+	}
+	for name, value := range want {
+		if got := md.EXIF[name]; got != value {
+			t.Errorf("%s = %#v, want %#v", name, got, value)
+		}
+	}
+}
+
+func TestEXIF_EnumDescriptions(t *testing.T) {
+	exifIFD := &testIFD{entries: []testEntry{
+		shortEntry(0x8822, 3),
+		shortEntry(0x9207, 5),
+		shortEntry(0x9209, 0x59),
+		shortEntry(0xA403, 1),
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{pointerEntry(0x8769, exifIFD)}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	want := map[string]string{
+		"ExposureProgramDescription": "Aperture-priority AE",
+		"MeteringModeDescription":    "Multi-segment",
+		"FlashDescription":           "Auto, Fired, Red-eye reduction",
+		"WhiteBalanceDescription":    "Manual",
+	}
+	for name, value := range want {
+		if got := md.EXIF[name]; got != value {
+			t.Errorf("%s = %v, want %q", name, got, value)
+		}
+	}
+	if got := md.EXIF["Flash"]; got != uint16(0x59) {
+		t.Errorf("Flash = %v, want raw value 0x59", got)
+	}
+}
+
+func TestEXIF_CanonMakerNote(t *testing.T) {
+	settings := make([]uint16, 26)
+	settings[0] = 52
+	settings[1] = 2    // MacroMode: Normal
+	settings[7] = 1    // FocusMode: AI Servo AF
+	settings[22] = 61  // LensType
+	settings[23] = 105 // MaxFocalLength
+	settings[24] = 24  // MinFocalLength
+	settings[25] = 1   // FocalUnits
+
+	makerNote := &testIFD{entries: []testEntry{
+		shortsEntry(0x0001, settings...),
+		longEntry(0x0008, 1001234),
+		longEntry(0x000C, 123456789),
+		longEntry(0x0042, 7), // unknown tag
+		asciiEntry(0x0095, "EF24-105mm f/4L IS USM"),
+	}}
+	exifIFD := &testIFD{entries: []testEntry{
+		{tag: 0x927C, typ: 7, count: 2 + 12*5 + 4, sub: makerNote},
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"),
+		pointerEntry(0x8769, exifIFD),
+	}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	note, ok := md.EXIF["MakerNote"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("MakerNote = %v, want a map", md.EXIF["MakerNote"])
+	}
+	want := map[string]interface{}{
+		"MacroMode":      "Normal",
+		"FocusMode":      "AI Servo AF",
+		"LensType":       uint16(61),
+		"MaxFocalLength": 105.0,
+		"MinFocalLength": 24.0,
+		"FileNumber":     uint32(1001234),
+		"SerialNumber":   uint32(123456789),
+		"LensModel":      "EF24-105mm f/4L IS USM",
+	}
+	for name, value := range want {
+		if got := note[name]; got != value {
+			t.Errorf("MakerNote[%s] = %#v, want %#v", name, got, value)
+		}
+	}
+	if len(note) != len(want) {
+		t.Errorf("MakerNote has %d entries, want %d: %v", len(note), len(want), note)
+	}
+}
+
+func TestEXIF_NikonMakerNote(t *testing.T) {
+	nikonIFD := &testIFD{entries: []testEntry{
+		shortsEntry(0x0002, 0, 400),
+		asciiEntry(0x001D, "3012345"),
+		rationalEntry(0x0084, 24, 1, 70, 1, 28, 10, 28, 10),
+		undefinedEntry(0x0098, []byte("0204\x01\x02\x03\x04\x05\x06")),
+		longEntry(0x00A7, 48213),
+	}}
+	want := map[string]interface{}{
+		"ISO":          uint16(400),
+		"SerialNumber": "3012345",
+		"Lens":         "24-70mm f/2.8",
+		"ShutterCount": uint32(48213),
+	}
+
+	jpegWithNote := func(note testEntry) []byte {
+		return createJPEGWithEXIF(buildTIFF(&testIFD{entries: []testEntry{
+			asciiEntry(0x010F, "NIKON CORPORATION"),
+			pointerEntry(0x8769, &testIFD{entries: []testEntry{note}}),
+		}}))
+	}
+
+	tests := []struct {
+		name string
+		note testEntry
+	}{
+		// Type 3: own TIFF header, offsets relative to it
+		{"Type3", undefinedEntry(0x927C, append([]byte("Nikon\x00\x02\x10\x00\x00"), buildTIFF(nikonIFD)...))},
+		// Type 1: an 8-byte header, offsets relative to the EXIF TIFF header
+		{"Type1", testEntry{tag: 0x927C, typ: 7, count: 8 + 2 + 12*5 + 4, sub: &testIFD{
+			entries: nikonIFD.entries,
+			header:  []byte("Nikon\x00\x01\x00"),
+		}}},
+		// Type 2: header-less IFD, offsets relative to the EXIF TIFF header
+		{"Type2", testEntry{tag: 0x927C, typ: 7, count: 2 + 12*5 + 4, sub: nikonIFD}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(jpegWithNote(tt.note))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			note, ok := md.EXIF["MakerNote"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("MakerNote = %v, want a map", md.EXIF["MakerNote"])
+			}
+			for name, value := range want {
+				if got := note[name]; got != value {
+					t.Errorf("MakerNote[%s] = %#v, want %#v", name, got, value)
+				}
+			}
+			if _, ok := note["LensData"]; ok {
+				t.Error("Encrypted LensData should be skipped")
+			}
+		})
+	}
+}
+
+// TestEXIF_NikonMakerNoteSample decodes the Type 3 MakerNote of a Nikon
+// D80 JPEG. The sample is an EXIF-only stub, so the JPEG itself is partial.
+func TestEXIF_NikonMakerNoteSample(t *testing.T) {
+	want := map[string]interface{}{
+		"ISO":          uint16(1250),
+		"SerialNumber": "3453402",
+		"Lens":         "18-135mm f/3.5-5.6",
+		"ShutterCount": uint32(12139),
+	}
+
+	// The same D80 EXIF block in a JPEG APP1 segment and as the TIFF
+	// stream a NEF file starts with
+	for _, tt := range []struct {
+		path   string
+		format Format
+	}{
+		{"testdata/nikon-makernote.jpg", FormatJPEG},
+		{"testdata/nikon-d80-exif.tif", FormatTIFF},
+	} {
+		md, err := Metadata(tt.path)
+		if err != nil && !errors.Is(err, ErrPartial) {
+			t.Fatalf("Metadata(%s) error = %v", tt.path, err)
+		}
+		if md.Format != tt.format || md.EXIF["Model"] != "NIKON D80" {
+			t.Fatalf("%s: Format, Model = %v, %v, want %v, NIKON D80", tt.path, md.Format, md.EXIF["Model"], tt.format)
+		}
+		note, ok := md.EXIF["MakerNote"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: MakerNote = %v, want a map", tt.path, md.EXIF["MakerNote"])
+		}
+		for name, value := range want {
+			if got := note[name]; got != value {
+				t.Errorf("%s: MakerNote[%s] = %#v, want %#v", tt.path, name, got, value)
+			}
+		}
+	}
+
+	// ExtractTIFF reaches the MakerNote through the Exif IFD pointer
+	f, err := os.Open("testdata/nikon-d80-exif.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	res, err := formats.ExtractTIFF(f)
+	if err != nil {
+		t.Fatalf("ExtractTIFF() error = %v", err)
+	}
+	if note, _ := res.EXIF["MakerNote"].(map[string]interface{}); note["ShutterCount"] != uint32(12139) {
+		t.Errorf("ExtractTIFF() MakerNote = %v, want ShutterCount 12139", res.EXIF["MakerNote"])
+	}
+}
+
+// xpText encodes s as a NUL-terminated UTF-16LE Windows property
+func xpText(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return append(b, 0, 0)
+}
+
+func TestEXIF_WindowsXPTags(t *testing.T) {
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		byteEntry(0x9C9B, xpText("Sommer in Köln")),
+		byteEntry(0x9C9C, xpText("Ein Kommentar")),
+		byteEntry(0x9C9D, xpText("Jürgen")),
+		byteEntry(0x9C9E, xpText("urlaub; strand;;sonne")),
+		byteEntry(0x9C9F, xpText("Ferien 😎")),
+	}})
+
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	want := map[string]string{
+		"XPTitle":   "Sommer in Köln",
+		"XPComment": "Ein Kommentar",
+		"XPAuthor":  "Jürgen",
+		"XPSubject": "Ferien 😎",
+	}
+	for tag, text := range want {
+		if got := md.EXIF[tag]; got != text {
+			t.Errorf("%s = %q, want %q", tag, got, text)
+		}
+	}
+
+	keywords, ok := md.EXIF["XPKeywords"].([]string)
+	if !ok || len(keywords) != 3 || keywords[0] != "urlaub" || keywords[1] != "strand" || keywords[2] != "sonne" {
+		t.Errorf("XPKeywords = %#v, want [urlaub strand sonne]", md.EXIF["XPKeywords"])
+	}
+}
+
+func TestEXIF_CorruptOffsets(t *testing.T) {
+	gps := &testIFD{entries: []testEntry{asciiEntry(0x0001, "N"), rationalEntry(0x0002, 52, 1, 31, 1, 0, 1)}}
+	exifIFD := &testIFD{entries: []testEntry{
+		undefinedEntry(0x9286, []byte("ASCII\x00\x00\x00a longer comment")),
+		byteEntry(0x9C9B, xpText("Title")),
+	}}
+	tiff := buildTIFF(&testIFD{
+		entries: []testEntry{
+			asciiEntry(0x010F, "Canon EOS"),
+			shortsEntry(0x0102, 8, 8, 8),
+			pointerEntry(0x8769, exifIFD),
+			pointerEntry(0x8825, gps),
+		},
+		next: &testIFD{entries: []testEntry{longEntry(0x0201, 0xFFFFFFF0), longEntry(0x0202, 0xFFFFFFF0)}},
+	})
+
+	// Overwrite every 4-byte window with values that overflow a 32-bit int
+	// or point far past the data; parsing must skip the tag, not panic
+	for _, v := range []uint32{0xFFFFFFFF, 0x80000000, 0x7FFFFFFF, uint32(len(tiff)), uint32(len(tiff) - 1)} {
+		for i := 0; i+4 <= len(tiff); i++ {
+			corrupt := append([]byte{}, tiff...)
+			binary.LittleEndian.PutUint32(corrupt[i:], v)
+			md, err := MetadataFromBytes(createJPEGWithEXIF(corrupt))
+			if err != nil {
+				t.Fatalf("offset %d = %#x: MetadataFromBytes() error = %v", i, v, err)
+			}
+			md.Thumbnail()
+		}
+	}
+}
+
+func TestEXIF_SelfReferencingIFD(t *testing.T) {
+	done := make(chan *ImageMetadata)
+	go func() {
+		md, err := MetadataFromBytes(createJPEGWithEXIF(selfReferencingTIFF()))
+		if err != nil {
+			t.Errorf("MetadataFromBytes() error = %v", err)
+		}
+		done <- md
+	}()
+
+	select {
+	case md := <-done:
+		if md != nil && md.EXIF["Make"] != "Canon" {
+			t.Errorf("Make = %v, want Canon", md.EXIF["Make"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("MetadataFromBytes() did not return within a second")
+	}
+}
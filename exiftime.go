@@ -0,0 +1,74 @@
+package imx
+
+import (
+	"strings"
+	"time"
+)
+
+// exifTimeLayout is the EXIF date/time format, e.g. "2024:03:09 14:05:30".
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// DateTime returns the file change date and time (EXIF DateTime).
+// See DateTimeOriginal for how offsets and sub-seconds are applied.
+func (md *ImageMetadata) DateTime() (time.Time, bool) {
+	return md.exifTime("DateTime", "SubSecTime", "OffsetTime")
+}
+
+// DateTimeOriginal returns when the image was captured. SubSecTimeOriginal
+// adds sub-second precision. The zone comes from OffsetTimeOriginal, falling
+// back to OffsetTime; without either the time is returned in UTC because
+// EXIF does not otherwise record the zone. ok is false when the tag is
+// absent or malformed.
+func (md *ImageMetadata) DateTimeOriginal() (time.Time, bool) {
+	return md.exifTime("DateTimeOriginal", "SubSecTimeOriginal", "OffsetTimeOriginal", "OffsetTime")
+}
+
+// DateTimeDigitized returns when the image was digitized (EXIF
+// DateTimeDigitized). See DateTimeOriginal for how offsets and sub-seconds
+// are applied.
+func (md *ImageMetadata) DateTimeDigitized() (time.Time, bool) {
+	return md.exifTime("DateTimeDigitized", "SubSecTimeDigitized", "OffsetTimeDigitized", "OffsetTime")
+}
+
+// exifTime parses the date/time tag, applying the sub-second tag and the
+// first offset tag that is present.
+func (md *ImageMetadata) exifTime(tag, subSecTag string, offsetTags ...string) (time.Time, bool) {
+	exif := md.FlatEXIF()
+	value, _ := exif[tag].(string)
+	t, err := time.Parse(exifTimeLayout, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if subSec, ok := exif[subSecTag].(string); ok {
+		t = t.Add(parseSubSec(subSec))
+	}
+
+	for _, offsetTag := range offsetTags {
+		offset, _ := exif[offsetTag].(string)
+		offset = strings.TrimSpace(offset)
+		zone, err := time.Parse("-07:00", offset)
+		if err != nil {
+			continue
+		}
+		_, seconds := zone.Zone()
+		// Reinterpret the wall clock time in the recorded zone
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+			time.FixedZone(offset, seconds))
+		break
+	}
+
+	return t, true
+}
+
+// parseSubSec converts the digits of a SubSecTime tag ("123" = 0.123s)
+// to a duration. Anything after the leading digits is ignored.
+func parseSubSec(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	var nanos, scale int64 = 0, int64(time.Second)
+	for i := 0; i < len(s) && s[i] >= '0' && s[i] <= '9' && scale > 1; i++ {
+		scale /= 10
+		nanos += int64(s[i]-'0') * scale
+	}
+	return time.Duration(nanos)
+}
@@ -0,0 +1,111 @@
+package imx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imx/formats"
+)
+
+// FlatMap flattens the metadata into string values keyed by dotted paths,
+// e.g. "Width", "EXIF.FNumber" or "Additional.XMP.dc:title". It is intended
+// for templates and tabular exports where nested interface maps are awkward.
+func (md *ImageMetadata) FlatMap() map[string]string {
+	exif := md.LoadEXIF()
+	flat := map[string]string{
+		"Format":        string(md.Format),
+		"Width":         strconv.Itoa(md.Width),
+		"Height":        strconv.Itoa(md.Height),
+		"FileSize":      strconv.FormatInt(md.FileSize, 10),
+		"ColorDepth":    strconv.Itoa(md.ColorDepth),
+		"ColorSpace":    string(md.ColorSpace),
+		"HasICCProfile": strconv.FormatBool(md.HasICCProfile),
+		"HasAlpha":      strconv.FormatBool(md.HasAlpha),
+		"Channels":      strconv.Itoa(md.Channels),
+		"DPIX":          strconv.FormatFloat(md.DPIX, 'f', -1, 64),
+		"DPIY":          strconv.FormatFloat(md.DPIY, 'f', -1, 64),
+		"Orientation":   strconv.Itoa(int(md.Orientation)),
+		"IsAnimated":    strconv.FormatBool(md.IsAnimated),
+		"FrameCount":    strconv.Itoa(md.FrameCount),
+		"Duration":      md.Duration.String(),
+		"LoopCount":     strconv.Itoa(md.LoopCount),
+	}
+
+	flattenInto(flat, "EXIF", exif)
+	flattenInto(flat, "Additional", md.Additional)
+
+	return flat
+}
+
+// EXIFFormatted returns the EXIF tags rendered the way exiftool prints
+// them, e.g. ExposureTime "1/200", FNumber "f/2.8", FocalLength "50.0 mm"
+// and Flash "Off, Did not fire". It is a read-only view: EXIF keeps the raw
+// values for programmatic use.
+func (md *ImageMetadata) EXIFFormatted() map[string]string {
+	return formats.FormatEXIF(md.FlatEXIF())
+}
+
+// flattenInto writes the entries of m into flat under prefix, descending
+// into nested maps.
+func flattenInto(flat map[string]string, prefix string, m map[string]interface{}) {
+	for key, value := range m {
+		path := prefix + "." + key
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenInto(flat, path, nested)
+			continue
+		}
+		if nested, ok := value.(map[string]string); ok {
+			for k, v := range nested {
+				flat[path+"."+k] = v
+			}
+			continue
+		}
+		flat[path] = formatValue(value)
+	}
+}
+
+// formatValue renders a metadata value as a string. Floats use the shortest
+// exact representation, byte slices are hex encoded, and other slices are
+// joined with ", ".
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case []byte:
+		return hex.EncodeToString(v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + "=" + formatValue(v[k])
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			parts[i] = formatValue(rv.Index(i).Interface())
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	return fmt.Sprint(value)
+}
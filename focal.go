@@ -0,0 +1,62 @@
+package imx
+
+import "math"
+
+// fullFrameDiagonal is the diagonal of a 36x24 mm sensor, in millimeters.
+var fullFrameDiagonal = math.Hypot(36, 24)
+
+// focalPlaneUnits converts each FocalPlaneResolutionUnit to millimeters.
+var focalPlaneUnits = map[int64]float64{
+	2: 25.4,  // inch
+	3: 10,    // centimeter
+	4: 1,     // millimeter
+	5: 0.001, // micrometer
+}
+
+// EffectiveFocalLength returns the 35mm-equivalent focal length in
+// millimeters, the figure photographers compare lenses by. It is
+// FocalLengthIn35mmFilm when the camera recorded it. Otherwise it is
+// FocalLength scaled by a crop factor estimated from the sensor size, which
+// the focal plane resolution tags give with the image dimensions. The
+// estimate assumes the image covers the whole sensor, so it is approximate,
+// typically within a few percent. ok is false when neither is possible.
+func (md *ImageMetadata) EffectiveFocalLength() (mm float64, ok bool) {
+	if v, ok := md.EXIFFloat("FocalLengthIn35mmFilm"); ok && v > 0 {
+		return v, true
+	}
+
+	focal, ok := md.EXIFFloat("FocalLength")
+	if !ok || focal <= 0 {
+		return 0, false
+	}
+	unitCode, ok := md.EXIFInt("FocalPlaneResolutionUnit")
+	if !ok {
+		unitCode = 2 // the EXIF default, inches
+	}
+	unit, ok := focalPlaneUnits[unitCode]
+	if !ok {
+		return 0, false
+	}
+	xRes, okX := md.EXIFFloat("FocalPlaneXResolution")
+	yRes, okY := md.EXIFFloat("FocalPlaneYResolution")
+	if !okX || xRes <= 0 {
+		return 0, false
+	}
+	if !okY || yRes <= 0 {
+		yRes = xRes
+	}
+
+	// The focal plane resolution refers to the image as recorded, which
+	// PixelXDimension and PixelYDimension describe when the file was resized
+	width, okW := md.EXIFFloat("PixelXDimension")
+	height, okH := md.EXIFFloat("PixelYDimension")
+	if !okW || !okH || width <= 0 || height <= 0 {
+		width, height = float64(md.Width), float64(md.Height)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, false
+	}
+
+	diagonal := math.Hypot(width/xRes*unit, height/yRes*unit)
+	return focal * fullFrameDiagonal / diagonal, true
+}
@@ -0,0 +1,294 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExtractAVIF extracts metadata from an AVIF file.
+func ExtractAVIF(r io.ReadSeeker) (*Result, error) {
+	return extractAVIF(r, Options{})
+}
+
+func extractAVIF(r io.ReadSeeker, opts Options) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	if opts, err = opts.withInputSize(r); err != nil {
+		return nil, err
+	}
+
+	var brands []string
+	var meta []byte
+
+	// Walk the top-level boxes, keeping only ftyp and meta
+	for meta == nil {
+		boxType, size, err := readBoxHeader(r)
+		if err != nil {
+			break
+		}
+
+		switch boxType {
+		case "ftyp", "meta":
+			payload, err := readBoxPayload(r, size, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read AVIF %s box: %w", boxType, err)
+			}
+			if boxType == "ftyp" {
+				major, compatible := ftypBrands(payload)
+				brands = append([]string{major}, compatible...)
+			} else {
+				meta = payload
+			}
+
+		default:
+			if size < 0 {
+				// Box runs to the end of the file; nothing follows it
+				r.Seek(0, io.SeekEnd)
+				continue
+			}
+			r.Seek(size, io.SeekCurrent)
+		}
+	}
+
+	isAVIF, isSequence := false, false
+	for _, b := range brands {
+		switch b {
+		case "avif":
+			isAVIF = true
+		case "avis":
+			isAVIF = true
+			isSequence = true
+		}
+	}
+	if !isAVIF {
+		return nil, fmt.Errorf("%w: missing AVIF brand", ErrInvalidData)
+	}
+	if len(meta) < 4 {
+		return nil, fmt.Errorf("%w: missing AVIF meta box", ErrInvalidData)
+	}
+
+	result := newResult()
+	result.ColorSpace = "RGB"
+	result.Additional["Animation"] = isSequence
+
+	// meta is a FullBox: skip version and flags
+	children := parseBoxes(meta[4:])
+
+	var properties []isoBox
+	associations := make(map[uint32][]int)
+	if iprp, ok := findBox(children, "iprp"); ok {
+		iprpChildren := parseBoxes(iprp.data)
+		if ipco, ok := findBox(iprpChildren, "ipco"); ok {
+			properties = parseBoxes(ipco.data)
+		}
+		for _, b := range iprpChildren {
+			if b.typ == "ipma" {
+				parseIPMA(b.data, associations)
+			}
+		}
+	}
+
+	// Resolve the properties of the primary item; fall back to every
+	// property when pitm or the association table is missing.
+	primary := properties
+	if pitm, ok := findBox(children, "pitm"); ok {
+		if id, ok := parsePITM(pitm.data); ok {
+			if indices, ok := associations[id]; ok {
+				primary = nil
+				for _, idx := range indices {
+					if idx >= 1 && idx <= len(properties) {
+						primary = append(primary, properties[idx-1])
+					}
+				}
+			}
+		}
+	}
+
+	channels, bitDepth := 0, 0
+	for _, prop := range primary {
+		switch prop.typ {
+		case "ispe":
+			// FullBox header, then width and height
+			if len(prop.data) >= 12 && result.Width == 0 {
+				result.Width = int(binary.BigEndian.Uint32(prop.data[4:8]))
+				result.Height = int(binary.BigEndian.Uint32(prop.data[8:12]))
+			}
+
+		case "colr":
+			parseColr(prop.data, result)
+
+		case "pixi":
+			// FullBox header, channel count, then bits per channel
+			if len(prop.data) >= 5 {
+				n := int(prop.data[4])
+				if len(prop.data) >= 5+n && n > 0 {
+					channels = n
+					bitDepth = int(prop.data[5])
+					result.ColorDepth = 0
+					for _, bits := range prop.data[5 : 5+n] {
+						result.ColorDepth += int(bits)
+					}
+				}
+			}
+
+		case "av1C":
+			// Only used when pixi is absent
+			if len(prop.data) >= 3 && channels == 0 {
+				flags := prop.data[2]
+				bitDepth = 8
+				if flags&0x40 != 0 { // high_bitdepth
+					bitDepth = 10
+					if flags&0x20 != 0 { // twelve_bit
+						bitDepth = 12
+					}
+				}
+				mono := 3
+				if flags&0x10 != 0 { // monochrome
+					mono = 1
+				}
+				result.ColorDepth = bitDepth * mono
+				if mono == 1 {
+					result.ColorSpace = "Grayscale"
+				}
+			}
+		}
+	}
+
+	if channels == 1 {
+		result.ColorSpace = "Grayscale"
+	}
+	if bitDepth > 0 {
+		result.Additional["BitDepth"] = bitDepth
+	}
+
+	// An auxiliary alpha image anywhere in the file means the primary
+	// image carries transparency.
+	for _, prop := range properties {
+		if prop.typ == "auxC" && isAlphaAuxType(prop.data) {
+			result.Additional["HasAlpha"] = true
+			if result.ColorSpace == "RGB" {
+				result.ColorSpace = "RGBA"
+			}
+		}
+	}
+
+	if result.Width == 0 || result.Height == 0 {
+		return nil, fmt.Errorf("%w: missing AVIF ispe property", ErrInvalidData)
+	}
+
+	return result, nil
+}
+
+// parsePITM returns the primary item ID from a pitm payload.
+func parsePITM(data []byte) (uint32, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+	if data[0] == 0 {
+		if len(data) < 6 {
+			return 0, false
+		}
+		return uint32(binary.BigEndian.Uint16(data[4:6])), true
+	}
+	if len(data) < 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[4:8]), true
+}
+
+// parseIPMA records the 1-based property indices associated with each item.
+func parseIPMA(data []byte, associations map[uint32][]int) {
+	if len(data) < 8 {
+		return
+	}
+	version := data[0]
+	flags := data[3]
+	entryCount := int(binary.BigEndian.Uint32(data[4:8]))
+	pos := 8
+
+	for i := 0; i < entryCount; i++ {
+		var itemID uint32
+		if version < 1 {
+			if pos+2 > len(data) {
+				return
+			}
+			itemID = uint32(binary.BigEndian.Uint16(data[pos : pos+2]))
+			pos += 2
+		} else {
+			if pos+4 > len(data) {
+				return
+			}
+			itemID = binary.BigEndian.Uint32(data[pos : pos+4])
+			pos += 4
+		}
+
+		if pos >= len(data) {
+			return
+		}
+		count := int(data[pos])
+		pos++
+
+		for j := 0; j < count; j++ {
+			var index int
+			if flags&0x01 != 0 {
+				if pos+2 > len(data) {
+					return
+				}
+				index = int(binary.BigEndian.Uint16(data[pos:pos+2]) & 0x7FFF)
+				pos += 2
+			} else {
+				if pos >= len(data) {
+					return
+				}
+				index = int(data[pos] & 0x7F)
+				pos++
+			}
+			associations[itemID] = append(associations[itemID], index)
+		}
+	}
+}
+
+// parseColr reads a colr property, recording nclx coefficients or ICC presence.
+func parseColr(data []byte, res *Result) {
+	if len(data) < 4 {
+		return
+	}
+
+	switch string(data[0:4]) {
+	case "nclx":
+		if len(data) < 11 {
+			return
+		}
+		primaries := int(binary.BigEndian.Uint16(data[4:6]))
+		transfer := int(binary.BigEndian.Uint16(data[6:8]))
+		matrix := int(binary.BigEndian.Uint16(data[8:10]))
+
+		res.Additional["ColorPrimaries"] = primaries
+		res.Additional["TransferCharacteristics"] = transfer
+		res.Additional["MatrixCoefficients"] = matrix
+		res.Additional["FullRange"] = data[10]&0x80 != 0
+		// PQ (16) and HLG (18) transfer functions indicate HDR content
+		res.Additional["HDR"] = transfer == 16 || transfer == 18
+
+	case "rICC", "prof":
+		res.HasICCProfile = true
+	}
+}
+
+// isAlphaAuxType reports whether an auxC payload declares an alpha plane.
+func isAlphaAuxType(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	auxType := string(data[4:])
+	if i := strings.IndexByte(auxType, 0); i >= 0 {
+		auxType = auxType[:i]
+	}
+	return auxType == "urn:mpeg:mpegB:cicp:systems:auxiliary:alpha" ||
+		auxType == "urn:mpeg:hevc:2015:auxid:1"
+}
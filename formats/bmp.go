@@ -1,13 +1,49 @@
 package formats
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"math/bits"
 )
 
+// DIB header sizes with color management fields
+const (
+	bmpV4HeaderSize = 108
+	bmpV5HeaderSize = 124
+)
+
+// bV5CSType values that reference an ICC profile
+const (
+	bmpProfileLinked   = 0x4C494E4B // 'LINK'
+	bmpProfileEmbedded = 0x4D424544 // 'MBED'
+)
+
+// bmpColorSpaceTypes names the bV4CSType/bV5CSType values.
+var bmpColorSpaceTypes = map[uint32]string{
+	0x00000000:         "Calibrated RGB",
+	0x73524742:         "sRGB",    // 'sRGB'
+	0x57696E20:         "Windows", // 'Win '
+	bmpProfileLinked:   "Linked Profile",
+	bmpProfileEmbedded: "Embedded Profile",
+}
+
+// bmpRenderingIntents names the bV5Intent values.
+var bmpRenderingIntents = map[uint32]string{
+	1: "Saturation",
+	2: "Relative Colorimetric",
+	4: "Perceptual",
+	8: "Absolute Colorimetric",
+}
+
 // ExtractBMP extracts metadata from a BMP file.
 func ExtractBMP(r io.ReadSeeker) (*Result, error) {
+	return extractBMP(r, Options{})
+}
+
+func extractBMP(r io.ReadSeeker, opts Options) (*Result, error) {
 	// Reset to beginning
 	_, err := r.Seek(0, io.SeekStart)
 	if err != nil {
@@ -16,7 +52,7 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 
 	// Read BMP file header (14 bytes)
 	fileHeader := make([]byte, 14)
-	_, err = r.Read(fileHeader)
+	err = readFull(r, fileHeader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read BMP file header: %w", err)
 	}
@@ -38,7 +74,7 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 
 	// Read DIB header size (4 bytes, little-endian)
 	dibSizeBytes := make([]byte, 4)
-	_, err = r.Read(dibSizeBytes)
+	err = readFull(r, dibSizeBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read DIB header size: %w", err)
 	}
@@ -53,12 +89,20 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 	var colorsUsed, colorsImportant uint32
 
 	if dibSize >= 40 {
-		// BITMAPINFOHEADER (40 bytes) or larger
-		dibHeader := make([]byte, 36) // Read remaining 36 bytes of 40-byte header
-		_, err = r.Read(dibHeader)
+		// BITMAPINFOHEADER (40 bytes) or larger; fields past the V5 header
+		// are not defined and are skipped
+		headerLen := int(dibSize)
+		if headerLen > bmpV5HeaderSize {
+			headerLen = bmpV5HeaderSize
+		}
+		dibHeader := make([]byte, headerLen-4) // Remainder after the size field
+		err = readFull(r, dibHeader)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read DIB header: %w", err)
 		}
+		if int(dibSize) > headerLen {
+			r.Seek(int64(dibSize)-int64(headerLen), io.SeekCurrent)
+		}
 
 		width = int32(binary.LittleEndian.Uint32(dibHeader[0:4]))
 		height = int32(binary.LittleEndian.Uint32(dibHeader[4:8]))
@@ -87,6 +131,10 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 		result.Additional["ImageSize"] = imageSize
 		result.Additional["XPixelsPerMeter"] = xPixelsPerM
 		result.Additional["YPixelsPerMeter"] = yPixelsPerM
+		if xPixelsPerM > 0 && yPixelsPerM > 0 {
+			result.Additional["DPIX"] = dpiFromPixelsPerMeter(xPixelsPerM)
+			result.Additional["DPIY"] = dpiFromPixelsPerMeter(yPixelsPerM)
+		}
 		result.Additional["ColorsUsed"] = colorsUsed
 		result.Additional["ColorsImportant"] = colorsImportant
 
@@ -108,6 +156,31 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 			result.ColorSpace = "Unknown"
 		}
 
+		// BI_BITFIELDS masks follow a BITMAPINFOHEADER or sit inside a
+		// V2+ header at the same offset; V3+ headers and BI_ALPHABITFIELDS add alpha
+		if compression == 3 || compression == 6 {
+			maskCount := 3
+			if dibSize >= 56 || compression == 6 {
+				maskCount = 4
+			}
+			var maskData []byte
+			if len(dibHeader) >= 36+4*maskCount {
+				maskData = dibHeader[36 : 36+4*maskCount]
+			} else {
+				maskData = make([]byte, 4*maskCount)
+				err = readFull(r, maskData)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read BMP color masks: %w", err)
+				}
+			}
+			parseBMPMasks(maskData, result)
+		}
+
+		// BITMAPV4HEADER and BITMAPV5HEADER color management fields
+		if dibSize >= bmpV4HeaderSize {
+			parseBMPColorSpace(r, dibHeader, result, opts)
+		}
+
 		// Compression types
 		compressionNames := map[uint32]string{
 			0: "BI_RGB",
@@ -116,6 +189,7 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 			3: "BI_BITFIELDS",
 			4: "BI_JPEG",
 			5: "BI_PNG",
+			6: "BI_ALPHABITFIELDS",
 		}
 		if name, ok := compressionNames[compression]; ok {
 			result.Additional["CompressionName"] = name
@@ -124,7 +198,7 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 	} else if dibSize == 12 {
 		// BITMAPCOREHEADER (12 bytes)
 		dibHeader := make([]byte, 8)
-		_, err = r.Read(dibHeader)
+		err = readFull(r, dibHeader)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read DIB header: %w", err)
 		}
@@ -145,3 +219,110 @@ func ExtractBMP(r io.ReadSeeker) (*Result, error) {
 
 	return result, nil
 }
+
+// parseBMPMasks records the BI_BITFIELDS channel masks and derives the
+// channel layout, e.g. R5G6B5, and whether an alpha channel is present.
+func parseBMPMasks(data []byte, res *Result) {
+	masks := []uint32{
+		binary.LittleEndian.Uint32(data[0:4]),
+		binary.LittleEndian.Uint32(data[4:8]),
+		binary.LittleEndian.Uint32(data[8:12]),
+		0,
+	}
+	if len(data) >= 16 {
+		masks[3] = binary.LittleEndian.Uint32(data[12:16])
+	}
+
+	res.Additional["RedMask"] = masks[0]
+	res.Additional["GreenMask"] = masks[1]
+	res.Additional["BlueMask"] = masks[2]
+	res.Additional["AlphaMask"] = masks[3]
+
+	layout := fmt.Sprintf("R%dG%dB%d", bits.OnesCount32(masks[0]), bits.OnesCount32(masks[1]), bits.OnesCount32(masks[2]))
+	res.ColorSpace = "RGB"
+	if masks[3] != 0 {
+		layout += fmt.Sprintf("A%d", bits.OnesCount32(masks[3]))
+		res.ColorSpace = "RGBA"
+	}
+	res.Additional["BitfieldLayout"] = layout
+}
+
+// parseBMPColorSpace reads the color space type and gamma of a V4 header and
+// the rendering intent and linked or embedded ICC profile of a V5 header.
+// header holds the DIB header after its size field. A profile that cannot
+// be read is skipped; the rest of the header is still reported.
+func parseBMPColorSpace(r io.ReadSeeker, header []byte, res *Result, opts Options) {
+	csType := binary.LittleEndian.Uint32(header[52:56])
+	if name, ok := bmpColorSpaceTypes[csType]; ok {
+		res.Additional["ColorSpaceType"] = name
+	} else {
+		res.Additional["ColorSpaceType"] = "Unknown"
+	}
+
+	// Endpoints and gamma only apply to calibrated RGB; gamma is 16.16 fixed point
+	if csType == 0 {
+		res.Additional["GammaRed"] = float64(binary.LittleEndian.Uint32(header[92:96])) / 65536
+		res.Additional["GammaGreen"] = float64(binary.LittleEndian.Uint32(header[96:100])) / 65536
+		res.Additional["GammaBlue"] = float64(binary.LittleEndian.Uint32(header[100:104])) / 65536
+	}
+
+	if len(header) < bmpV5HeaderSize-4 {
+		return
+	}
+	if intent, ok := bmpRenderingIntents[binary.LittleEndian.Uint32(header[104:108])]; ok {
+		res.Additional["RenderingIntent"] = intent
+	}
+
+	if csType == bmpProfileEmbedded {
+		res.HasICCProfile = true
+	}
+
+	// Profile data is addressed from the start of the DIB header
+	profileOffset := int64(binary.LittleEndian.Uint32(header[108:112]))
+	profileSize := binary.LittleEndian.Uint32(header[112:116])
+	if csType != bmpProfileLinked && csType != bmpProfileEmbedded || profileSize == 0 {
+		return
+	}
+	if profileSize > maxICCProfileSize {
+		opts.log("skipped BMP color profile", "size", profileSize, "error", "profile too large")
+		return
+	}
+
+	// The header fields are checked against the input before allocating
+	inputSize := opts.InputSize
+	if inputSize <= 0 {
+		var err error
+		if inputSize, err = r.Seek(0, io.SeekEnd); err != nil {
+			opts.log("skipped BMP color profile", "error", err)
+			return
+		}
+	}
+	if 14+profileOffset+int64(profileSize) > inputSize {
+		opts.log("skipped BMP color profile", "offset", profileOffset, "size", profileSize, "error", "profile past the end of the file")
+		return
+	}
+	if _, err := r.Seek(14+profileOffset, io.SeekStart); err != nil {
+		opts.log("skipped BMP color profile", "error", err)
+		return
+	}
+	profile := make([]byte, profileSize)
+	if err := readFull(r, profile); err != nil {
+		opts.log("skipped BMP color profile", "error", err)
+		return
+	}
+
+	if csType == bmpProfileLinked {
+		// A linked profile is a NUL-terminated file name
+		res.Additional["LinkedProfile"] = decodeTextBytes(bytes.TrimRight(profile, "\x00"))
+		return
+	}
+	if icc, ok := parseICCProfile(profile); ok {
+		res.Additional["ICCProfile"] = icc
+	}
+}
+
+// dpiFromPixelsPerMeter converts a pixels-per-meter resolution to the
+// nearest whole dots per inch.
+func dpiFromPixelsPerMeter(ppm uint32) int {
+	return int(math.Round(float64(ppm) * 0.0254))
+}
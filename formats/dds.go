@@ -0,0 +1,118 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DDS header and pixel format flags
+const (
+	ddsdMipMapCount     = 0x20000
+	ddpfAlphaPixels     = 0x1
+	ddpfFourCC          = 0x4
+	ddpfRGB             = 0x40
+	ddpfLuminance       = 0x20000
+	ddsCaps2CubeMap     = 0x200
+	ddsCaps2Volume      = 0x200000
+	ddsHeaderSize       = 124
+	ddsDX10HeaderSize   = 20
+	ddsPixelFormatStart = 72
+)
+
+// ExtractDDS extracts metadata from a DirectDraw Surface texture.
+func ExtractDDS(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read magic and DDS_HEADER
+	buf := make([]byte, 4+ddsHeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("failed to read DDS header: %w", err)
+	}
+	if string(buf[0:4]) != "DDS " {
+		return nil, fmt.Errorf("%w: missing DDS magic", ErrInvalidData)
+	}
+
+	header := buf[4:]
+	if binary.LittleEndian.Uint32(header[0:4]) != ddsHeaderSize {
+		return nil, fmt.Errorf("%w: invalid DDS header size", ErrInvalidData)
+	}
+
+	flags := binary.LittleEndian.Uint32(header[4:8])
+	height := binary.LittleEndian.Uint32(header[8:12])
+	width := binary.LittleEndian.Uint32(header[12:16])
+	depth := binary.LittleEndian.Uint32(header[20:24])
+	mipMapCount := binary.LittleEndian.Uint32(header[24:28])
+
+	pf := header[ddsPixelFormatStart : ddsPixelFormatStart+32]
+	pfFlags := binary.LittleEndian.Uint32(pf[4:8])
+	fourCC := string(pf[8:12])
+	rgbBitCount := int(binary.LittleEndian.Uint32(pf[12:16]))
+	caps2 := binary.LittleEndian.Uint32(header[108:112])
+
+	result := newResult()
+	result.Width = int(width)
+	result.Height = int(height)
+
+	if flags&ddsdMipMapCount == 0 || mipMapCount == 0 {
+		mipMapCount = 1
+	}
+	result.Additional["MipMapCount"] = int(mipMapCount)
+	result.Additional["CubeMap"] = caps2&ddsCaps2CubeMap != 0
+	if caps2&ddsCaps2Volume != 0 {
+		result.Additional["Depth"] = int(depth)
+	}
+
+	hasAlpha := pfFlags&ddpfAlphaPixels != 0
+
+	switch {
+	case pfFlags&ddpfFourCC != 0:
+		result.Additional["FourCC"] = fourCC
+		result.Additional["Compressed"] = true
+		switch fourCC {
+		case "DXT1":
+			result.ColorSpace = "RGB"
+		case "DXT2", "DXT3", "DXT4", "DXT5":
+			result.ColorSpace = "RGBA"
+		case "ATI1", "BC4U", "BC4S":
+			result.ColorSpace = "Grayscale"
+		case "DX10":
+			dx10 := make([]byte, ddsDX10HeaderSize)
+			if _, err := io.ReadFull(r, dx10); err != nil {
+				return nil, fmt.Errorf("failed to read DDS DX10 header: %w", err)
+			}
+			result.Additional["DXGIFormat"] = int(binary.LittleEndian.Uint32(dx10[0:4]))
+			result.Additional["ResourceDimension"] = int(binary.LittleEndian.Uint32(dx10[4:8]))
+			result.Additional["ArraySize"] = int(binary.LittleEndian.Uint32(dx10[12:16]))
+			result.ColorSpace = "Unknown"
+		default:
+			result.ColorSpace = "Unknown"
+		}
+
+	case pfFlags&ddpfRGB != 0:
+		result.Additional["Compressed"] = false
+		result.ColorDepth = rgbBitCount
+		result.ColorSpace = "RGB"
+		if hasAlpha {
+			result.ColorSpace = "RGBA"
+		}
+
+	case pfFlags&ddpfLuminance != 0:
+		result.Additional["Compressed"] = false
+		result.ColorDepth = rgbBitCount
+		result.ColorSpace = "Grayscale"
+		if hasAlpha {
+			result.ColorSpace = "GrayscaleAlpha"
+		}
+
+	default:
+		result.ColorDepth = rgbBitCount
+		result.ColorSpace = "Unknown"
+	}
+
+	return result, nil
+}
@@ -1,56 +1,240 @@
 package formats
 
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// MaxHeaderSize is the number of leading bytes Detect can make use of.
+// Callers should supply up to this many bytes from the start of the input.
+const MaxHeaderSize = 128
+
+// Signature declares where a format's identifying bytes live and how to
+// recognize them.
+type Signature struct {
+	// Format is the name reported when the signature matches.
+	Format string
+
+	// Offset is the start of the window the signature inspects. Negative
+	// offsets are relative to the end of the input (e.g. file footers).
+	Offset int64
+
+	// Length is the minimum number of bytes Match needs. For header
+	// signatures Match receives everything available from Offset onwards,
+	// up to MaxHeaderSize; for trailer signatures it receives exactly Length bytes.
+	Length int
+
+	// Match reports whether the window identifies the format.
+	Match func(window []byte) bool
+}
+
+// Signatures returns the detection signatures of all registered formats,
+// header signatures first, in the order they are tried.
+func Signatures() []Signature {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	sigs := make([]Signature, 0, len(headerSignatures)+len(trailerSignatures))
+	sigs = append(sigs, headerSignatures...)
+	return append(sigs, trailerSignatures...)
+}
+
 // Detect identifies the image format by examining the magic bytes.
 // It returns the format name as a string, or an empty string if the format is not recognized.
+// Detect does not allocate.
 func Detect(magicBytes []byte) string {
 	if len(magicBytes) < 2 {
 		return ""
 	}
 
-	// JPEG: FF D8 FF
-	if len(magicBytes) >= 3 && magicBytes[0] == 0xFF && magicBytes[1] == 0xD8 && magicBytes[2] == 0xFF {
-		return "JPEG"
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, sig := range headerSignatures {
+		start := int(sig.Offset)
+		if len(magicBytes) < start+sig.Length {
+			continue
+		}
+		if sig.Match(magicBytes[start:]) {
+			return sig.Format
+		}
 	}
 
-	// PNG: 89 50 4E 47 0D 0A 1A 0A
-	if len(magicBytes) >= 8 {
-		pngSig := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
-		match := true
-		for i := 0; i < 8; i++ {
-			if magicBytes[i] != pngSig[i] {
-				match = false
-				break
-			}
+	return ""
+}
+
+// DetectTrailer identifies formats whose signature lives at the end of the
+// input, such as footer-based formats. size is the total length of r.
+// It returns an empty string if no trailer signature matches.
+func DetectTrailer(r io.ReadSeeker, size int64) (string, error) {
+	registryMu.RLock()
+	sigs := trailerSignatures
+	registryMu.RUnlock()
+
+	for _, sig := range sigs {
+		start := size + sig.Offset
+		if start < 0 || start+int64(sig.Length) > size {
+			continue
+		}
+
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return "", err
 		}
-		if match {
-			return "PNG"
+		window := make([]byte, sig.Length)
+		if _, err := io.ReadFull(r, window); err != nil {
+			return "", err
+		}
+		if sig.Match(window) {
+			return sig.Format, nil
 		}
 	}
 
-	// GIF: 47 49 46 38 37 61 (GIF87a) or 47 49 46 38 39 61 (GIF89a)
-	if len(magicBytes) >= 6 {
-		if magicBytes[0] == 0x47 && magicBytes[1] == 0x49 && magicBytes[2] == 0x46 &&
-			magicBytes[3] == 0x38 && (magicBytes[4] == 0x37 || magicBytes[4] == 0x39) &&
-			magicBytes[5] == 0x61 {
-			return "GIF"
-		}
+	return "", nil
+}
+
+// JPEG: FF D8 FF
+func isJPEG(b []byte) bool {
+	return b[0] == 0xFF && b[1] == 0xD8 && b[2] == 0xFF
+}
+
+// PNG: 89 50 4E 47 0D 0A 1A 0A
+func isPNG(b []byte) bool {
+	return bytes.Equal(b[:8], pngSignature)
+}
+
+// GIF: 47 49 46 38 37 61 (GIF87a) or 47 49 46 38 39 61 (GIF89a)
+func isGIF(b []byte) bool {
+	return b[0] == 0x47 && b[1] == 0x49 && b[2] == 0x46 &&
+		b[3] == 0x38 && (b[4] == 0x37 || b[4] == 0x39) &&
+		b[5] == 0x61
+}
+
+// WebP: RIFF (52 49 46 46) ... WEBP (57 45 42 50)
+func isWebP(b []byte) bool {
+	return b[0] == 0x52 && b[1] == 0x49 && b[2] == 0x46 && b[3] == 0x46 &&
+		b[8] == 0x57 && b[9] == 0x45 && b[10] == 0x42 && b[11] == 0x50
+}
+
+// RIFFForm returns the form type of a RIFF container, such as "WEBP",
+// "AVI " or "WAVE", and whether b starts with a complete RIFF header.
+func RIFFForm(b []byte) (string, bool) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" {
+		return "", false
 	}
+	return string(b[8:12]), true
+}
 
-	// WebP: RIFF (52 49 46 46) ... WEBP (57 45 42 50)
-	if len(magicBytes) >= 12 {
-		if magicBytes[0] == 0x52 && magicBytes[1] == 0x49 && magicBytes[2] == 0x46 && magicBytes[3] == 0x46 {
-			// Check for WEBP at offset 8
-			if len(magicBytes) >= 12 && magicBytes[8] == 0x57 && magicBytes[9] == 0x45 &&
-				magicBytes[10] == 0x42 && magicBytes[11] == 0x50 {
-				return "WebP"
-			}
+// AVIF: ISO-BMFF ftyp box whose major or compatible brands include avif/avis
+func isAVIF(b []byte) bool {
+	if string(b[4:8]) != "ftyp" {
+		return false
+	}
+	if isAVIFBrand(b[8:12]) {
+		return true
+	}
+	// Compatible brands follow the minor version; scan those within the header
+	end := int(binary.BigEndian.Uint32(b[0:4]))
+	if end > len(b) {
+		end = len(b)
+	}
+	for i := 16; i+4 <= end; i += 4 {
+		if isAVIFBrand(b[i : i+4]) {
+			return true
 		}
 	}
+	return false
+}
 
-	// BMP: 42 4D (BM)
-	if len(magicBytes) >= 2 && magicBytes[0] == 0x42 && magicBytes[1] == 0x4D {
-		return "BMP"
+func isAVIFBrand(b []byte) bool {
+	brand := string(b)
+	return brand == "avif" || brand == "avis"
+}
+
+// JXL codestream: FF 0A
+func isJXLCodestream(b []byte) bool {
+	return b[0] == 0xFF && b[1] == 0x0A
+}
+
+// JXL container: 00 00 00 0C 4A 58 4C 20 0D 0A 87 0A
+func isJXLContainer(b []byte) bool {
+	return string(b[:12]) == string(jxlContainerSig)
+}
+
+// BMP: 42 4D (BM)
+func isBMP(b []byte) bool {
+	return b[0] == 0x42 && b[1] == 0x4D
+}
+
+// ICO: 00 00 01 00 followed by a non-zero image count
+func isICO(b []byte) bool {
+	return b[0] == 0x00 && b[1] == 0x00 && b[2] == 0x01 && b[3] == 0x00 &&
+		(b[4] != 0 || b[5] != 0)
+}
+
+// TGA 2.0: "TRUEVISION-XFILE.\0" in the last 18 bytes
+func isTGAFooter(b []byte) bool {
+	return string(b) == tgaFooterSig
+}
+
+// PSD/PSB: "8BPS" followed by version 1 or 2
+func isPSD(b []byte) bool {
+	return string(b[0:4]) == "8BPS" && b[4] == 0x00 && (b[5] == 0x01 || b[5] == 0x02)
+}
+
+// PNM: "P1" through "P6" followed by whitespace or a comment
+func isPNM(b []byte) bool {
+	if b[0] != 'P' || b[1] < '1' || b[1] > '6' {
+		return false
+	}
+	switch b[2] {
+	case ' ', '\t', '\n', '\r', '#':
+		return true
 	}
+	return false
+}
 
-	return ""
+// Radiance HDR: "#?RADIANCE" or "#?RGBE"
+func isHDR(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("#?RADIANCE")) || bytes.HasPrefix(b, []byte("#?RGBE"))
+}
+
+// DDS: "DDS "
+func isDDS(b []byte) bool {
+	return string(b[0:4]) == "DDS "
+}
+
+// JP2: 00 00 00 0C 6A 50 20 20 0D 0A 87 0A
+func isJP2(b []byte) bool {
+	return string(b[:12]) == string(jp2SignatureBox)
+}
+
+// J2K codestream: FF 4F FF 51 (SOC followed by SIZ)
+func isJ2K(b []byte) bool {
+	return b[0] == 0xFF && b[1] == 0x4F && b[2] == 0xFF && b[3] == 0x51
+}
+
+// QOI: "qoif"
+func isQOI(b []byte) bool {
+	return string(b[0:4]) == "qoif"
+}
+
+// TIFF: "II" 2A 00 (little-endian) or "MM" 00 2A (big-endian)
+func isTIFF(b []byte) bool {
+	return string(b[0:4]) == "II\x2A\x00" || string(b[0:4]) == "MM\x00\x2A"
+}
+
+// CR2: a little-endian TIFF header followed by "CR" and major version 2
+func isCR2(b []byte) bool {
+	return string(b[0:4]) == "II\x2A\x00" && string(b[8:11]) == "CR\x02"
+}
+
+// SVG has no binary magic: sniff for an XML declaration or an <svg> root,
+// skipping a UTF-8 byte order mark and leading whitespace.
+func isSVG(b []byte) bool {
+	if len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF {
+		b = b[3:]
+	}
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n') {
+		b = b[1:]
+	}
+	return bytes.HasPrefix(b, []byte("<?xml")) || bytes.HasPrefix(b, []byte("<svg"))
 }
@@ -1,30 +1,29 @@
 package formats
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-// EXIF tag IDs (commonly used)
+// EXIF tag IDs the parser acts on; names for all known tags live in exiftags.go
 const (
-	exifTagDateTime          = 0x0132
-	exifTagMake              = 0x010F
-	exifTagModel             = 0x0110
-	exifTagOrientation       = 0x0112
-	exifTagXResolution       = 0x011A
-	exifTagYResolution       = 0x011B
-	exifTagResolutionUnit    = 0x0128
-	exifTagSoftware          = 0x0131
-	exifTagArtist            = 0x013B
-	exifTagCopyright         = 0x8298
-	exifTagExifIFD           = 0x8769
-	exifTagGPSIFD            = 0x8825
-	exifTagISO               = 0x8827
-	exifTagExposureTime      = 0x829A
-	exifTagFNumber           = 0x829D
-	exifTagDateTimeOriginal  = 0x9003
-	exifTagDateTimeDigitized = 0x9004
+	exifTagExifIFD    = 0x8769
+	exifTagGPSIFD     = 0x8825
+	exifTagInteropIFD = 0xA005
+)
+
+// IFD1 (thumbnail) tag IDs
+const (
+	exifTagThumbnailOffset = 0x0201
+	exifTagThumbnailLength = 0x0202
 )
 
 // EXIF data types
@@ -39,6 +38,39 @@ const (
 	exifTypeSRational = 10
 )
 
+// maxIFDEntries caps the directory entries read from one EXIF block, however
+// its IFDs point at each other.
+const maxIFDEntries = 8192
+
+// ifdWalk tracks the IFDs read or validated in one EXIF block. IFD0, its
+// sub-IFDs, IFD1 and any MakerNote share one walk, so a pointer back to a
+// directory that was already read, directly or through another directory,
+// is not followed again.
+type ifdWalk struct {
+	visited map[*byte]bool
+	entries int
+	// ownTagsOnly reads only the tags of each IFD, without following the
+	// ExifIFD, GPS and Interop pointers or decoding a MakerNote, for
+	// directories such as the MPF MP Index that hold no sub-IFDs
+	ownTagsOnly bool
+}
+
+func newIFDWalk() *ifdWalk {
+	return &ifdWalk{visited: make(map[*byte]bool)}
+}
+
+// enter reports whether the IFD at offset in data is still to be read and
+// marks it as read. IFDs are keyed by address, since a Nikon MakerNote
+// walks a slice of data with its own offsets.
+func (w *ifdWalk) enter(data []byte, offset int) bool {
+	key := &data[offset]
+	if w.visited[key] {
+		return false
+	}
+	w.visited[key] = true
+	return true
+}
+
 // ParseEXIF extracts EXIF data from a JPEG or PNG file.
 // It searches for the EXIF APP1 segment and parses the TIFF structure.
 func ParseEXIF(r io.ReadSeeker) (map[string]interface{}, error) {
@@ -55,7 +87,7 @@ func ParseEXIF(r io.ReadSeeker) (map[string]interface{}, error) {
 	}
 
 	buf := make([]byte, 4)
-	_, err = r.Read(buf)
+	err = readFull(r, buf)
 	if err != nil {
 		return exif, nil
 	}
@@ -70,7 +102,7 @@ func ParseEXIF(r io.ReadSeeker) (map[string]interface{}, error) {
 
 		// Read segment data
 		segmentData := make([]byte, length-2)
-		_, err = r.Read(segmentData)
+		err = readFull(r, segmentData)
 		if err != nil {
 			return exif, nil
 		}
@@ -78,7 +110,7 @@ func ParseEXIF(r io.ReadSeeker) (map[string]interface{}, error) {
 		// Check for "Exif\0\0" identifier
 		if len(segmentData) >= 6 && string(segmentData[0:6]) == "Exif\x00\x00" {
 			// Parse TIFF header and IFD
-			exifData, err := parseTIFF(segmentData[6:])
+			exifData, err := parseTIFF(segmentData[6:], Options{})
 			if err == nil {
 				for k, v := range exifData {
 					exif[k] = v
@@ -92,14 +124,19 @@ func ParseEXIF(r io.ReadSeeker) (map[string]interface{}, error) {
 	return exif, nil
 }
 
+// DecodeEXIF decodes a TIFF-structured EXIF block, such as the
+// Result.RawEXIF kept with Options.DeferEXIF. GroupEXIF and Logger apply as
+// they do during extraction.
+func DecodeEXIF(data []byte, opts Options) (map[string]interface{}, error) {
+	return parseTIFF(data, opts)
+}
+
 // parseTIFF parses a TIFF structure (used by EXIF)
-func parseTIFF(data []byte) (map[string]interface{}, error) {
+func parseTIFF(data []byte, opts Options) (map[string]interface{}, error) {
 	if len(data) < 8 {
 		return nil, fmt.Errorf("insufficient data for TIFF header")
 	}
 
-	exif := make(map[string]interface{})
-
 	// Check byte order (II for little-endian, MM for big-endian)
 	var byteOrder binary.ByteOrder
 	if data[0] == 0x49 && data[1] == 0x49 {
@@ -116,68 +153,587 @@ func parseTIFF(data []byte) (map[string]interface{}, error) {
 	}
 
 	// Get offset to first IFD
-	ifdOffset := int(byteOrder.Uint32(data[4:8]))
-	if ifdOffset >= len(data) {
+	rawOffset := byteOrder.Uint32(data[4:8])
+	if uint64(rawOffset) >= uint64(len(data)) {
 		return nil, fmt.Errorf("IFD offset out of bounds")
 	}
+	ifdOffset := int(rawOffset)
 
-	// Parse IFD
-	parseIFD(data, ifdOffset, byteOrder, exif, 0)
+	// parseIFD skips what validateTIFF rejects, so only the first problem
+	// is reported
+	if opts.Logger != nil {
+		if err := validateTIFF(data); err != nil {
+			opts.log("truncated EXIF IFD", "error", err)
+		}
+	}
 
-	return exif, nil
+	// Parse IFD0, then the thumbnail directory (IFD1) that follows it
+	walk := newIFDWalk()
+	ifd0 := make(map[string]interface{})
+	next := walk.parseIFD(data, ifdOffset, byteOrder, ifd0, 0, getEXIFTagName)
+	groups := map[string]interface{}{"IFD0": ifd0}
+	hoistEXIFGroups(ifd0, groups)
+	for _, group := range groups {
+		describeEXIF(group.(map[string]interface{}))
+		decodeAPEX(group.(map[string]interface{}))
+	}
+	if next > 0 && next < len(data) {
+		ifd1 := make(map[string]interface{})
+		walk.parseIFD(data, next, byteOrder, ifd1, 0, getIFD1TagName)
+		if len(ifd1) > 0 {
+			groups["IFD1"] = ifd1
+		}
+	}
+
+	if opts.GroupEXIF {
+		return groups, nil
+	}
+	return FlattenEXIF(groups), nil
 }
 
-// parseIFD parses an Image File Directory
-func parseIFD(data []byte, offset int, byteOrder binary.ByteOrder, exif map[string]interface{}, depth int) {
-	if depth > 10 || offset+2 > len(data) {
-		return // Prevent infinite recursion
+// exifSubGroups names the directories parseIFD nests under the IFD holding
+// their pointer.
+var exifSubGroups = []string{"ExifIFD", "GPS", "Interop"}
+
+// hoistEXIFGroups moves the directories nested in m up into groups.
+func hoistEXIFGroups(m, groups map[string]interface{}) {
+	for _, name := range exifSubGroups {
+		if sub, ok := m[name].(map[string]interface{}); ok {
+			delete(m, name)
+			groups[name] = sub
+			hoistEXIFGroups(sub, groups)
+		}
 	}
+}
 
-	// Get number of directory entries
-	if offset+2 > len(data) {
-		return
+// FlattenEXIF merges EXIF grouped by directory, as returned with
+// Options.GroupEXIF, into the default flat map: the IFD0, ExifIFD and GPS
+// tags at the top level, and the Interop and IFD1 directories as nested
+// maps. Where a tag appears in several directories the later one wins.
+func FlattenEXIF(grouped map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for _, name := range []string{"IFD0", "ExifIFD", "GPS"} {
+		group, _ := grouped[name].(map[string]interface{})
+		for k, v := range group {
+			flat[k] = v
+		}
+	}
+	for _, name := range []string{"Interop", "IFD1"} {
+		if group, ok := grouped[name].(map[string]interface{}); ok {
+			flat[name] = group
+		}
 	}
+	return flat
+}
+
+// parseIFD parses an Image File Directory, naming tags with tagName. It
+// returns the offset of the next IFD in the chain, or 0 if there is none.
+// An IFD the walk has already read is skipped.
+func (w *ifdWalk) parseIFD(data []byte, offset int, byteOrder binary.ByteOrder, exif map[string]interface{}, depth int, tagName func(uint16) string) int {
+	if depth > 10 || offset < 0 || offset+2 > len(data) {
+		return 0 // Prevent infinite recursion
+	}
+	if !w.enter(data, offset) {
+		return 0
+	}
+
+	// Get number of directory entries
 	numEntries := int(byteOrder.Uint16(data[offset : offset+2]))
+	nextPtr := offset + 2 + 12*numEntries
 
 	offset += 2
 
 	// Parse each entry
 	for i := 0; i < numEntries && offset+12 <= len(data); i++ {
+		if w.entries++; w.entries > maxIFDEntries {
+			return 0
+		}
 		tag := byteOrder.Uint16(data[offset : offset+2])
 		dataType := byteOrder.Uint16(data[offset+2 : offset+4])
 		count := byteOrder.Uint32(data[offset+4 : offset+8])
 		valueOffset := byteOrder.Uint32(data[offset+8 : offset+12])
 
-		// Read tag value
-		var value interface{}
-		valueSize := getDataTypeSize(dataType) * int(count)
+		// Locate the raw tag value. Sizes and offsets are computed in 64 bits
+		// so corrupt counts cannot overflow int and slip past the bounds check
+		var raw []byte
+		valueSize := uint64(getDataTypeSize(dataType)) * uint64(count)
+		inline := valueSize <= 4
 
-		if valueSize <= 4 {
+		if inline {
 			// Value is stored directly in the offset field
-			value = readTagValue(data[offset+8:offset+12], dataType, count, byteOrder)
-		} else {
+			raw = data[offset+8 : offset+12]
+		} else if end := uint64(valueOffset) + valueSize; end <= uint64(len(data)) {
 			// Value is stored at the offset
-			valOffset := int(valueOffset)
-			if valOffset < len(data) && valOffset+valueSize <= len(data) {
-				value = readTagValue(data[valOffset:valOffset+valueSize], dataType, count, byteOrder)
-			}
+			raw = data[valueOffset:end]
 		}
 
 		// Map tag to name and store
-		if tagName := getEXIFTagName(tag); tagName != "" {
-			exif[tagName] = value
+		if name := tagName(tag); name != "" && raw != nil {
+			switch name {
+			case "UserComment", "GPSProcessingMethod", "GPSAreaInformation":
+				exif[name] = decodeCharacterCodeText(raw[:min(int(count), len(raw))], byteOrder)
+			case "XPTitle", "XPComment", "XPAuthor", "XPSubject":
+				exif[name] = decodeXPText(raw[:min(int(count), len(raw))])
+			case "XPKeywords":
+				exif[name] = splitXPKeywords(decodeXPText(raw[:min(int(count), len(raw))]))
+			case "ExifVersion", "FlashpixVersion", "InteropVersion":
+				exif[name] = decodeVersion(raw, dataType, count, byteOrder)
+			case "ComponentsConfiguration":
+				exif[name] = decodeComponents(raw, dataType, count, byteOrder)
+			default:
+				exif[name] = readTagValue(raw, dataType, count, byteOrder)
+			}
+		}
+
+		if tag == exifTagMakerNote && raw != nil && !inline && !w.ownTagsOnly {
+			if note := parseMakerNote(w, data, raw, int(valueOffset), byteOrder, exif, depth+1); len(note) > 0 {
+				exif["MakerNote"] = note
+			}
 		}
 
 		// Handle IFD pointers
-		if tag == exifTagExifIFD && valueSize <= 4 {
+		if inline && uint64(valueOffset) < uint64(len(data)) && !w.ownTagsOnly {
 			ifdPtr := int(valueOffset)
-			if ifdPtr < len(data) {
-				parseIFD(data, ifdPtr, byteOrder, exif, depth+1)
+			switch tag {
+			case exifTagExifIFD:
+				// The MakerNote decoder picks the vendor from IFD0's Make
+				sub := make(map[string]interface{})
+				cameraMake, hasMake := exif["Make"]
+				if hasMake {
+					sub["Make"] = cameraMake
+				}
+				w.parseIFD(data, ifdPtr, byteOrder, sub, depth+1, getEXIFTagName)
+				if hasMake {
+					delete(sub, "Make")
+				}
+				exif["ExifIFD"] = sub
+			case exifTagGPSIFD:
+				gps := make(map[string]interface{})
+				w.parseIFD(data, ifdPtr, byteOrder, gps, depth+1, getGPSTagName)
+				decodeGPS(gps)
+				exif["GPS"] = gps
+			case exifTagInteropIFD:
+				interop := make(map[string]interface{})
+				w.parseIFD(data, ifdPtr, byteOrder, interop, depth+1, getInteropTagName)
+				if len(interop) > 0 {
+					exif["Interop"] = interop
+				}
 			}
 		}
 
 		offset += 12
 	}
+
+	if nextPtr+4 > len(data) {
+		return 0
+	}
+	next := byteOrder.Uint32(data[nextPtr : nextPtr+4])
+	if uint64(next) >= uint64(len(data)) {
+		return 0
+	}
+	return int(next)
+}
+
+// validateTIFF walks every IFD reachable from the TIFF header and reports
+// the first directory, tag value or IFD pointer that lies outside data. It
+// backs strict parsing; parseTIFF silently skips such entries instead.
+func validateTIFF(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("%w: TIFF header too short", ErrInvalidData)
+	}
+	var byteOrder binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: invalid TIFF byte order", ErrInvalidData)
+	}
+	if byteOrder.Uint16(data[2:4]) != 42 {
+		return fmt.Errorf("%w: invalid TIFF magic number", ErrInvalidData)
+	}
+	return newIFDWalk().validateIFD(data, uint64(byteOrder.Uint32(data[4:8])), byteOrder, 0)
+}
+
+// validateIFD checks one IFD, the sub-IFDs it points to and, for IFD0, the
+// IFD1 chained after it. Reaching an IFD the walk has already checked is an
+// error.
+func (w *ifdWalk) validateIFD(data []byte, offset uint64, byteOrder binary.ByteOrder, depth int) error {
+	if depth > 10 {
+		return fmt.Errorf("%w: IFDs nested too deeply", ErrInvalidData)
+	}
+	size := uint64(len(data))
+	if offset+2 > size {
+		return fmt.Errorf("%w: IFD offset %d past the end of %d-byte EXIF data", ErrInvalidData, offset, size)
+	}
+	if !w.enter(data, int(offset)) {
+		return fmt.Errorf("%w: IFD at %d is reached more than once", ErrInvalidData, offset)
+	}
+
+	numEntries := uint64(byteOrder.Uint16(data[offset : offset+2]))
+	end := offset + 2 + 12*numEntries + 4
+	if end > size {
+		return fmt.Errorf("%w: IFD at %d with %d entries overruns the EXIF data", ErrInvalidData, offset, numEntries)
+	}
+
+	if w.entries += int(numEntries); w.entries > maxIFDEntries {
+		return fmt.Errorf("%w: more than %d IFD entries", ErrInvalidData, maxIFDEntries)
+	}
+
+	for entry := offset + 2; entry < end-4; entry += 12 {
+		tag := byteOrder.Uint16(data[entry : entry+2])
+		dataType := byteOrder.Uint16(data[entry+2 : entry+4])
+		count := byteOrder.Uint32(data[entry+4 : entry+8])
+		valueOffset := uint64(byteOrder.Uint32(data[entry+8 : entry+12]))
+
+		valueSize := uint64(getDataTypeSize(dataType)) * uint64(count)
+		if valueSize > 4 && valueOffset+valueSize > size {
+			return fmt.Errorf("%w: tag 0x%04X value at %d overruns the EXIF data", ErrInvalidData, tag, valueOffset)
+		}
+		if tag == exifTagExifIFD || tag == exifTagGPSIFD || tag == exifTagInteropIFD {
+			if err := w.validateIFD(data, valueOffset, byteOrder, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	next := uint64(byteOrder.Uint32(data[end-4 : end]))
+	if next == 0 {
+		return nil
+	}
+	if depth > 0 || next == offset {
+		// Only IFD0 chains to another directory that parseTIFF reads
+		if next >= size {
+			return fmt.Errorf("%w: next IFD offset %d past the end of the EXIF data", ErrInvalidData, next)
+		}
+		return nil
+	}
+	return w.validateIFD(data, next, byteOrder, depth+1)
+}
+
+// gpsSpeedUnits converts each GPSSpeedRef unit to kilometers per hour.
+var gpsSpeedUnits = map[string]float64{
+	"K": 1,        // km/h
+	"M": 1.609344, // mph
+	"N": 1.852,    // knots
+}
+
+// exifDescriptions names the values of enumerated EXIF tags, using
+// exiftool's wording so output is comparable.
+var exifDescriptions = map[string]map[uint16]string{
+	"Orientation": {
+		1: "Horizontal (normal)",
+		2: "Mirror horizontal",
+		3: "Rotate 180",
+		4: "Mirror vertical",
+		5: "Mirror horizontal and rotate 270 CW",
+		6: "Rotate 90 CW",
+		7: "Mirror horizontal and rotate 90 CW",
+		8: "Rotate 270 CW",
+	},
+	"Flash": {
+		0x00: "No Flash",
+		0x01: "Fired",
+		0x05: "Fired, Return not detected",
+		0x07: "Fired, Return detected",
+		0x08: "On, Did not fire",
+		0x09: "On, Fired",
+		0x0D: "On, Return not detected",
+		0x0F: "On, Return detected",
+		0x10: "Off, Did not fire",
+		0x14: "Off, Did not fire, Return not detected",
+		0x18: "Auto, Did not fire",
+		0x19: "Auto, Fired",
+		0x1D: "Auto, Fired, Return not detected",
+		0x1F: "Auto, Fired, Return detected",
+		0x20: "No flash function",
+		0x30: "Off, No flash function",
+		0x41: "Fired, Red-eye reduction",
+		0x45: "Fired, Red-eye reduction, Return not detected",
+		0x47: "Fired, Red-eye reduction, Return detected",
+		0x49: "On, Red-eye reduction",
+		0x4D: "On, Red-eye reduction, Return not detected",
+		0x4F: "On, Red-eye reduction, Return detected",
+		0x50: "Off, Red-eye reduction",
+		0x58: "Auto, Did not fire, Red-eye reduction",
+		0x59: "Auto, Fired, Red-eye reduction",
+		0x5D: "Auto, Fired, Red-eye reduction, Return not detected",
+		0x5F: "Auto, Fired, Red-eye reduction, Return detected",
+	},
+	"MeteringMode": {
+		0:   "Unknown",
+		1:   "Average",
+		2:   "Center-weighted average",
+		3:   "Spot",
+		4:   "Multi-spot",
+		5:   "Multi-segment",
+		6:   "Partial",
+		255: "Other",
+	},
+	"ExposureProgram": {
+		0: "Not Defined",
+		1: "Manual",
+		2: "Program AE",
+		3: "Aperture-priority AE",
+		4: "Shutter speed priority AE",
+		5: "Creative (Slow speed)",
+		6: "Action (High speed)",
+		7: "Portrait",
+		8: "Landscape",
+		9: "Bulb",
+	},
+	"WhiteBalance": {
+		0: "Auto",
+		1: "Manual",
+	},
+}
+
+// describeEXIF adds a human-readable <Tag>Description entry next to each
+// enumerated tag, keeping the raw numeric value. Values outside the table
+// are described as "Unknown".
+func describeEXIF(exif map[string]interface{}) {
+	for name, table := range exifDescriptions {
+		value, ok := exif[name].(uint16)
+		if !ok {
+			continue
+		}
+		desc, ok := table[value]
+		if !ok {
+			desc = "Unknown"
+		}
+		exif[name+"Description"] = desc
+	}
+}
+
+// decodeAPEX adds readable ShutterSpeed and Aperture strings derived from
+// the APEX ShutterSpeedValue and ApertureValue, keeping the raw values. The
+// exposure time is 2^-Tv seconds and the f-number √2^Av.
+func decodeAPEX(exif map[string]interface{}) {
+	if tv, ok := apexValue(exif["ShutterSpeedValue"]); ok {
+		exif["ShutterSpeed"] = formatExposureTime(math.Pow(2, -tv))
+	}
+	if av, ok := apexValue(exif["ApertureValue"]); ok {
+		exif["Aperture"] = formatFNumber(math.Pow(math.Sqrt2, av))
+	}
+}
+
+// apexValue reads an APEX value, accepting an unsigned rational from
+// writers that ignore the SRATIONAL type of ShutterSpeedValue. Values
+// beyond ±64 stops are rejected as corrupt.
+func apexValue(v interface{}) (float64, bool) {
+	f, ok := exifFloat(v)
+	return f, ok && math.Abs(f) < 64
+}
+
+// formatExposureTime renders seconds as exiftool does: "1/250" up to a
+// quarter second, otherwise a decimal such as "0.5" or "2".
+func formatExposureTime(secs float64) string {
+	if secs > 0 && secs < 0.25001 {
+		return "1/" + strconv.Itoa(int(0.5+1/secs))
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(secs, 'f', 1, 64), ".0")
+}
+
+// formatFNumber renders an f-number as "f/2.8", keeping two decimals below 1.
+func formatFNumber(f float64) string {
+	if f < 1 {
+		return "f/" + strconv.FormatFloat(f, 'f', 2, 64)
+	}
+	return "f/" + strconv.FormatFloat(f, 'f', 1, 64)
+}
+
+// decodeGPS normalizes the raw GPS IFD values:
+//   - GPSLatitude/GPSLongitude become signed decimal degrees, with the
+//     degrees/minutes/seconds triples kept under GPSLatitudeDMS/GPSLongitudeDMS
+//   - GPSAltitude becomes signed meters relative to sea level
+//   - GPSSpeed becomes kilometers per hour
+//   - GPSDateStamp and GPSTimeStamp are combined into an RFC 3339 GPSDateTime
+//
+// Missing reference tags default as in the EXIF specification (north, east,
+// above sea level, km/h).
+func decodeGPS(gps map[string]interface{}) {
+	for _, axis := range []struct{ name, negative string }{
+		{"GPSLatitude", "S"},
+		{"GPSLongitude", "W"},
+	} {
+		dms, ok := gps[axis.name].([]Rational)
+		if !ok || len(dms) != 3 || !finiteRationals(dms) {
+			continue
+		}
+		degrees := dms[0].Float64() + dms[1].Float64()/60 + dms[2].Float64()/3600
+		if ref, _ := gps[axis.name+"Ref"].(string); strings.EqualFold(ref, axis.negative) {
+			degrees = -degrees
+		}
+		gps[axis.name+"DMS"] = dms
+		gps[axis.name] = degrees
+	}
+
+	if altitude, ok := gps["GPSAltitude"].(Rational); ok && altitude.Den != 0 {
+		meters := altitude.Float64()
+		if ref, _ := gps["GPSAltitudeRef"].(uint8); ref == 1 {
+			meters = -meters
+		}
+		gps["GPSAltitude"] = meters
+	}
+
+	if speed, ok := gps["GPSSpeed"].(Rational); ok && speed.Den != 0 {
+		ref, _ := gps["GPSSpeedRef"].(string)
+		scale, ok := gpsSpeedUnits[strings.ToUpper(ref)]
+		if !ok {
+			scale = 1
+		}
+		gps["GPSSpeed"] = speed.Float64() * scale
+	}
+
+	date, _ := gps["GPSDateStamp"].(string)
+	clock, _ := gps["GPSTimeStamp"].([]Rational)
+	if day, err := time.Parse("2006:01:02", date); err == nil && len(clock) == 3 && finiteRationals(clock) {
+		offset := time.Duration(clock[0].Float64()*float64(time.Hour) +
+			clock[1].Float64()*float64(time.Minute) +
+			clock[2].Float64()*float64(time.Second))
+		gps["GPSDateTime"] = day.Add(offset).UTC().Format(time.RFC3339)
+	}
+}
+
+// finiteRationals reports whether every value has a non-zero denominator.
+func finiteRationals(vals []Rational) bool {
+	for _, v := range vals {
+		if v.Den == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeVersion decodes a version tag such as ExifVersion, stored as four
+// ASCII digits ("0230"). Values that are not printable ASCII are kept raw.
+func decodeVersion(raw []byte, dataType uint16, count uint32, byteOrder binary.ByteOrder) interface{} {
+	version := bytes.TrimRight(raw[:min(int(count), len(raw))], "\x00")
+	for _, c := range version {
+		if c < 0x20 || c > 0x7E {
+			return readTagValue(raw, dataType, count, byteOrder)
+		}
+	}
+	return string(version)
+}
+
+// componentNames names the ComponentsConfiguration channel codes.
+var componentNames = []string{"-", "Y", "Cb", "Cr", "R", "G", "B"}
+
+// decodeComponents decodes ComponentsConfiguration into the channel order,
+// e.g. "Y,Cb,Cr,-". Values with unknown codes are kept raw.
+func decodeComponents(raw []byte, dataType uint16, count uint32, byteOrder binary.ByteOrder) interface{} {
+	codes := raw[:min(int(count), len(raw))]
+	names := make([]string, len(codes))
+	for i, c := range codes {
+		if int(c) >= len(componentNames) {
+			return readTagValue(raw, dataType, count, byteOrder)
+		}
+		names[i] = componentNames[c]
+	}
+	return strings.Join(names, ",")
+}
+
+// splitASCII removes the NUL terminator and padding of an ASCII value. A
+// value packing several NUL-separated strings, such as Copyright's
+// photographer and editor entries, becomes a []string; a single string
+// stays a string.
+func splitASCII(str string) interface{} {
+	str = strings.TrimRight(str, "\x00")
+	if !strings.Contains(str, "\x00") {
+		return str
+	}
+	var parts []string
+	for _, part := range strings.Split(str, "\x00") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return parts
+}
+
+// decodeXPText decodes a Windows Explorer property: little-endian UTF-16
+// regardless of the TIFF byte order, terminated by a NUL.
+func decodeXPText(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units))
+}
+
+// splitXPKeywords splits the semicolon-separated XPKeywords list.
+func splitXPKeywords(s string) []string {
+	keywords := []string{}
+	for _, k := range strings.Split(s, ";") {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
+
+// decodeCharacterCodeText decodes a text value prefixed by the 8-byte EXIF
+// character code (ASCII, UNICODE, JIS or undefined), as used by UserComment
+// and the GPS text tags. Trailing NULs and spaces are trimmed.
+func decodeCharacterCodeText(b []byte, byteOrder binary.ByteOrder) string {
+	if len(b) < 8 {
+		return strings.TrimRight(string(b), "\x00 ")
+	}
+
+	code, text := b[:8], b[8:]
+	var s string
+
+	switch {
+	case bytes.HasPrefix(code, []byte("ASCII")):
+		s = string(text)
+
+	case bytes.HasPrefix(code, []byte("UNICODE")):
+		// UCS-2 in the TIFF byte order unless a byte order mark says otherwise
+		order := byteOrder
+		if len(text) >= 2 {
+			switch {
+			case text[0] == 0xFE && text[1] == 0xFF:
+				order, text = binary.BigEndian, text[2:]
+			case text[0] == 0xFF && text[1] == 0xFE:
+				order, text = binary.LittleEndian, text[2:]
+			}
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[i*2 : i*2+2])
+		}
+		s = string(utf16.Decode(units))
+
+	case bytes.HasPrefix(code, []byte("JIS")):
+		// JIS X 0208 has no standard library decoder; keep the raw bytes
+		s = string(text)
+
+	default:
+		s = decodeTextBytes(text)
+	}
+
+	return strings.TrimRight(s, "\x00 ")
+}
+
+// decodeTextBytes decodes text of an unknown charset: UTF-8 when valid,
+// otherwise Latin-1.
+func decodeTextBytes(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
 }
 
 // getDataTypeSize returns the size in bytes of an EXIF data type
@@ -199,20 +755,21 @@ func getDataTypeSize(dataType uint16) int {
 // readTagValue reads a tag value based on its data type
 func readTagValue(data []byte, dataType uint16, count uint32, byteOrder binary.ByteOrder) interface{} {
 	switch dataType {
-	case exifTypeByte, exifTypeUndefined:
+	case exifTypeByte:
 		if count == 1 && len(data) >= 1 {
 			return uint8(data[0])
 		}
 		return data[:min(int(count), len(data))]
 
+	case exifTypeUndefined:
+		if count == 1 && len(data) >= 1 {
+			return uint8(data[0])
+		}
+		return Undefined(data[:min(int(count), len(data))])
+
 	case exifTypeASCII:
 		if len(data) >= int(count) {
-			str := string(data[:count])
-			// Remove null terminator
-			if len(str) > 0 && str[len(str)-1] == 0 {
-				str = str[:len(str)-1]
-			}
-			return str
+			return splitASCII(string(data[:count]))
 		}
 		return ""
 
@@ -244,63 +801,37 @@ func readTagValue(data []byte, dataType uint16, count uint32, byteOrder binary.B
 		}
 		return vals
 
-	case exifTypeRational, exifTypeSRational:
-		if count == 1 && len(data) >= 8 {
-			num := byteOrder.Uint32(data[0:4])
-			den := byteOrder.Uint32(data[4:8])
-			if den == 0 {
-				return float64(0)
+	case exifTypeRational:
+		vals := make([]Rational, min(int(count), len(data)/8))
+		for i := range vals {
+			vals[i] = Rational{
+				Num: int64(byteOrder.Uint32(data[i*8 : i*8+4])),
+				Den: int64(byteOrder.Uint32(data[i*8+4 : i*8+8])),
 			}
-			if dataType == exifTypeSRational {
-				return float64(int32(num)) / float64(int32(den))
+		}
+		if count == 1 && len(vals) == 1 {
+			return vals[0]
+		}
+		return vals
+
+	case exifTypeSRational:
+		vals := make([]SRational, min(int(count), len(data)/8))
+		for i := range vals {
+			vals[i] = SRational{
+				Num: int64(int32(byteOrder.Uint32(data[i*8 : i*8+4]))),
+				Den: int64(int32(byteOrder.Uint32(data[i*8+4 : i*8+8]))),
 			}
-			return float64(num) / float64(den)
 		}
-		return nil
+		if count == 1 && len(vals) == 1 {
+			return vals[0]
+		}
+		return vals
 
 	default:
 		return nil
 	}
 }
 
-// getEXIFTagName returns the human-readable name for an EXIF tag
-func getEXIFTagName(tag uint16) string {
-	switch tag {
-	case exifTagDateTime:
-		return "DateTime"
-	case exifTagMake:
-		return "Make"
-	case exifTagModel:
-		return "Model"
-	case exifTagOrientation:
-		return "Orientation"
-	case exifTagXResolution:
-		return "XResolution"
-	case exifTagYResolution:
-		return "YResolution"
-	case exifTagResolutionUnit:
-		return "ResolutionUnit"
-	case exifTagSoftware:
-		return "Software"
-	case exifTagArtist:
-		return "Artist"
-	case exifTagCopyright:
-		return "Copyright"
-	case exifTagISO:
-		return "ISO"
-	case exifTagExposureTime:
-		return "ExposureTime"
-	case exifTagFNumber:
-		return "FNumber"
-	case exifTagDateTimeOriginal:
-		return "DateTimeOriginal"
-	case exifTagDateTimeDigitized:
-		return "DateTimeDigitized"
-	default:
-		return ""
-	}
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,89 @@
+package formats
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FormatEXIF renders a parsed EXIF map the way exiftool prints it:
+// ExposureTime "1/200", FNumber "f/2.8", FocalLength "50.0 mm",
+// ExposureBiasValue "-0.33 EV", ISO "100", and enumerated tags such as
+// Flash by their description. Text tags are copied as is, joining multiple
+// strings with ", ", while binary, nested and unrecognised numeric values
+// are left out. exif is not modified.
+func FormatEXIF(exif map[string]interface{}) map[string]string {
+	formatted := make(map[string]string)
+	for name, v := range exif {
+		switch s := v.(type) {
+		case string:
+			formatted[name] = s
+		case []string:
+			formatted[name] = strings.Join(s, ", ")
+		}
+	}
+
+	// Derived strings replace the raw values they describe
+	for name := range exifDescriptions {
+		if desc, ok := formatted[name+"Description"]; ok {
+			formatted[name] = desc
+			delete(formatted, name+"Description")
+		}
+	}
+	for raw, derived := range map[string]string{"ShutterSpeedValue": "ShutterSpeed", "ApertureValue": "Aperture"} {
+		if s, ok := formatted[derived]; ok {
+			formatted[raw] = s
+			delete(formatted, derived)
+		}
+	}
+
+	if v, ok := exifFloat(exif["ExposureTime"]); ok && v > 0 {
+		formatted["ExposureTime"] = formatExposureTime(v)
+	}
+	if v, ok := exifFloat(exif["FNumber"]); ok && v > 0 {
+		formatted["FNumber"] = formatFNumber(v)
+	}
+	if v, ok := exifFloat(exif["FocalLength"]); ok {
+		formatted["FocalLength"] = strconv.FormatFloat(v, 'f', 1, 64) + " mm"
+	}
+	if v, ok := exifFloat(exif["FocalLengthIn35mmFilm"]); ok {
+		formatted["FocalLengthIn35mmFilm"] = strconv.FormatFloat(v, 'f', 0, 64) + " mm"
+	}
+	if v, ok := exifFloat(exif["ExposureBiasValue"]); ok {
+		bias := strconv.FormatFloat(v, 'f', 2, 64)
+		bias = strings.TrimSuffix(strings.TrimRight(bias, "0"), ".")
+		if bias == "-0" {
+			bias = "0"
+		}
+		formatted["ExposureBiasValue"] = bias + " EV"
+	}
+	if v, ok := exifFloat(exif["ISO"]); ok {
+		formatted["ISO"] = strconv.FormatFloat(v, 'f', 0, 64)
+	}
+
+	return formatted
+}
+
+// exifFloat reads a numeric EXIF value, taking the first of several.
+// Rationals with a zero denominator report false.
+func exifFloat(v interface{}) (float64, bool) {
+	var f float64
+	switch n := v.(type) {
+	case uint16:
+		f = float64(n)
+	case uint32:
+		f = float64(n)
+	case []uint16:
+		if len(n) == 0 {
+			return 0, false
+		}
+		f = float64(n[0])
+	case Rational:
+		f = n.Float64()
+	case SRational:
+		f = n.Float64()
+	default:
+		return 0, false
+	}
+	return f, !math.IsNaN(f) && !math.IsInf(f, 0)
+}
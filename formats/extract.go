@@ -7,18 +7,16 @@ import (
 
 // Extract dispatches to the appropriate format parser based on the format string.
 func Extract(format string, r io.ReadSeeker) (*Result, error) {
-	switch format {
-	case "JPEG":
-		return ExtractJPEG(r)
-	case "PNG":
-		return ExtractPNG(r)
-	case "GIF":
-		return ExtractGIF(r)
-	case "WebP":
-		return ExtractWebP(r)
-	case "BMP":
-		return ExtractBMP(r)
-	default:
+	return ExtractWithOptions(format, r, Options{})
+}
+
+// ExtractWithOptions is like Extract but lets callers tune the parsers.
+func ExtractWithOptions(format string, r io.ReadSeeker, opts Options) (*Result, error) {
+	registryMu.RLock()
+	parser, ok := parsers[format]
+	registryMu.RUnlock()
+	if !ok {
 		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
 	}
+	return parser(r, opts)
 }
@@ -1,22 +1,35 @@
 package formats
 
 import (
+	"bytes"
+	"compress/lzw"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// maxUniqueColorPixels bounds how many pixels are decoded when estimating
+// the number of palette entries a GIF uses.
+const maxUniqueColorPixels = 4 << 20
+
 // ExtractGIF extracts metadata from a GIF file.
 func ExtractGIF(r io.ReadSeeker) (*Result, error) {
+	return extractGIF(r, Options{})
+}
+
+func extractGIF(r io.ReadSeeker, opts Options) (*Result, error) {
 	// Reset to beginning
 	_, err := r.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
+	// The walk is made of many small reads and skips
+	r = newBufferedReadSeeker(r, 0)
 
 	// Read GIF signature (6 bytes)
 	sig := make([]byte, 6)
-	_, err = r.Read(sig)
+	err = readFull(r, sig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read GIF signature: %w", err)
 	}
@@ -32,7 +45,7 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 
 	// Read Logical Screen Descriptor (7 bytes)
 	lsd := make([]byte, 7)
-	_, err = r.Read(lsd)
+	err = readFull(r, lsd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read GIF logical screen descriptor: %w", err)
 	}
@@ -55,7 +68,9 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 	pixelAspectRatio := lsd[6]
 
 	result.ColorSpace = "Indexed"
-	result.ColorDepth = colorResolution * 3 // Approximate
+	// Pixels are palette indices; the palette entries themselves are 24-bit RGB
+	result.ColorDepth = globalColorTableSize
+	result.Additional["PaletteBitDepth"] = 24
 	result.Additional["GlobalColorTable"] = globalColorTableFlag
 	result.Additional["ColorResolution"] = colorResolution
 	result.Additional["SortFlag"] = sortFlag
@@ -63,10 +78,19 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 	result.Additional["BackgroundColorIndex"] = backgroundColorIndex
 	result.Additional["PixelAspectRatio"] = pixelAspectRatio
 
-	// Skip global color table if present
+	// Read the global color table, or skip it when the palette is not wanted
 	if globalColorTableFlag {
 		colorTableSize := 3 * (1 << globalColorTableSize)
-		r.Seek(int64(colorTableSize), io.SeekCurrent)
+		if opts.SkipPalette {
+			r.Seek(int64(colorTableSize), io.SeekCurrent)
+		} else {
+			table := make([]byte, colorTableSize)
+			if err := readFull(r, table); err != nil {
+				return nil, fmt.Errorf("failed to read GIF global color table: %w", err)
+			}
+			palette, _ := parsePLTE(table)
+			result.Additional["Palette"] = palette
+		}
 	}
 
 	// Check for transparency and animation by scanning extension blocks
@@ -74,9 +98,22 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 	hasAnimation := false
 	frameCount := 0
 
-	for {
+	// Frame delays in milliseconds
+	frameDelays := []int{}
+	pendingDelay, duration := 0, 0
+	var comments []string
+
+	// Palette indices seen while estimating unique colors
+	var usedColors [256]bool
+	pixelBudget := maxUniqueColorPixels
+
+	// A read error stops the walk but keeps what was read before it
+	var parseErr error
+	trailer := false
+blocks:
+	for !trailer {
 		blockType := make([]byte, 1)
-		_, err = r.Read(blockType)
+		err = readFull(r, blockType)
 		if err != nil {
 			break
 		}
@@ -84,66 +121,87 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 		switch blockType[0] {
 		case 0x21: // Extension introducer
 			extLabel := make([]byte, 1)
-			_, err = r.Read(extLabel)
+			err = readFull(r, extLabel)
 			if err != nil {
-				return nil, err
+				parseErr = fmt.Errorf("failed to read GIF extension label: %w", err)
+				break blocks
 			}
 
 			switch extLabel[0] {
 			case 0xF9: // Graphic Control Extension
-				// Read block size (should be 4)
-				blockSize := make([]byte, 1)
-				r.Read(blockSize)
-				if blockSize[0] == 4 {
-					gceData := make([]byte, 4)
-					r.Read(gceData)
-					// Check transparency flag
-					if (gceData[0] & 0x01) != 0 {
-						hasTransparency = true
+				gceData, err := readGIFSubBlocks(r, true)
+				if err != nil {
+					parseErr = fmt.Errorf("failed to read GIF graphic control extension: %w", err)
+					break blocks
+				}
+				// Check transparency flag; the first transparent index is
+				// reported so callers can map it in Palette
+				if len(gceData) >= 1 && (gceData[0]&0x01) != 0 {
+					if !hasTransparency && len(gceData) >= 4 {
+						result.Additional["TransparentIndex"] = int(gceData[3])
 					}
+					hasTransparency = true
+				}
+				// Delay time in hundredths of a second, applied to the next frame
+				if len(gceData) >= 3 {
+					pendingDelay = int(binary.LittleEndian.Uint16(gceData[1:3])) * 10
 				}
-				// Skip terminator
-				r.Read(make([]byte, 1))
 
 			case 0xFF: // Application Extension (may contain animation info)
 				blockSize := make([]byte, 1)
-				r.Read(blockSize)
-				if blockSize[0] == 11 {
-					appData := make([]byte, 11)
-					r.Read(appData)
-					if string(appData) == "NETSCAPE2.0" {
-						hasAnimation = true
-					}
-					// Skip sub-blocks
-					for {
-						subBlockSize := make([]byte, 1)
-						r.Read(subBlockSize)
-						if subBlockSize[0] == 0 {
-							break
-						}
-						subBlockData := make([]byte, int(subBlockSize[0]))
-						r.Read(subBlockData)
-					}
+				err = readFull(r, blockSize)
+				if err != nil {
+					parseErr = fmt.Errorf("failed to read GIF application extension: %w", err)
+					break blocks
+				}
+				appData := make([]byte, int(blockSize[0]))
+				err = readFull(r, appData)
+				if err != nil {
+					parseErr = fmt.Errorf("failed to read GIF application extension: %w", err)
+					break blocks
+				}
+				isLoop := string(appData) == "NETSCAPE2.0" || string(appData) == "ANIMEXTS1.0"
+				if isLoop {
+					hasAnimation = true
+				}
+				// The looping sub-block is 0x01 followed by the loop count
+				subBlocks, err := readGIFSubBlocks(r, isLoop)
+				if err != nil {
+					parseErr = fmt.Errorf("failed to read GIF application extension: %w", err)
+					break blocks
+				}
+				if len(subBlocks) >= 3 && subBlocks[0] == 0x01 {
+					result.Additional["LoopCount"] = int(binary.LittleEndian.Uint16(subBlocks[1:3]))
+				}
+
+			case 0xFE: // Comment Extension
+				text, err := readGIFSubBlocks(r, true)
+				if err != nil {
+					parseErr = fmt.Errorf("failed to read GIF comment extension: %w", err)
+					break blocks
 				}
+				comments = append(comments, decodeTextBytes(bytes.TrimRight(text, "\x00")))
 
 			default:
 				// Skip other extensions
-				for {
-					subBlockSize := make([]byte, 1)
-					r.Read(subBlockSize)
-					if subBlockSize[0] == 0 {
-						break
-					}
-					subBlockData := make([]byte, int(subBlockSize[0]))
-					r.Read(subBlockData)
+				if _, err := readGIFSubBlocks(r, false); err != nil {
+					parseErr = fmt.Errorf("failed to read GIF extension: %w", err)
+					break blocks
 				}
 			}
 
 		case 0x2C: // Image separator (start of image)
 			frameCount++
+			frameDelays = append(frameDelays, pendingDelay)
+			duration += pendingDelay
+			pendingDelay = 0
 			// Skip image descriptor and data
 			imgDesc := make([]byte, 9)
-			r.Read(imgDesc)
+			err = readFull(r, imgDesc)
+			if err != nil {
+				parseErr = fmt.Errorf("failed to read GIF image descriptor: %w", err)
+				break blocks
+			}
 
 			// Check for local color table
 			localColorTableFlag := (imgDesc[8] & 0x80) != 0
@@ -153,31 +211,183 @@ func ExtractGIF(r io.ReadSeeker) (*Result, error) {
 				r.Seek(int64(colorTableSize), io.SeekCurrent)
 			}
 
-			// Skip image data
+			// Skip image data; when estimating unique colors it is decoded
+			// straight from the sub-blocks until the pixel budget runs out
 			lzwMinCodeSize := make([]byte, 1)
-			r.Read(lzwMinCodeSize)
-			// Skip data sub-blocks
-			for {
-				subBlockSize := make([]byte, 1)
-				r.Read(subBlockSize)
-				if subBlockSize[0] == 0 {
-					break
-				}
-				subBlockData := make([]byte, int(subBlockSize[0]))
-				r.Read(subBlockData)
+			err = readFull(r, lzwMinCodeSize)
+			if err != nil {
+				parseErr = fmt.Errorf("failed to read GIF image data: %w", err)
+				break blocks
+			}
+			if opts.EstimateUniqueColors && pixelBudget > 0 {
+				pixels := int(binary.LittleEndian.Uint16(imgDesc[4:6])) * int(binary.LittleEndian.Uint16(imgDesc[6:8]))
+				imageData := &gifSubBlockReader{r: r}
+				pixelBudget -= markGIFColors(imageData, int(lzwMinCodeSize[0]), min(pixels, pixelBudget), &usedColors)
+				err = imageData.skip()
+			} else {
+				_, err = readGIFSubBlocks(r, false)
+			}
+			if err != nil {
+				parseErr = fmt.Errorf("failed to read GIF image data: %w", err)
+				break blocks
 			}
 
 		case 0x3B: // Trailer (end of GIF)
-			return result, nil
+			trailer = true
 
 		default:
 			// Unknown block, skip
+			opts.log("unknown GIF block", "introducer", fmt.Sprintf("0x%02X", blockType[0]))
+		}
+	}
+
+	// Without the trailer the file was cut short; that is only an error
+	// in strict mode
+	if !trailer {
+		result.Additional["Truncated"] = true
+		if !opts.Strict {
+			parseErr = nil
+		} else if parseErr == nil {
+			parseErr = fmt.Errorf("%w: GIF ends before the trailer", ErrInvalidData)
+		} else if !errors.Is(parseErr, ErrInvalidData) {
+			parseErr = fmt.Errorf("%w: %w", ErrInvalidData, parseErr)
 		}
 	}
 
 	result.Additional["HasTransparency"] = hasTransparency
 	result.Additional["HasAnimation"] = hasAnimation
 	result.Additional["FrameCount"] = frameCount
+	result.Additional["FrameDelays"] = frameDelays
+	result.Additional["Duration"] = duration
+
+	// A single comment is reported as a string, several as a slice
+	switch len(comments) {
+	case 0:
+	case 1:
+		result.Additional["Comment"] = comments[0]
+	default:
+		result.Additional["Comment"] = comments
+	}
+
+	if opts.EstimateUniqueColors {
+		unique := 0
+		for _, used := range usedColors {
+			if used {
+				unique++
+			}
+		}
+		result.Additional["UniqueColors"] = unique
+	}
+
+	return result, parseErr
+}
+
+// readGIFSubBlocks consumes a sequence of data sub-blocks up to and including
+// the zero-length terminator. The data is returned only when keep is set.
+func readGIFSubBlocks(r io.ReadSeeker, keep bool) ([]byte, error) {
+	var data []byte
+	size := make([]byte, 1)
+	for {
+		if err := readFull(r, size); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("%w: unterminated sub-blocks", ErrInvalidData)
+			}
+			return nil, err
+		}
+		if size[0] == 0 {
+			return data, nil
+		}
+
+		if !keep {
+			if _, err := r.Seek(int64(size[0]), io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block := make([]byte, int(size[0]))
+		if err := readFull(r, block); err != nil {
+			return nil, err
+		}
+		data = append(data, block...)
+	}
+}
+
+// gifSubBlockReader reads the data of a run of sub-blocks as one stream,
+// ending at the zero-length terminator. A read error is kept for skip.
+type gifSubBlockReader struct {
+	r      io.ReadSeeker
+	remain int // bytes left in the current sub-block
+	done   bool
+	err    error
+}
+
+func (b *gifSubBlockReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	for b.remain == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		size := make([]byte, 1)
+		if err := readFull(b.r, size); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%w: unterminated sub-blocks", ErrInvalidData)
+			}
+			b.err = err
+			return 0, err
+		}
+		b.remain = int(size[0])
+		b.done = b.remain == 0
+	}
+	if len(p) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.r.Read(p)
+	b.remain -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
 
-	return result, nil
+// skip moves past the sub-blocks not yet read, returning any read error.
+func (b *gifSubBlockReader) skip() error {
+	if b.err != nil || b.done {
+		return b.err
+	}
+	if _, err := b.r.Seek(int64(b.remain), io.SeekCurrent); err != nil {
+		return err
+	}
+	b.remain = 0
+	_, err := readGIFSubBlocks(b.r, false)
+	return err
+}
+
+// markGIFColors decodes up to maxPixels palette indices from LZW image data,
+// marking each index seen. It returns the number of pixels decoded.
+func markGIFColors(data io.Reader, litWidth int, maxPixels int, used *[256]bool) int {
+	if litWidth < 2 || litWidth > 8 || maxPixels <= 0 {
+		return 0
+	}
+
+	lr := lzw.NewReader(data, lzw.LSB, litWidth)
+	defer lr.Close()
+
+	pixels := make([]byte, min(maxPixels, 32<<10))
+	decoded := 0
+	for decoded < maxPixels {
+		n, err := io.ReadFull(lr, pixels[:min(len(pixels), maxPixels-decoded)])
+		for _, idx := range pixels[:n] {
+			used[idx] = true
+		}
+		decoded += n
+		if err != nil {
+			break
+		}
+	}
+	return decoded
 }
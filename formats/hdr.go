@@ -0,0 +1,86 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxHDRHeaderBytes bounds how much is read while looking for the resolution line.
+const maxHDRHeaderBytes = 64 << 10
+
+// ExtractHDR extracts metadata from a Radiance RGBE (.hdr/.pic) file.
+func ExtractHDR(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := readUpTo(r, maxHDRHeaderBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HDR header: %w", err)
+	}
+	if !bytes.HasPrefix(header, []byte("#?RADIANCE")) && !bytes.HasPrefix(header, []byte("#?RGBE")) {
+		return nil, fmt.Errorf("%w: missing Radiance signature", ErrInvalidData)
+	}
+
+	result := newResult()
+	result.ColorSpace = "RGB"
+	result.ColorDepth = 32
+
+	// Header variables run until an empty line; the resolution string follows
+	lines := strings.Split(string(header), "\n")
+	i := 1
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "FORMAT="); ok {
+			result.Additional["Format"] = strings.TrimSpace(value)
+		}
+		if value, ok := strings.CutPrefix(line, "EXPOSURE="); ok {
+			if exposure, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				result.Additional["Exposure"] = exposure
+			}
+		}
+	}
+
+	if i+1 >= len(lines) {
+		return nil, fmt.Errorf("%w: missing HDR resolution line", ErrInvalidData)
+	}
+
+	// Resolution string such as "-Y 1080 +X 1920"; the first axis is the
+	// one that varies slowest, so "+X ... -Y ..." denotes a rotated scan order.
+	fields := strings.Fields(lines[i+1])
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("%w: invalid HDR resolution line", ErrInvalidData)
+	}
+
+	var width, height int
+	for j := 0; j < 4; j += 2 {
+		axis := fields[j]
+		n, err := strconv.Atoi(fields[j+1])
+		if err != nil || n <= 0 || len(axis) != 2 || (axis[0] != '+' && axis[0] != '-') {
+			return nil, fmt.Errorf("%w: invalid HDR resolution line", ErrInvalidData)
+		}
+		switch axis[1] {
+		case 'X':
+			width = n
+		case 'Y':
+			height = n
+		}
+	}
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("%w: invalid HDR resolution line", ErrInvalidData)
+	}
+
+	result.Width = width
+	result.Height = height
+	result.Additional["Orientation"] = fields[0] + " " + fields[2]
+
+	return result, nil
+}
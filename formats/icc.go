@@ -0,0 +1,158 @@
+package formats
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// iccHeaderSize is the size of the fixed ICC profile header.
+const iccHeaderSize = 128
+
+// maxICCProfileSize caps how much of a compressed profile is inflated, so a
+// small chunk cannot expand into an arbitrarily large allocation.
+const maxICCProfileSize = 16 << 20
+
+// ICCProfile summarizes an embedded ICC color profile.
+type ICCProfile struct {
+	// Name is the profile name a container stores next to the profile,
+	// such as the PNG iCCP keyword. It is empty for JPEG.
+	Name            string `json:"name,omitempty"`
+	Description     string `json:"description"`
+	ColorSpace      string `json:"colorSpace"`
+	ConnectionSpace string `json:"connectionSpace"`
+	DeviceClass     string `json:"deviceClass"`
+	RenderingIntent string `json:"renderingIntent"`
+	Version         string `json:"version"`
+	CMM             string `json:"cmm,omitempty"`
+	Size            uint32 `json:"size"`
+}
+
+// iccDeviceClasses names the profile/device class signatures.
+var iccDeviceClasses = map[string]string{
+	"scnr": "Input Device Profile",
+	"mntr": "Display Device Profile",
+	"prtr": "Output Device Profile",
+	"link": "DeviceLink Profile",
+	"spac": "ColorSpace Conversion Profile",
+	"abst": "Abstract Profile",
+	"nmcl": "NamedColor Profile",
+}
+
+// iccRenderingIntents names the rendering intent values.
+var iccRenderingIntents = []string{
+	"Perceptual",
+	"Media-Relative Colorimetric",
+	"Saturation",
+	"ICC-Absolute Colorimetric",
+}
+
+// parseICCProfile decodes the header of an ICC profile and its description
+// tag. It reports false when data is too short to be a profile.
+func parseICCProfile(data []byte) (ICCProfile, bool) {
+	if len(data) < iccHeaderSize || string(data[36:40]) != "acsp" {
+		return ICCProfile{}, false
+	}
+
+	profile := ICCProfile{
+		Size:            binary.BigEndian.Uint32(data[0:4]),
+		CMM:             iccSignature(data[4:8]),
+		Version:         strconv.Itoa(int(data[8])) + "." + strconv.Itoa(int(data[9]>>4)) + "." + strconv.Itoa(int(data[9]&0x0F)),
+		DeviceClass:     iccSignature(data[12:16]),
+		ColorSpace:      iccSignature(data[16:20]),
+		ConnectionSpace: iccSignature(data[20:24]),
+	}
+	if name, ok := iccDeviceClasses[string(data[12:16])]; ok {
+		profile.DeviceClass = name
+	}
+	if intent := binary.BigEndian.Uint32(data[64:68]); int(intent) < len(iccRenderingIntents) {
+		profile.RenderingIntent = iccRenderingIntents[intent]
+	}
+
+	if desc, ok := iccTag(data, "desc"); ok {
+		profile.Description = iccText(desc)
+	}
+
+	return profile, true
+}
+
+// iccSignature renders a four-character signature without padding.
+func iccSignature(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// iccTag returns the data of the tag with the given signature from the tag table.
+func iccTag(data []byte, sig string) ([]byte, bool) {
+	if len(data) < iccHeaderSize+4 {
+		return nil, false
+	}
+	count := int(binary.BigEndian.Uint32(data[iccHeaderSize : iccHeaderSize+4]))
+	pos := iccHeaderSize + 4
+
+	for i := 0; i < count && pos+12 <= len(data); i++ {
+		if string(data[pos:pos+4]) == sig {
+			offset := int64(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+			size := int64(binary.BigEndian.Uint32(data[pos+8 : pos+12]))
+			if offset+size > int64(len(data)) {
+				return nil, false
+			}
+			return data[offset : offset+size], true
+		}
+		pos += 12
+	}
+	return nil, false
+}
+
+// iccText decodes a textDescriptionType (ICC v2) or
+// multiLocalizedUnicodeType (ICC v4) tag, using the first localization.
+func iccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[0:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if n > len(tag)-12 {
+			n = len(tag) - 12
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+
+	case "mluc":
+		if len(tag) < 28 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:24]))
+		offset := int(binary.BigEndian.Uint32(tag[24:28]))
+		if offset < 0 || length < 0 || offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+
+	case "text":
+		return strings.TrimRight(string(tag[8:]), "\x00")
+	}
+	return ""
+}
+
+// assembleICCChunks joins ICC profile chunks numbered 1..total. It fails if
+// any chunk is missing.
+func assembleICCChunks(chunks map[int][]byte, total int) ([]byte, bool) {
+	if total == 0 || len(chunks) != total {
+		return nil, false
+	}
+	var profile []byte
+	for seq := 1; seq <= total; seq++ {
+		chunk, ok := chunks[seq]
+		if !ok {
+			return nil, false
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile, true
+}
@@ -0,0 +1,96 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// IconImage describes one image embedded in an ICO file.
+type IconImage struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	BitCount int    `json:"bitCount"`
+	Offset   uint32 `json:"offset"`
+	Size     uint32 `json:"size"`
+}
+
+// ExtractICO extracts metadata from a Windows icon file. The top-level
+// dimensions describe the largest embedded image.
+func ExtractICO(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read ICONDIR (6 bytes)
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read ICO header: %w", err)
+	}
+
+	reserved := binary.LittleEndian.Uint16(header[0:2])
+	imageType := binary.LittleEndian.Uint16(header[2:4])
+	count := int(binary.LittleEndian.Uint16(header[4:6]))
+	if reserved != 0 || imageType != 1 {
+		return nil, fmt.Errorf("%w: invalid ICO header", ErrInvalidData)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("%w: ICO contains no images", ErrInvalidData)
+	}
+
+	// Read all ICONDIRENTRY records (16 bytes each)
+	entries := make([]byte, 16*count)
+	if _, err := io.ReadFull(r, entries); err != nil {
+		return nil, fmt.Errorf("failed to read ICO directory: %w", err)
+	}
+
+	result := newResult()
+	images := make([]IconImage, count)
+	largest := 0
+
+	for i := range images {
+		entry := entries[i*16 : i*16+16]
+		img := IconImage{
+			Width:    int(entry[0]),
+			Height:   int(entry[1]),
+			BitCount: int(binary.LittleEndian.Uint16(entry[6:8])),
+			Size:     binary.LittleEndian.Uint32(entry[8:12]),
+			Offset:   binary.LittleEndian.Uint32(entry[12:16]),
+		}
+		// A stored dimension of 0 means 256 pixels
+		if img.Width == 0 {
+			img.Width = 256
+		}
+		if img.Height == 0 {
+			img.Height = 256
+		}
+		images[i] = img
+
+		best := images[largest]
+		if img.Width*img.Height > best.Width*best.Height ||
+			(img.Width*img.Height == best.Width*best.Height && img.BitCount > best.BitCount) {
+			largest = i
+		}
+	}
+
+	best := images[largest]
+	result.Width = best.Width
+	result.Height = best.Height
+	result.ColorDepth = best.BitCount
+
+	switch {
+	case best.BitCount == 32:
+		result.ColorSpace = "RGBA"
+	case best.BitCount > 0 && best.BitCount <= 8:
+		result.ColorSpace = "Indexed"
+	default:
+		result.ColorSpace = "RGB"
+	}
+
+	result.Additional["ImageCount"] = count
+	result.Additional["Images"] = images
+
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// photoshopAPP13Prefix identifies Photoshop image resources in a JPEG APP13 segment.
+const photoshopAPP13Prefix = "Photoshop 3.0\x00"
+
+// irbIPTC is the image resource ID holding IPTC-IIM data.
+const irbIPTC = 0x0404
+
+// iptcDatasets names the IIM record 2 (application) datasets that are
+// extracted, using exiftool's names.
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	25:  "Keywords",
+	80:  "By-line",
+	110: "Credit",
+	120: "Caption-Abstract",
+}
+
+// iptcRepeatable lists the datasets that may occur more than once and are
+// reported as []string.
+var iptcRepeatable = map[string]bool{
+	"Keywords": true,
+	"By-line":  true,
+}
+
+// findImageResource returns the data of the first Photoshop image resource
+// ("8BIM" block) with the given ID.
+func findImageResource(data []byte, id uint16) ([]byte, bool) {
+	pos := 0
+	for pos+6 <= len(data) {
+		if string(data[pos:pos+4]) != "8BIM" {
+			return nil, false
+		}
+		resourceID := binary.BigEndian.Uint16(data[pos+4 : pos+6])
+		pos += 6
+
+		// Pascal string name, padded to an even length including the length byte
+		if pos >= len(data) {
+			return nil, false
+		}
+		nameLen := int(data[pos]) + 1
+		pos += nameLen + nameLen%2
+
+		if pos+4 > len(data) {
+			return nil, false
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if size < 0 || size > len(data)-pos {
+			return nil, false
+		}
+
+		if resourceID == id {
+			return data[pos : pos+size], true
+		}
+		pos += size + size%2
+	}
+	return nil, false
+}
+
+// parseIPTC decodes the known record 2 datasets of an IPTC-IIM block.
+func parseIPTC(data []byte) map[string]interface{} {
+	iptc := make(map[string]interface{})
+
+	pos := 0
+	for pos+5 <= len(data) && data[pos] == 0x1C {
+		record, dataset := data[pos+1], data[pos+2]
+		size := int(binary.BigEndian.Uint16(data[pos+3 : pos+5]))
+		pos += 5
+		if size&0x8000 != 0 {
+			// Extended dataset lengths are only used for binary payloads
+			return iptc
+		}
+		if pos+size > len(data) {
+			break
+		}
+		value := data[pos : pos+size]
+		pos += size
+
+		name, ok := iptcDatasets[dataset]
+		if record != 2 || !ok {
+			continue
+		}
+		text := decodeTextBytes(bytes.TrimRight(value, "\x00"))
+		if iptcRepeatable[name] {
+			list, _ := iptc[name].([]string)
+			iptc[name] = append(list, text)
+		} else {
+			iptc[name] = text
+		}
+	}
+
+	return iptc
+}
@@ -0,0 +1,117 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxBoxPayload bounds how much of a single ISO-BMFF box is read into memory.
+const maxBoxPayload = 16 << 20
+
+// isoBox is a single ISO base media file format box.
+type isoBox struct {
+	typ  string
+	data []byte // payload, excluding the box header
+}
+
+// parseBoxes splits data into its immediate child boxes. Malformed trailing
+// data is ignored.
+func parseBoxes(data []byte) []isoBox {
+	var boxes []isoBox
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		hdrLen := uint64(8)
+
+		switch size {
+		case 0: // Box extends to the end of the enclosing data
+			size = uint64(len(data))
+		case 1: // 64-bit largesize follows the type
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdrLen = 16
+		}
+
+		if size < hdrLen || size > uint64(len(data)) {
+			return boxes
+		}
+
+		boxes = append(boxes, isoBox{typ: typ, data: data[hdrLen:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// findBox returns the first box of the given type.
+func findBox(boxes []isoBox, typ string) (isoBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return isoBox{}, false
+}
+
+// readBoxHeader reads a box header from r and returns the box type and the
+// payload size. A payload size of -1 means the box extends to the end of the file.
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", 0, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	typ := string(hdr[4:8])
+
+	switch size {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		large := make([]byte, 8)
+		if _, err := io.ReadFull(r, large); err != nil {
+			return "", 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(large))
+		if size < 16 {
+			return "", 0, fmt.Errorf("%w: invalid box size", ErrInvalidData)
+		}
+		return typ, size - 16, nil
+	}
+
+	if size < 8 {
+		return "", 0, fmt.Errorf("%w: invalid box size", ErrInvalidData)
+	}
+	return typ, size - 8, nil
+}
+
+// readBoxPayload reads a box payload of the given size into memory, after
+// checking the size against the input and opts.MaxChunkSize.
+func readBoxPayload(r io.ReadSeeker, size int64, opts Options) ([]byte, error) {
+	if size < 0 || size > maxBoxPayload {
+		return nil, fmt.Errorf("%w: box payload too large", ErrInvalidData)
+	}
+	if err := opts.checkLength(r, size, "box payload"); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// ftypBrands returns the major brand and compatible brands of an ftyp payload.
+func ftypBrands(data []byte) (string, []string) {
+	if len(data) < 8 {
+		return "", nil
+	}
+	major := string(data[0:4])
+	var compatible []string
+	for i := 8; i+4 <= len(data); i += 4 {
+		compatible = append(compatible, string(data[i:i+4]))
+	}
+	return major, compatible
+}
@@ -0,0 +1,169 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// jp2SignatureBox is the 12-byte JPEG 2000 signature box that starts a JP2 file.
+var jp2SignatureBox = []byte{0x00, 0x00, 0x00, 0x0C, 0x6A, 0x50, 0x20, 0x20, 0x0D, 0x0A, 0x87, 0x0A}
+
+// ExtractJP2 extracts metadata from a JPEG 2000 file, either a JP2 container
+// or a raw J2K codestream.
+func ExtractJP2(r io.ReadSeeker) (*Result, error) {
+	return extractJP2(r, Options{})
+}
+
+func extractJP2(r io.ReadSeeker, opts Options) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	if opts, err = opts.withInputSize(r); err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 12)
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, fmt.Errorf("failed to read JPEG 2000 signature: %w", err)
+	}
+
+	if sig[0] == 0xFF && sig[1] == 0x4F && sig[2] == 0xFF && sig[3] == 0x51 {
+		return parseJ2KCodestream(r)
+	}
+	if string(sig) != string(jp2SignatureBox) {
+		return nil, fmt.Errorf("%w: invalid JPEG 2000 signature", ErrInvalidData)
+	}
+
+	// Walk top-level boxes to the jp2h superbox
+	for {
+		boxType, size, err := readBoxHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing jp2h box", ErrInvalidData)
+		}
+
+		if boxType == "jp2h" {
+			payload, err := readBoxPayload(r, size, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read jp2h box: %w", err)
+			}
+			return parseJP2Header(payload)
+		}
+
+		if size < 0 {
+			return nil, fmt.Errorf("%w: missing jp2h box", ErrInvalidData)
+		}
+		r.Seek(size, io.SeekCurrent)
+	}
+}
+
+// parseJP2Header reads the ihdr and colr boxes of a jp2h superbox.
+func parseJP2Header(data []byte) (*Result, error) {
+	children := parseBoxes(data)
+
+	ihdr, ok := findBox(children, "ihdr")
+	if !ok || len(ihdr.data) < 14 {
+		return nil, fmt.Errorf("%w: missing ihdr box", ErrInvalidData)
+	}
+
+	result := newResult()
+	result.Height = int(binary.BigEndian.Uint32(ihdr.data[0:4]))
+	result.Width = int(binary.BigEndian.Uint32(ihdr.data[4:8]))
+	components := int(binary.BigEndian.Uint16(ihdr.data[8:10]))
+
+	// BPC stores bit depth minus one; 0xFF means components differ (see bpcc)
+	bpc := ihdr.data[10]
+	if bpc != 0xFF {
+		bits := int(bpc&0x7F) + 1
+		result.ColorDepth = bits * components
+		result.Additional["BitsPerComponent"] = bits
+		result.Additional["Signed"] = bpc&0x80 != 0
+	} else if bpcc, ok := findBox(children, "bpcc"); ok {
+		for _, b := range bpcc.data {
+			result.ColorDepth += int(b&0x7F) + 1
+		}
+	}
+	result.Additional["Components"] = components
+	result.ColorSpace = componentColorSpace(components)
+
+	if colr, ok := findBox(children, "colr"); ok && len(colr.data) >= 3 {
+		switch colr.data[0] {
+		case 1: // Enumerated color space
+			if len(colr.data) >= 7 {
+				enumCS := binary.BigEndian.Uint32(colr.data[3:7])
+				result.Additional["EnumeratedColorSpace"] = int(enumCS)
+				switch enumCS {
+				case 12:
+					result.ColorSpace = "CMYK"
+				case 17:
+					result.ColorSpace = "Grayscale"
+				}
+			}
+		case 2, 3: // Restricted or any ICC profile
+			result.HasICCProfile = true
+		}
+	}
+
+	return result, nil
+}
+
+// parseJ2KCodestream reads the SIZ marker segment of a raw codestream. The
+// reader is positioned 12 bytes into the file.
+func parseJ2KCodestream(r io.ReadSeeker) (*Result, error) {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Lsiz, Rsiz, image and tile geometry, Csiz
+	siz := make([]byte, 38)
+	if _, err := io.ReadFull(r, siz); err != nil {
+		return nil, fmt.Errorf("failed to read J2K SIZ segment: %w", err)
+	}
+
+	xsiz := binary.BigEndian.Uint32(siz[4:8])
+	ysiz := binary.BigEndian.Uint32(siz[8:12])
+	xosiz := binary.BigEndian.Uint32(siz[12:16])
+	yosiz := binary.BigEndian.Uint32(siz[16:20])
+	components := int(binary.BigEndian.Uint16(siz[36:38]))
+	if xsiz < xosiz || ysiz < yosiz {
+		return nil, fmt.Errorf("%w: invalid J2K image offsets", ErrInvalidData)
+	}
+
+	result := newResult()
+	result.Width = int(xsiz - xosiz)
+	result.Height = int(ysiz - yosiz)
+	result.ColorSpace = componentColorSpace(components)
+	result.Additional["Components"] = components
+	result.Additional["Codestream"] = true
+
+	// Ssiz, XRsiz, YRsiz for each component
+	if components > 0 && components <= 16384 {
+		comps := make([]byte, 3*components)
+		if _, err := io.ReadFull(r, comps); err == nil {
+			for i := 0; i < components; i++ {
+				result.ColorDepth += int(comps[i*3]&0x7F) + 1
+			}
+			result.Additional["BitsPerComponent"] = int(comps[0]&0x7F) + 1
+		}
+	}
+
+	return result, nil
+}
+
+// componentColorSpace guesses the color space from a component count.
+func componentColorSpace(components int) string {
+	switch components {
+	case 1:
+		return "Grayscale"
+	case 2:
+		return "GrayscaleAlpha"
+	case 3:
+		return "RGB"
+	case 4:
+		return "RGBA"
+	default:
+		return "Unknown"
+	}
+}
@@ -1,21 +1,31 @@
 package formats
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 )
 
+// jfifDensityUnits names the JFIF APP0 density units.
+var jfifDensityUnits = []string{"aspect", "dpi", "dpcm"}
+
 // ExtractJPEG extracts metadata from a JPEG file.
 func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
+	return extractJPEG(r, Options{})
+}
+
+func extractJPEG(r io.ReadSeeker, opts Options) (*Result, error) {
 	// Reset to beginning
 	_, err := r.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
+	// The walk is made of many small reads and skips
+	r = newBufferedReadSeeker(r, 0)
 
 	buf := make([]byte, 2)
-	_, err = r.Read(buf)
+	err = readFull(r, buf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read JPEG header: %w", err)
 	}
@@ -27,11 +37,19 @@ func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
 
 	result := newResult()
 	hasICC := false
+	extendedXMP := make(xmpExtensions)
+	iccChunks := make(map[int][]byte)
+	iccTotal := 0
+	var comments []string
+	sawEOI := false
 
-	// Read through JPEG segments
+	// Read through JPEG segments; an error stops the walk but keeps what
+	// was read before it
+	var parseErr error
+segments:
 	for {
 		marker := make([]byte, 2)
-		_, err = r.Read(marker)
+		err = readFull(r, marker)
 		if err != nil {
 			break
 		}
@@ -45,15 +63,17 @@ func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
 
 		// Skip padding bytes (0xFF)
 		for markerType == 0xFF {
-			_, err = r.Read(marker)
+			err = readFull(r, marker)
 			if err != nil {
-				return nil, err
+				parseErr = err
+				break segments
 			}
 			markerType = marker[1]
 		}
 
 		// End of image
 		if markerType == 0xD9 {
+			sawEOI = true
 			break
 		}
 
@@ -64,61 +84,194 @@ func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
 
 		// Read segment length
 		lengthBytes := make([]byte, 2)
-		_, err = r.Read(lengthBytes)
+		err = readFull(r, lengthBytes)
 		if err != nil {
+			if opts.Strict {
+				parseErr = fmt.Errorf("%w: truncated JPEG segment header", ErrInvalidData)
+			}
 			break
 		}
 		length := int(binary.BigEndian.Uint16(lengthBytes)) - 2
+		if length < 0 {
+			parseErr = fmt.Errorf("%w: invalid JPEG segment length", ErrInvalidData)
+			break segments
+		}
+
+		// APPn and COM segments are read into memory whole
+		if markerType >= 0xE0 && markerType <= 0xEF || markerType == 0xFE {
+			if err := opts.checkLength(r, int64(length), "JPEG segment"); err != nil {
+				parseErr = err
+				break segments
+			}
+		}
 
 		// Handle different segment types
 		switch markerType {
+		case 0xDA: // SOS; metadata never follows the entropy-coded data
+			if _, err := r.Seek(int64(length), io.SeekCurrent); err == nil {
+				sawEOI = jpegHasEOI(r)
+			}
+			break segments
+
 		case 0xE0: // APP0 (JFIF)
-			// Skip JFIF data
-			r.Seek(int64(length), io.SeekCurrent)
+			segmentData := make([]byte, length)
+			err = readFull(r, segmentData)
+			if err != nil {
+				continue
+			}
+			// The JFXX extension segment carries only a thumbnail and is ignored
+			parseJFIF(segmentData, result)
 
 		case 0xE1: // APP1 (EXIF)
-			segmentData := make([]byte, length)
-			_, err = r.Read(segmentData)
+			// Without EXIF decoding, an EXIF segment is skipped after its
+			// identifier rather than read into memory
+			var segmentData []byte
+			if opts.SkipEXIF && length >= 6 {
+				id := make([]byte, 6)
+				if readFull(r, id) != nil {
+					continue
+				}
+				if string(id) == "Exif\x00\x00" {
+					r.Seek(int64(length-6), io.SeekCurrent)
+					continue
+				}
+				segmentData = append(id, make([]byte, length-6)...)
+				err = readFull(r, segmentData[6:])
+			} else {
+				segmentData = make([]byte, length)
+				err = readFull(r, segmentData)
+			}
 			if err != nil {
 				continue
 			}
 			// Check for EXIF identifier
 			if len(segmentData) >= 6 && string(segmentData[0:6]) == "Exif\x00\x00" {
+				if opts.Strict {
+					if err := validateTIFF(segmentData[6:]); err != nil {
+						parseErr = err
+						break segments
+					}
+				}
+				if opts.DeferEXIF {
+					if result.RawEXIF == nil {
+						result.RawEXIF = segmentData[6:]
+					}
+					continue
+				}
 				// Parse EXIF from segment data
-				exifData, err := parseTIFF(segmentData[6:])
+				exifData, err := parseTIFF(segmentData[6:], opts)
 				if err == nil {
 					for k, v := range exifData {
 						result.EXIF[k] = v
 					}
+					if result.RawEXIF == nil {
+						result.RawEXIF = segmentData[6:]
+					}
+				} else {
+					opts.log("skipped JPEG segment", "marker", "APP1", "type", "EXIF", "error", err)
+				}
+			} else if bytes.HasPrefix(segmentData, []byte(xmpAPP1Prefix)) {
+				// XMP packet; properties from every packet are merged
+				props, err := parseXMP(segmentData[len(xmpAPP1Prefix):])
+				if err == nil {
+					xmp, _ := result.Additional["XMP"].(map[string]interface{})
+					if xmp == nil {
+						xmp = make(map[string]interface{})
+						result.Additional["XMP"] = xmp
+					}
+					for k, v := range props {
+						xmp[k] = v
+					}
+				} else {
+					opts.log("skipped JPEG segment", "marker", "APP1", "type", "XMP", "error", err)
+				}
+			} else if bytes.HasPrefix(segmentData, []byte(xmpExtensionPrefix)) {
+				extendedXMP.add(segmentData[len(xmpExtensionPrefix):])
+			}
+
+		case 0xED: // APP13 (Photoshop image resources)
+			segmentData := make([]byte, length)
+			err = readFull(r, segmentData)
+			if err != nil {
+				continue
+			}
+			if bytes.HasPrefix(segmentData, []byte(photoshopAPP13Prefix)) {
+				resources := segmentData[len(photoshopAPP13Prefix):]
+				if iim, ok := findImageResource(resources, irbIPTC); ok {
+					if iptc := parseIPTC(iim); len(iptc) > 0 {
+						result.Additional["IPTC"] = iptc
+					} else {
+						opts.log("skipped JPEG segment", "marker", "APP13", "type", "IPTC", "error", "no datasets decoded")
+					}
 				}
 			}
 
-		case 0xE2: // APP2 (ICC Profile)
+		case 0xE2: // APP2 (ICC Profile, Multi-Picture Format)
+			segmentStart, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				parseErr = err
+				break segments
+			}
 			segmentData := make([]byte, length)
-			_, err = r.Read(segmentData)
+			err = readFull(r, segmentData)
 			if err != nil {
 				continue
 			}
-			// Check for ICC profile identifier
+			if bytes.HasPrefix(segmentData, []byte(mpfAPP2Prefix)) {
+				if images, ok := parseMPF(segmentData[len(mpfAPP2Prefix):], segmentStart+int64(len(mpfAPP2Prefix))); ok {
+					result.Additional["MPF"] = images
+				}
+			}
+			// Check for ICC profile identifier; large profiles are split
+			// across segments numbered 1..total after the 12-byte identifier
 			if len(segmentData) >= 11 && string(segmentData[0:11]) == "ICC_PROFILE" {
 				hasICC = true
+				if len(segmentData) >= 14 {
+					seq, total := int(segmentData[12]), int(segmentData[13])
+					iccTotal = total
+					iccChunks[seq] = segmentData[14:]
+				}
+			}
+
+		case 0xEE: // APP14 (Adobe)
+			segmentData := make([]byte, length)
+			err = readFull(r, segmentData)
+			if err != nil {
+				continue
+			}
+			// Version, flags0 and flags1 precede the color transform byte
+			if len(segmentData) >= 12 && string(segmentData[0:5]) == "Adobe" {
+				result.Additional["AdobeTransform"] = int(segmentData[11])
 			}
 
+		case 0xFE: // COM (free-text comment)
+			segmentData := make([]byte, length)
+			err = readFull(r, segmentData)
+			if err != nil {
+				continue
+			}
+			comments = append(comments, decodeTextBytes(bytes.TrimRight(segmentData, "\x00")))
+
 		case 0xC0, 0xC1, 0xC2, 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
 			// SOF (Start of Frame) segments - contain image dimensions
+			// Precision, dimensions and component count come first
 			readLen := length
-			if readLen > 9 {
-				readLen = 9
+			if readLen > 6 {
+				readLen = 6
 			}
 			sofData := make([]byte, readLen)
-			_, err = r.Read(sofData)
+			err = readFull(r, sofData)
 			if err != nil {
 				continue
 			}
+			encoding := jpegEncoding(markerType)
+			result.Additional["Encoding"] = encoding
+			result.Additional["Progressive"] = encoding == "Progressive"
+
 			if len(sofData) >= 5 {
-				// Precision (bits per sample)
+				// Precision (bits per sample); 12 for extended-sequential frames
 				precision := int(sofData[0])
-				result.ColorDepth = precision * 3 // Assuming RGB
+				result.ColorDepth = precision * 3 // Assuming RGB until the component count is read
 				result.Additional["BitsPerSample"] = precision
 
 				// Height and Width (big-endian)
@@ -131,6 +284,7 @@ func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
 				if len(sofData) >= 6 {
 					numComponents := int(sofData[5])
 					result.Additional["Components"] = numComponents
+					result.ColorDepth = precision * numComponents
 					switch numComponents {
 					case 1:
 						result.ColorSpace = "Grayscale"
@@ -141,25 +295,177 @@ func ExtractJPEG(r io.ReadSeeker) (*Result, error) {
 					default:
 						result.ColorSpace = "Unknown"
 					}
+
+					// Component descriptors follow: id, H/V sampling factors, quantization table
+					if numComponents == 3 && length >= readLen+9 {
+						components := make([]byte, 9)
+						readLen += len(components)
+						if readFull(r, components) == nil {
+							if ratio, ok := chromaSubsampling(components); ok {
+								result.Additional["ChromaSubsampling"] = ratio
+							}
+						}
+					}
 				}
 			}
 			// Skip remaining segment data
-			if length > 9 {
-				r.Seek(int64(length-9), io.SeekCurrent)
+			if length > readLen {
+				r.Seek(int64(length-readLen), io.SeekCurrent)
 			}
 
 		default:
-			// Skip unknown segments
+			// Skip unknown segments; only application segments are worth
+			// reporting, the rest describe the entropy-coded data
+			if markerType >= 0xE0 && markerType <= 0xEF {
+				opts.log("unknown JPEG segment", "marker", fmt.Sprintf("APP%d", markerType-0xE0))
+			}
 			r.Seek(int64(length), io.SeekCurrent)
 		}
 	}
 
+	if !sawEOI {
+		result.Additional["Truncated"] = true
+		if opts.Strict && parseErr == nil {
+			parseErr = fmt.Errorf("%w: JPEG ends before EOI", ErrInvalidData)
+		}
+	}
+
 	result.HasICCProfile = hasICC
 
+	// Adobe applications write four-component data inverted, and transform 2
+	// marks it as YCCK rather than CMYK
+	if transform, ok := result.Additional["AdobeTransform"].(int); ok {
+		components, _ := result.Additional["Components"].(int)
+		result.Additional["InvertedCMYK"] = components == 4
+		if components == 4 && transform == 2 {
+			result.ColorSpace = "YCCK"
+		}
+	}
+
+	// A single comment is reported as a string, several as a slice
+	switch len(comments) {
+	case 0:
+	case 1:
+		result.Additional["Comment"] = comments[0]
+	default:
+		result.Additional["Comment"] = comments
+	}
+	if profile, ok := assembleICCChunks(iccChunks, iccTotal); ok {
+		if icc, ok := parseICCProfile(profile); ok {
+			result.Additional["ICCProfile"] = icc
+		} else {
+			opts.log("skipped JPEG segment", "marker", "APP2", "type", "ICC", "error", "invalid profile")
+		}
+	} else if hasICC {
+		opts.log("skipped JPEG segment", "marker", "APP2", "type", "ICC", "error", "incomplete profile")
+	}
+
+	// Extended XMP is only trusted when the main packet references its GUID
+	if xmp, ok := result.Additional["XMP"].(map[string]interface{}); ok {
+		if guid, ok := xmp["xmpNote:HasExtendedXMP"].(string); ok {
+			if merged, ok := extendedXMP.assemble(guid); ok {
+				result.Additional["XMPExtended"] = string(merged)
+			}
+		}
+	}
+
 	// Set default color space if not set
 	if result.ColorSpace == "" {
 		result.ColorSpace = "RGB"
 	}
 
-	return result, nil
+	return result, parseErr
+}
+
+// jpegEncoding names the coding process of a SOF marker. The low two bits
+// select sequential, progressive or lossless for every SOF variant.
+func jpegEncoding(marker byte) string {
+	switch marker & 0x03 {
+	case 0x02:
+		return "Progressive"
+	case 0x03:
+		return "Lossless"
+	default:
+		return "Baseline"
+	}
+}
+
+// jpegEOIWindow is how far from the end of the input jpegHasEOI looks for
+// the EOI marker, leaving room for trailers some cameras append.
+const jpegEOIWindow = 64 << 10
+
+// jpegHasEOI reports whether an EOI marker lies between the current
+// position, the start of the scan data, and the end of r. Byte stuffing
+// keeps 0xFF 0xD9 out of entropy-coded data, so any occurrence is an EOI.
+func jpegHasEOI(r io.ReadSeeker) bool {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false
+	}
+	n := end - pos
+	if n > jpegEOIWindow {
+		n = jpegEOIWindow
+	}
+	if n < 2 {
+		return false
+	}
+	if _, err := r.Seek(end-n, io.SeekStart); err != nil {
+		return false
+	}
+	tail := make([]byte, n)
+	if err := readFull(r, tail); err != nil {
+		return false
+	}
+	return bytes.LastIndex(tail, []byte{0xFF, 0xD9}) >= 0
+}
+
+// jpegSubsampling names the J:a:b notation for luma-to-chroma sampling ratios.
+var jpegSubsampling = map[[2]int]string{
+	{1, 1}: "4:4:4",
+	{2, 1}: "4:2:2",
+	{2, 2}: "4:2:0",
+	{1, 2}: "4:4:0",
+	{4, 1}: "4:1:1",
+	{4, 2}: "4:1:0",
+}
+
+// chromaSubsampling derives the subsampling of a YCbCr frame from its three
+// component descriptors. Both chroma components must share sampling factors.
+func chromaSubsampling(components []byte) (string, bool) {
+	lumaH, lumaV := int(components[1]>>4), int(components[1]&0x0F)
+	if components[4] != components[7] {
+		return "", false
+	}
+	chromaH, chromaV := int(components[4]>>4), int(components[4]&0x0F)
+	if chromaH == 0 || chromaV == 0 || lumaH%chromaH != 0 || lumaV%chromaV != 0 {
+		return "", false
+	}
+	ratio, ok := jpegSubsampling[[2]int{lumaH / chromaH, lumaV / chromaV}]
+	return ratio, ok
+}
+
+// parseJFIF records the pixel density from a JFIF APP0 payload.
+func parseJFIF(data []byte, res *Result) {
+	if len(data) < 12 || string(data[0:5]) != "JFIF\x00" {
+		return
+	}
+
+	units := int(data[7])
+	xDensity := int(binary.BigEndian.Uint16(data[8:10]))
+	yDensity := int(binary.BigEndian.Uint16(data[10:12]))
+	if units >= len(jfifDensityUnits) || xDensity == 0 || yDensity == 0 {
+		return
+	}
+
+	res.Additional["XDensity"] = xDensity
+	res.Additional["YDensity"] = yDensity
+	res.Additional["DensityUnit"] = jfifDensityUnits[units]
+	if units == 1 {
+		res.Additional["DPIX"] = xDensity
+		res.Additional["DPIY"] = yDensity
+	}
 }
@@ -0,0 +1,320 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+)
+
+// jxlHeaderBytes is how much of the codestream is read to decode the headers.
+const jxlHeaderBytes = 4096
+
+// jxlContainerSig is the JPEG XL signature box that starts the ISO-BMFF container.
+var jxlContainerSig = []byte{0x00, 0x00, 0x00, 0x0C, 0x4A, 0x58, 0x4C, 0x20, 0x0D, 0x0A, 0x87, 0x0A}
+
+// ExtractJXL extracts metadata from a JPEG XL file, either a bare codestream
+// or an ISO-BMFF container.
+func ExtractJXL(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 12)
+	n, err := io.ReadFull(r, sig)
+	if err != nil && n < 2 {
+		return nil, fmt.Errorf("failed to read JXL signature: %w", err)
+	}
+
+	result := newResult()
+	var codestream []byte
+
+	switch {
+	case sig[0] == 0xFF && sig[1] == 0x0A:
+		result.Additional["Container"] = false
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		codestream, err = readUpTo(r, jxlHeaderBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JXL codestream: %w", err)
+		}
+
+	case n == 12 && string(sig) == string(jxlContainerSig):
+		result.Additional["Container"] = true
+		codestream, err = readJXLContainer(r)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: invalid JXL signature", ErrInvalidData)
+	}
+
+	if len(codestream) < 2 || codestream[0] != 0xFF || codestream[1] != 0x0A {
+		return nil, fmt.Errorf("%w: invalid JXL codestream", ErrInvalidData)
+	}
+
+	if err := parseJXLHeaders(codestream[2:], result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readJXLContainer walks the container boxes and returns the start of the
+// codestream from the jxlc box or the concatenated jxlp boxes.
+func readJXLContainer(r io.ReadSeeker) ([]byte, error) {
+	var codestream []byte
+
+	for len(codestream) < jxlHeaderBytes {
+		boxType, size, err := readBoxHeader(r)
+		if err != nil {
+			break
+		}
+
+		switch boxType {
+		case "jxlc", "jxlp":
+			limit := int64(jxlHeaderBytes - len(codestream))
+			if boxType == "jxlp" {
+				// Partial codestream boxes start with a 4-byte sequence index
+				if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+					return nil, err
+				}
+				if size >= 0 {
+					size -= 4
+				}
+			}
+			if size >= 0 && size < limit {
+				limit = size
+			}
+			part, err := readUpTo(r, int(limit))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read JXL %s box: %w", boxType, err)
+			}
+			codestream = append(codestream, part...)
+			if boxType == "jxlc" {
+				return codestream, nil
+			}
+			if size > int64(len(part)) {
+				r.Seek(size-int64(len(part)), io.SeekCurrent)
+			}
+
+		default:
+			if size < 0 {
+				return codestream, nil
+			}
+			r.Seek(size, io.SeekCurrent)
+		}
+	}
+
+	if len(codestream) == 0 {
+		return nil, fmt.Errorf("%w: missing JXL codestream box", ErrInvalidData)
+	}
+	return codestream, nil
+}
+
+// jxlBitReader reads the LSB-first bit-packed fields of a JXL codestream.
+type jxlBitReader struct {
+	data []byte
+	pos  int // bit position
+	err  error
+}
+
+func (b *jxlBitReader) bits(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if b.pos>>3 >= len(b.data) {
+			b.err = fmt.Errorf("%w: truncated JXL header", ErrInvalidData)
+			return 0
+		}
+		bit := (b.data[b.pos>>3] >> (uint(b.pos) & 7)) & 1
+		v |= uint64(bit) << uint(i)
+		b.pos++
+	}
+	return v
+}
+
+func (b *jxlBitReader) bool() bool {
+	return b.bits(1) == 1
+}
+
+// jxlDist is one U32 distribution: a constant plus an optional number of extra bits.
+type jxlDist struct {
+	offset uint64
+	bits   int
+}
+
+// u32 decodes a U32 field from its four distributions.
+func (b *jxlBitReader) u32(d0, d1, d2, d3 jxlDist) uint64 {
+	d := [4]jxlDist{d0, d1, d2, d3}[b.bits(2)]
+	return d.offset + b.bits(d.bits)
+}
+
+func (b *jxlBitReader) enum() uint64 {
+	return b.u32(jxlDist{0, 0}, jxlDist{1, 0}, jxlDist{2, 4}, jxlDist{18, 6})
+}
+
+// size decodes a SizeHeader and returns the width and height.
+func (b *jxlBitReader) size() (uint64, uint64) {
+	var width, height uint64
+	div8 := b.bool()
+	if div8 {
+		height = (b.bits(5) + 1) * 8
+	} else {
+		height = b.u32(jxlDist{1, 9}, jxlDist{1, 13}, jxlDist{1, 18}, jxlDist{1, 30})
+	}
+
+	ratio := b.bits(3)
+	if ratio == 0 {
+		if div8 {
+			width = (b.bits(5) + 1) * 8
+		} else {
+			width = b.u32(jxlDist{1, 9}, jxlDist{1, 13}, jxlDist{1, 18}, jxlDist{1, 30})
+		}
+	} else {
+		width = jxlAspectWidth(height, ratio)
+	}
+	return width, height
+}
+
+// jxlAspectWidth derives the width from the height for a fixed aspect ratio.
+func jxlAspectWidth(height, ratio uint64) uint64 {
+	ratios := [8][2]uint64{{1, 1}, {1, 1}, {12, 10}, {4, 3}, {3, 2}, {16, 9}, {5, 4}, {2, 1}}
+	return height * ratios[ratio][0] / ratios[ratio][1]
+}
+
+// bitDepth decodes a BitDepth bundle and returns bits per sample.
+func (b *jxlBitReader) bitDepth() (int, bool) {
+	float := b.bool()
+	if float {
+		bps := b.u32(jxlDist{32, 0}, jxlDist{16, 0}, jxlDist{24, 0}, jxlDist{1, 6})
+		b.bits(4) // exponent bits
+		return int(bps), true
+	}
+	bps := b.u32(jxlDist{8, 0}, jxlDist{10, 0}, jxlDist{12, 0}, jxlDist{1, 6})
+	return int(bps), false
+}
+
+// parseJXLHeaders decodes the SizeHeader and ImageMetadata that follow the
+// codestream signature.
+func parseJXLHeaders(data []byte, res *Result) error {
+	br := &jxlBitReader{data: data}
+
+	width, height := br.size()
+	if br.err != nil {
+		return br.err
+	}
+	res.Width = int(width)
+	res.Height = int(height)
+
+	bitsPerSample := 8
+	isFloat := false
+	alphaBits := 0
+	colorSpace := uint64(0) // kRGB
+
+	allDefault := br.bool()
+	if !allDefault {
+		extraFields := br.bool()
+		if extraFields {
+			res.Additional["Orientation"] = int(br.bits(3)) + 1
+			if br.bool() { // have_intrinsic_size
+				br.size()
+			}
+			if br.bool() { // have_preview
+				jxlSkipPreview(br)
+			}
+			if br.bool() { // have_animation
+				res.Additional["Animation"] = true
+				br.u32(jxlDist{100, 0}, jxlDist{1000, 0}, jxlDist{1, 10}, jxlDist{1, 30}) // tps_numerator
+				br.u32(jxlDist{1, 0}, jxlDist{1001, 0}, jxlDist{1, 8}, jxlDist{1, 10})    // tps_denominator
+				br.u32(jxlDist{0, 0}, jxlDist{0, 3}, jxlDist{0, 16}, jxlDist{0, 32})      // num_loops
+				br.bool()                                                                 // have_timecodes
+			}
+		}
+
+		bitsPerSample, isFloat = br.bitDepth()
+		br.bool() // modular_16bit_buffer_sufficient
+
+		numExtra := br.u32(jxlDist{0, 0}, jxlDist{1, 0}, jxlDist{2, 4}, jxlDist{1, 12})
+		for i := uint64(0); i < numExtra && br.err == nil; i++ {
+			if bits, ok := jxlReadExtraChannel(br); ok && alphaBits == 0 {
+				alphaBits = bits
+			}
+		}
+
+		res.Additional["XYBEncoded"] = br.bool()
+
+		// ColorEncoding
+		if !br.bool() { // all_default
+			br.bool() // want_icc
+			colorSpace = br.enum()
+		}
+	}
+
+	if br.err != nil {
+		return br.err
+	}
+
+	res.Additional["BitsPerSample"] = bitsPerSample
+	res.Additional["FloatSamples"] = isFloat
+
+	channels := 3
+	res.ColorSpace = "RGB"
+	if colorSpace == 1 { // kGrey
+		channels = 1
+		res.ColorSpace = "Grayscale"
+	}
+	res.ColorDepth = bitsPerSample*channels + alphaBits
+	if alphaBits > 0 {
+		if channels == 1 {
+			res.ColorSpace = "GrayscaleAlpha"
+		} else {
+			res.ColorSpace = "RGBA"
+		}
+	}
+
+	return nil
+}
+
+// jxlSkipPreview consumes a PreviewHeader.
+func jxlSkipPreview(br *jxlBitReader) {
+	div8 := br.bool()
+	if div8 {
+		br.u32(jxlDist{16, 0}, jxlDist{32, 0}, jxlDist{1, 5}, jxlDist{33, 9})
+	} else {
+		br.u32(jxlDist{1, 6}, jxlDist{65, 8}, jxlDist{321, 10}, jxlDist{1345, 12})
+	}
+	if br.bits(3) == 0 {
+		if div8 {
+			br.u32(jxlDist{16, 0}, jxlDist{32, 0}, jxlDist{1, 5}, jxlDist{33, 9})
+		} else {
+			br.u32(jxlDist{1, 6}, jxlDist{65, 8}, jxlDist{321, 10}, jxlDist{1345, 12})
+		}
+	}
+}
+
+// jxlReadExtraChannel consumes an ExtraChannelInfo bundle and returns the
+// bit depth when the channel is alpha.
+func jxlReadExtraChannel(br *jxlBitReader) (int, bool) {
+	if br.bool() { // d_alpha: default 8-bit alpha channel
+		return 8, true
+	}
+
+	channelType := br.enum()
+	bits, _ := br.bitDepth()
+	br.u32(jxlDist{0, 0}, jxlDist{3, 0}, jxlDist{4, 0}, jxlDist{1, 3}) // dim_shift
+	nameLen := br.u32(jxlDist{0, 0}, jxlDist{0, 4}, jxlDist{16, 5}, jxlDist{48, 10})
+	br.pos += int(nameLen) * 8
+
+	switch channelType {
+	case 0: // kAlpha
+		br.bool() // alpha_associated
+		return bits, true
+	case 2: // kSpotColor
+		br.pos += 4 * 16
+	case 5: // kCFA
+		br.u32(jxlDist{1, 0}, jxlDist{0, 2}, jxlDist{3, 4}, jxlDist{19, 8})
+	}
+	return 0, false
+}
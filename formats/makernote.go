@@ -0,0 +1,188 @@
+package formats
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// exifTagMakerNote is the Exif IFD tag holding the vendor-specific MakerNote.
+const exifTagMakerNote = 0x927C
+
+// canonTagNames maps the Canon MakerNote tags that are decoded.
+var canonTagNames = map[uint16]string{
+	0x0001: "CameraSettings",
+	0x0006: "ImageType",
+	0x0007: "FirmwareVersion",
+	0x0008: "FileNumber",
+	0x0009: "OwnerName",
+	0x000C: "SerialNumber",
+	0x0010: "ModelID",
+	0x0095: "LensModel",
+}
+
+// nikonTagNames maps the Nikon MakerNote tags that are decoded. LensData
+// (0x0098) is encrypted on most bodies and deliberately absent.
+var nikonTagNames = map[uint16]string{
+	0x0001: "MakerNoteVersion",
+	0x0002: "ISO",
+	0x001D: "SerialNumber",
+	0x0083: "LensType",
+	0x0084: "Lens",
+	0x00A7: "ShutterCount",
+}
+
+// canonFocusModes names the FocusMode values in Canon CameraSettings.
+var canonFocusModes = map[uint16]string{
+	0:   "One-shot AF",
+	1:   "AI Servo AF",
+	2:   "AI Focus AF",
+	3:   "Manual Focus (3)",
+	4:   "Single",
+	5:   "Continuous",
+	6:   "Manual Focus (6)",
+	16:  "Pan Focus",
+	256: "One-shot AF (Live View)",
+	257: "AI Servo AF (Live View)",
+	258: "AI Focus AF (Live View)",
+	512: "Movie Snap Focus",
+	519: "Movie Servo AF",
+}
+
+// parseMakerNote decodes the MakerNote raw, found at offset (relative to the
+// TIFF header in data), for the camera makes that are understood, based on
+// the Make tag already parsed from IFD0. It returns nil for other makes.
+func parseMakerNote(w *ifdWalk, data, raw []byte, offset int, byteOrder binary.ByteOrder, exif map[string]interface{}, depth int) map[string]interface{} {
+	cameraMake, _ := exif["Make"].(string)
+
+	switch {
+	case strings.HasPrefix(strings.ToUpper(cameraMake), "CANON"):
+		return parseCanonMakerNote(w, data, offset, byteOrder, depth)
+	case strings.HasPrefix(strings.ToUpper(cameraMake), "NIKON"):
+		return parseNikonMakerNote(w, data, raw, offset, byteOrder, depth)
+	default:
+		return nil
+	}
+}
+
+// parseCanonMakerNote walks Canon's header-less MakerNote IFD, whose value
+// offsets are relative to the TIFF header like the rest of the EXIF data.
+func parseCanonMakerNote(w *ifdWalk, data []byte, offset int, byteOrder binary.ByteOrder, depth int) map[string]interface{} {
+	note := make(map[string]interface{})
+	w.parseIFD(data, offset, byteOrder, note, depth, func(tag uint16) string {
+		return canonTagNames[tag]
+	})
+
+	if settings, ok := note["CameraSettings"].([]uint16); ok {
+		delete(note, "CameraSettings")
+		decodeCanonCameraSettings(settings, note)
+	}
+
+	return note
+}
+
+// decodeCanonCameraSettings decodes the fields of the CameraSettings array
+// (Canon tag 0x0001) that are useful on their own. Index 0 holds the
+// array's size in bytes, so field N is at index N.
+func decodeCanonCameraSettings(settings []uint16, note map[string]interface{}) {
+	field := func(i int) (uint16, bool) {
+		if i >= len(settings) {
+			return 0, false
+		}
+		return settings[i], true
+	}
+
+	if v, ok := field(1); ok {
+		switch v {
+		case 1:
+			note["MacroMode"] = "Macro"
+		case 2:
+			note["MacroMode"] = "Normal"
+		}
+	}
+	if v, ok := field(7); ok {
+		if name, ok := canonFocusModes[v]; ok {
+			note["FocusMode"] = name
+		}
+	}
+	if v, ok := field(22); ok {
+		note["LensType"] = v
+	}
+
+	// Focal lengths are in FocalUnits per millimeter
+	units, ok := field(25)
+	if !ok || units == 0 {
+		units = 1
+	}
+	if v, ok := field(23); ok && v > 0 {
+		note["MaxFocalLength"] = float64(v) / float64(units)
+	}
+	if v, ok := field(24); ok && v > 0 {
+		note["MinFocalLength"] = float64(v) / float64(units)
+	}
+}
+
+// parseNikonMakerNote handles the three Nikon MakerNote layouts:
+//   - Type 1, "Nikon\0\x01\0" followed by an IFD with offsets relative to
+//     the EXIF TIFF header
+//   - Type 2, a header-less IFD with offsets relative to the EXIF TIFF header
+//   - Type 3, "Nikon\0\x02" followed by a complete TIFF header (with its own
+//     byte order) at byte 10, which all offsets are relative to
+func parseNikonMakerNote(w *ifdWalk, data, raw []byte, offset int, byteOrder binary.ByteOrder, depth int) map[string]interface{} {
+	note := make(map[string]interface{})
+	tagName := func(tag uint16) string {
+		return nikonTagNames[tag]
+	}
+
+	switch {
+	case len(raw) >= 18 && string(raw[:7]) == "Nikon\x00\x02":
+		tiff := raw[10:]
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return nil
+		}
+		w.parseIFD(tiff, int(order.Uint32(tiff[4:8])), order, note, depth, tagName)
+
+	case len(raw) >= 8 && string(raw[:8]) == "Nikon\x00\x01\x00":
+		w.parseIFD(data, offset+8, byteOrder, note, depth, tagName)
+
+	default:
+		w.parseIFD(data, offset, byteOrder, note, depth, tagName)
+	}
+
+	// ISO is stored as two shorts; the second is the ISO speed
+	if iso, ok := note["ISO"].([]uint16); ok && len(iso) == 2 {
+		note["ISO"] = iso[1]
+	}
+	if lens, ok := note["Lens"].([]Rational); ok {
+		if desc, ok := describeNikonLens(lens); ok {
+			note["Lens"] = desc
+		} else {
+			delete(note, "Lens")
+		}
+	}
+
+	return note
+}
+
+// describeNikonLens formats the Lens tag (min/max focal length and the
+// maximum aperture at each) the way exiftool does, e.g. "24-70mm f/2.8".
+func describeNikonLens(lens []Rational) (string, bool) {
+	if len(lens) != 4 || !finiteRationals(lens) {
+		return "", false
+	}
+
+	rangeString := func(a, b float64) string {
+		if a == b {
+			return strconv.FormatFloat(a, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(a, 'f', -1, 64) + "-" + strconv.FormatFloat(b, 'f', -1, 64)
+	}
+	return rangeString(lens[0].Float64(), lens[1].Float64()) + "mm f/" +
+		rangeString(lens[2].Float64(), lens[3].Float64()), true
+}
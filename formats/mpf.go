@@ -0,0 +1,92 @@
+package formats
+
+import "encoding/binary"
+
+// mpfAPP2Prefix identifies a Multi-Picture Format APP2 segment.
+const mpfAPP2Prefix = "MPF\x00"
+
+// mpfTagNames names the MP Index IFD tags.
+var mpfTagNames = map[uint16]string{
+	0xB000: "MPFVersion",
+	0xB001: "NumberOfImages",
+	0xB002: "MPEntry",
+	0xB003: "ImageUIDList",
+	0xB004: "TotalFrames",
+}
+
+// mpfImageTypes names the MP type codes held in the low 24 bits of an
+// entry's attributes.
+var mpfImageTypes = map[uint32]string{
+	0x000000: "Undefined",
+	0x010001: "Large Thumbnail (VGA)",
+	0x010002: "Large Thumbnail (Full HD)",
+	0x020001: "Multi-Frame Panorama",
+	0x020002: "Multi-Frame Disparity",
+	0x020003: "Multi-Frame Multi-Angle",
+	0x030000: "Baseline MP Primary Image",
+}
+
+// MPImage describes one image listed in a JPEG Multi-Picture Format index.
+type MPImage struct {
+	// Attributes holds the raw entry flags (dependent parent/child,
+	// representative image, data format) and the MP type code.
+	Attributes uint32 `json:"attributes"`
+	Type       string `json:"type"`
+	Size       uint32 `json:"size"`
+	// Offset is the position of the image in the file. The first image
+	// is the file itself and always has offset 0.
+	Offset int64 `json:"offset"`
+}
+
+// parseMPF decodes the MP Index IFD of an MPF APP2 payload (after the
+// identifier). base is the file position of the payload, which entry
+// offsets are relative to.
+func parseMPF(data []byte, base int64) ([]MPImage, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+
+	// The MP Index holds only its own tags; pointers such as ExifIFD in
+	// it are not followed
+	index := make(map[string]interface{})
+	walk := newIFDWalk()
+	walk.ownTagsOnly = true
+	walk.parseIFD(data, int(order.Uint32(data[4:8])), order, index, 0, func(tag uint16) string {
+		return mpfTagNames[tag]
+	})
+
+	// Each MP entry is 16 bytes: attributes, size, offset and two
+	// dependent image entry numbers
+	entries, ok := index["MPEntry"].(Undefined)
+	if !ok || len(entries) < 16 {
+		return nil, false
+	}
+
+	images := make([]MPImage, 0, len(entries)/16)
+	for i := 0; i+16 <= len(entries); i += 16 {
+		attributes := order.Uint32(entries[i : i+4])
+		img := MPImage{
+			Attributes: attributes,
+			Type:       "Unknown",
+			Size:       order.Uint32(entries[i+4 : i+8]),
+		}
+		if name, ok := mpfImageTypes[attributes&0xFFFFFF]; ok {
+			img.Type = name
+		}
+		if offset := order.Uint32(entries[i+8 : i+12]); offset != 0 {
+			img.Offset = base + int64(offset)
+		}
+		images = append(images, img)
+	}
+	return images, true
+}
@@ -0,0 +1,120 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultMaxChunkSize is the largest chunk or segment a parser reads into
+// memory when Options.MaxChunkSize is zero.
+const DefaultMaxChunkSize = 64 << 20
+
+// Options tunes parser behaviour. The zero value selects the defaults used by Extract.
+type Options struct {
+	// EstimateUniqueColors reports how many palette entries an indexed image
+	// actually uses in Additional["UniqueColors"]. For PNG this is the PLTE
+	// entry count; for GIF a bounded amount of image data is decoded.
+	EstimateUniqueColors bool
+
+	// StopAtImageData ends PNG parsing at the first IDAT chunk. Chunks that
+	// precede the image data (IHDR, PLTE, iCCP, pHYs, acTL, ...) are still
+	// read, but eXIf and text chunks placed after it are missed.
+	StopAtImageData bool
+
+	// SkipPalette seeks past the GIF global color table instead of reading
+	// it into Additional["Palette"].
+	SkipPalette bool
+
+	// SkipEXIF leaves EXIF blocks undecoded, so Result.EXIF stays empty.
+	// JPEG, PNG and WebP skip over the block without reading it.
+	SkipEXIF bool
+
+	// DeferEXIF keeps EXIF blocks undecoded in Result.RawEXIF, leaving
+	// Result.EXIF empty, for callers that decode them with DecodeEXIF
+	// only when needed. JPEG, PNG and WebP honor it; of several JPEG EXIF
+	// segments only the first is kept.
+	DeferEXIF bool
+
+	// GroupEXIF returns Result.EXIF grouped by directory: "IFD0",
+	// "ExifIFD", "GPS", "Interop" and "IFD1" sub-maps instead of the flat
+	// map. FlattenEXIF turns it back into the flat form.
+	GroupEXIF bool
+
+	// Strict makes truncated data an error where parsers would otherwise
+	// return the metadata read so far.
+	Strict bool
+
+	// VerifyCRC reads every PNG chunk, image data included, and compares
+	// its CRC-32 with the stored value. The outcome is reported in
+	// Additional["CRCValid"], with the types of failing chunks in
+	// Additional["CRCMismatches"]; with Strict a mismatch is an error.
+	VerifyCRC bool
+
+	// InputSize is the total length of the input, or zero when unknown.
+	// Chunk and segment lengths that run past it are rejected before any
+	// buffer is allocated for them.
+	InputSize int64
+
+	// MaxChunkSize bounds a single chunk or segment read into memory: a
+	// JPEG APP segment, a PNG chunk, a WebP metadata chunk or an AVIF or
+	// JPEG 2000 box. Zero selects DefaultMaxChunkSize.
+	MaxChunkSize int64
+
+	// Logger, when set, is told about data the parsers skip instead of
+	// failing on: segments that fail to decode, truncated IFDs and chunks
+	// of unknown type.
+	Logger Logger
+}
+
+// Logger receives diagnostic events from the parsers. The event is a short
+// fixed message and detail holds alternating keys and values, so the Debug
+// method of a *slog.Logger can be used directly.
+type Logger func(event string, detail ...interface{})
+
+// log reports an event to o.Logger, if there is one.
+func (o Options) log(event string, detail ...interface{}) {
+	if o.Logger != nil {
+		o.Logger(event, detail...)
+	}
+}
+
+// checkLength rejects a length field read from the input when allocating
+// n bytes at the current position of r would exceed the chunk size limit
+// or run past the end of the input.
+func (o Options) checkLength(r io.Seeker, n int64, what string) error {
+	limit := o.MaxChunkSize
+	if limit <= 0 {
+		limit = DefaultMaxChunkSize
+	}
+	if n > limit {
+		return fmt.Errorf("%w: %s of %d bytes exceeds the %d byte limit", ErrInvalidData, what, n, limit)
+	}
+	if o.InputSize > 0 {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if n > o.InputSize-pos {
+			return fmt.Errorf("%w: %s of %d bytes runs past the end of the input", ErrInvalidData, what, n)
+		}
+	}
+	return nil
+}
+
+// withInputSize returns o with InputSize taken from the end of r when the
+// caller did not set it, so checkLength also applies to the Extract
+// functions. The position of r is kept.
+func (o Options) withInputSize(r io.Seeker) (Options, error) {
+	if o.InputSize > 0 {
+		return o, nil
+	}
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return o, err
+	}
+	if o.InputSize, err = r.Seek(0, io.SeekEnd); err != nil {
+		return o, err
+	}
+	_, err = r.Seek(pos, io.SeekStart)
+	return o, err
+}
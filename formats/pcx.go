@@ -0,0 +1,109 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// pcxHeaderSize is the size of the fixed PCX file header.
+const pcxHeaderSize = 128
+
+// ExtractPCX extracts metadata from a ZSoft PCX file.
+func ExtractPCX(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, pcxHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read PCX header: %w", err)
+	}
+	if header[0] != 0x0A || !isPCXVersion(header[1]) {
+		return nil, fmt.Errorf("%w: invalid PCX header", ErrInvalidData)
+	}
+
+	xMin := binary.LittleEndian.Uint16(header[4:6])
+	yMin := binary.LittleEndian.Uint16(header[6:8])
+	xMax := binary.LittleEndian.Uint16(header[8:10])
+	yMax := binary.LittleEndian.Uint16(header[10:12])
+	if xMax < xMin || yMax < yMin {
+		return nil, fmt.Errorf("%w: invalid PCX image window", ErrInvalidData)
+	}
+
+	bitsPerPixel := int(header[3])
+	planes := int(header[65])
+	hDPI := binary.LittleEndian.Uint16(header[12:14])
+	vDPI := binary.LittleEndian.Uint16(header[14:16])
+
+	result := newResult()
+	result.Width = int(xMax-xMin) + 1
+	result.Height = int(yMax-yMin) + 1
+	result.ColorDepth = bitsPerPixel * planes
+
+	switch {
+	case bitsPerPixel == 8 && planes == 3:
+		result.ColorSpace = "RGB"
+	case bitsPerPixel == 8 && planes == 4:
+		result.ColorSpace = "RGBA"
+	case bitsPerPixel == 1 && planes == 1:
+		result.ColorSpace = "Grayscale"
+	default:
+		result.ColorSpace = "Indexed"
+	}
+
+	result.Additional["Version"] = int(header[1])
+	result.Additional["RLE"] = header[2] == 1
+	result.Additional["BitsPerPixel"] = bitsPerPixel
+	result.Additional["Planes"] = planes
+	result.Additional["BytesPerLine"] = int(binary.LittleEndian.Uint16(header[66:68]))
+	if hDPI > 0 && vDPI > 0 {
+		result.Additional["DPIX"] = int(hDPI)
+		result.Additional["DPIY"] = int(vDPI)
+	}
+
+	return result, nil
+}
+
+// isPCXVersion reports whether v is a known PCX version byte.
+func isPCXVersion(v byte) bool {
+	switch v {
+	case 0, 2, 3, 4, 5:
+		return true
+	}
+	return false
+}
+
+// isConsistentPCXHeader reports whether the header fields agree with each
+// other. A leading 0x0A is too weak a signature on its own.
+func isConsistentPCXHeader(b []byte) bool {
+	if len(b) < 68 || b[0] != 0x0A || !isPCXVersion(b[1]) || b[2] > 1 || b[64] != 0 {
+		return false
+	}
+
+	bitsPerPixel := int(b[3])
+	planes := int(b[65])
+	switch bitsPerPixel {
+	case 1, 2, 4, 8:
+	default:
+		return false
+	}
+	if planes < 1 || planes > 4 {
+		return false
+	}
+
+	xMin := binary.LittleEndian.Uint16(b[4:6])
+	yMin := binary.LittleEndian.Uint16(b[6:8])
+	xMax := binary.LittleEndian.Uint16(b[8:10])
+	yMax := binary.LittleEndian.Uint16(b[10:12])
+	if xMax < xMin || yMax < yMin {
+		return false
+	}
+
+	// Each scan line holds at least width*bpp bits and is padded to an even size
+	width := int(xMax-xMin) + 1
+	bytesPerLine := int(binary.LittleEndian.Uint16(b[66:68]))
+	return bytesPerLine%2 == 0 && bytesPerLine*8 >= width*bitsPerPixel
+}
@@ -1,13 +1,26 @@
 package formats
 
 import (
+	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 )
 
+// pngSignature is the 8-byte PNG file signature.
+var pngSignature = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+
+// maxPNGTextSize caps the decompressed size of a zTXt or iTXt chunk.
+const maxPNGTextSize = 4 << 20
+
 // ExtractPNG extracts metadata from a PNG file.
 func ExtractPNG(r io.ReadSeeker) (*Result, error) {
+	return extractPNG(r, Options{})
+}
+
+func extractPNG(r io.ReadSeeker, opts Options) (*Result, error) {
 	// Reset to beginning
 	_, err := r.Seek(0, io.SeekStart)
 	if err != nil {
@@ -16,27 +29,52 @@ func ExtractPNG(r io.ReadSeeker) (*Result, error) {
 
 	// Read PNG signature (8 bytes)
 	sig := make([]byte, 8)
-	_, err = r.Read(sig)
+	err = readFull(r, sig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read PNG signature: %w", err)
 	}
 
 	// Verify PNG signature
-	pngSig := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
-	for i := 0; i < 8; i++ {
-		if sig[i] != pngSig[i] {
-			return nil, fmt.Errorf("%w: invalid PNG file", ErrInvalidData)
-		}
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, fmt.Errorf("%w: invalid PNG file", ErrInvalidData)
 	}
 
 	result := newResult()
 	hasICC := false
+	colorType := -1
+	hasTransparency := false
+	complete := false     // IEND was reached, or parsing stopped early on request
+	var frameDelays []int // APNG frame delays in ms, from fcTL
+
+	// Read chunks; an error stops the walk but keeps what was read before it
+	var parseErr error
+
+	// checkCRC compares a chunk's stored CRC with crc and reports whether
+	// the walk may go on. Mismatches are collected unless Strict.
+	var crcMismatches []string
+	checkCRC := func(crc uint32, chunkType string) bool {
+		stored := make([]byte, 4)
+		if err := readFull(r, stored); err != nil {
+			if opts.Strict {
+				parseErr = fmt.Errorf("%w: missing PNG %s chunk CRC", ErrInvalidData, chunkType)
+			}
+			return false
+		}
+		if binary.BigEndian.Uint32(stored) == crc {
+			return true
+		}
+		if opts.Strict {
+			parseErr = fmt.Errorf("%w: PNG %s chunk CRC mismatch", ErrInvalidData, chunkType)
+			return false
+		}
+		crcMismatches = append(crcMismatches, chunkType)
+		return true
+	}
 
-	// Read chunks
 	for {
 		// Read chunk length (4 bytes, big-endian)
 		lengthBytes := make([]byte, 4)
-		_, err = r.Read(lengthBytes)
+		err = readFull(r, lengthBytes)
 		if err != nil {
 			break
 		}
@@ -44,36 +82,77 @@ func ExtractPNG(r io.ReadSeeker) (*Result, error) {
 
 		// Read chunk type (4 bytes)
 		chunkType := make([]byte, 4)
-		_, err = r.Read(chunkType)
+		err = readFull(r, chunkType)
 		if err != nil {
 			break
 		}
 		chunkTypeStr := string(chunkType)
 
+		if chunkTypeStr == "IDAT" && opts.StopAtImageData {
+			complete = true
+			break
+		}
+
+		// Image data is never inspected, nor is eXIf when EXIF is skipped;
+		// skip it along with its CRC, or stream it through the checksum
+		// when verifying
+		if chunkTypeStr == "IDAT" || chunkTypeStr == "fdAT" || (chunkTypeStr == "eXIf" && opts.SkipEXIF) {
+			if !opts.VerifyCRC {
+				if _, err := r.Seek(int64(length)+4, io.SeekCurrent); err != nil {
+					break
+				}
+				continue
+			}
+			crc := crc32.NewIEEE()
+			crc.Write(chunkType)
+			if _, err := io.CopyN(crc, r, int64(length)); err != nil {
+				if opts.Strict {
+					parseErr = fmt.Errorf("%w: truncated PNG %s chunk", ErrInvalidData, chunkTypeStr)
+				}
+				break
+			}
+			if !checkCRC(crc.Sum32(), chunkTypeStr) {
+				break
+			}
+			continue
+		}
+
 		// Read chunk data
+		if err := opts.checkLength(r, int64(length), "PNG "+chunkTypeStr+" chunk"); err != nil {
+			parseErr = err
+			break
+		}
 		chunkData := make([]byte, length)
 		if length > 0 {
-			_, err = r.Read(chunkData)
+			err = readFull(r, chunkData)
 			if err != nil {
+				if opts.Strict {
+					parseErr = fmt.Errorf("%w: truncated PNG %s chunk", ErrInvalidData, chunkTypeStr)
+				}
 				break
 			}
 		}
 
-		// Read CRC (4 bytes, but we'll skip it)
-		crc := make([]byte, 4)
-		r.Read(crc)
+		// Skip CRC (4 bytes), or compare it when verifying
+		if opts.VerifyCRC {
+			crc := crc32.Update(crc32.ChecksumIEEE(chunkType), crc32.IEEETable, chunkData)
+			if !checkCRC(crc, chunkTypeStr) {
+				break
+			}
+		} else {
+			r.Seek(4, io.SeekCurrent)
+		}
 
 		// Process IHDR chunk (Image Header)
 		if chunkTypeStr == "IHDR" && length >= 13 {
 			result.Width = int(binary.BigEndian.Uint32(chunkData[0:4]))
 			result.Height = int(binary.BigEndian.Uint32(chunkData[4:8]))
 			bitDepth := int(chunkData[8])
-			colorType := int(chunkData[9])
+			colorType = int(chunkData[9])
 			compressionMethod := int(chunkData[10])
 			filterMethod := int(chunkData[11])
 			interlaceMethod := int(chunkData[12])
 
-			result.ColorDepth = bitDepth
 			result.Additional["BitDepth"] = bitDepth
 			result.Additional["ColorType"] = colorType
 			result.Additional["CompressionMethod"] = compressionMethod
@@ -95,31 +174,345 @@ func ExtractPNG(r io.ReadSeeker) (*Result, error) {
 			default:
 				result.ColorSpace = "Unknown"
 			}
+			result.ColorDepth = bitDepth * pngChannels(colorType)
+		}
+
+		// Process PLTE chunk (palette); every entry of an indexed image's
+		// palette is assumed to be referenced
+		if chunkTypeStr == "PLTE" {
+			if palette, ok := parsePLTE(chunkData); ok {
+				result.Additional["PaletteSize"] = len(palette)
+				result.Additional["Palette"] = palette
+			} else if opts.Strict {
+				parseErr = fmt.Errorf("%w: PLTE length %d is not 1-256 RGB entries", ErrInvalidData, length)
+				break
+			} else {
+				opts.log("skipped PNG chunk", "type", "PLTE", "error", "invalid palette length")
+			}
+		}
+		if chunkTypeStr == "PLTE" && colorType == 3 && opts.EstimateUniqueColors {
+			result.Additional["UniqueColors"] = length / 3
+		}
+
+		// Process pHYs chunk (physical pixel dimensions)
+		if chunkTypeStr == "pHYs" && length >= 9 {
+			parsePHYs(chunkData, result)
+		}
+
+		// Process acTL chunk (APNG animation control); it precedes the first IDAT
+		if chunkTypeStr == "acTL" && length >= 8 {
+			result.Additional["IsAnimated"] = true
+			result.Additional["FrameCount"] = int(binary.BigEndian.Uint32(chunkData[0:4]))
+			result.Additional["LoopCount"] = int(binary.BigEndian.Uint32(chunkData[4:8])) // 0 = infinite
+		}
+
+		// Process fcTL chunks (APNG frame control) for the frame delays
+		if chunkTypeStr == "fcTL" && length >= 26 {
+			frameDelays = append(frameDelays, apngDelay(chunkData[20:22], chunkData[22:24]))
+		}
+
+		// Process tRNS (transparency) and bKGD (background) chunks; both
+		// depend on the color type from IHDR
+		if chunkTypeStr == "tRNS" && colorType >= 0 {
+			hasTransparency = parseTRNS(chunkData, colorType, result) || hasTransparency
+		}
+		if chunkTypeStr == "bKGD" && colorType >= 0 {
+			parseBKGD(chunkData, colorType, result)
+		}
+
+		// Process textual chunks
+		if chunkTypeStr == "tEXt" || chunkTypeStr == "zTXt" || chunkTypeStr == "iTXt" {
+			parsePNGText(chunkTypeStr, chunkData, result)
 		}
 
 		// Process iCCP chunk (ICC Profile)
 		if chunkTypeStr == "iCCP" {
 			hasICC = true
+			if icc, ok := parseICCP(chunkData); ok {
+				result.Additional["ICCProfile"] = icc
+			} else {
+				opts.log("skipped PNG chunk", "type", "iCCP", "error", "invalid profile")
+			}
 		}
 
 		// Process eXIf chunk (EXIF data)
-		if chunkTypeStr == "eXIf" {
+		if chunkTypeStr == "eXIf" && opts.Strict {
+			if err := validateTIFF(chunkData); err != nil {
+				parseErr = err
+				break
+			}
+		}
+		if chunkTypeStr == "eXIf" && opts.DeferEXIF {
+			result.RawEXIF = chunkData
+		} else if chunkTypeStr == "eXIf" {
 			// Parse EXIF from chunk data
-			exifData, err := parseTIFF(chunkData)
+			exifData, err := parseTIFF(chunkData, opts)
 			if err == nil {
 				for k, v := range exifData {
 					result.EXIF[k] = v
 				}
+				result.RawEXIF = chunkData
+			} else {
+				opts.log("skipped PNG chunk", "type", "eXIf", "error", err)
 			}
 		}
 
 		// Stop after IEND chunk
 		if chunkTypeStr == "IEND" {
+			complete = true
 			break
 		}
 	}
 
+	if animated, _ := result.Additional["IsAnimated"].(bool); animated && len(frameDelays) > 0 {
+		duration := 0
+		for _, d := range frameDelays {
+			duration += d
+		}
+		result.Additional["FrameDelays"] = frameDelays
+		result.Additional["Duration"] = duration
+	}
+
+	if !complete {
+		result.Additional["Truncated"] = true
+		if opts.Strict && parseErr == nil {
+			parseErr = fmt.Errorf("%w: PNG ends before IEND", ErrInvalidData)
+		}
+	}
+
+	if opts.VerifyCRC {
+		result.Additional["CRCValid"] = len(crcMismatches) == 0
+		if len(crcMismatches) > 0 {
+			result.Additional["CRCMismatches"] = crcMismatches
+		}
+	}
+
 	result.HasICCProfile = hasICC
+	result.Additional["HasTransparency"] = hasTransparency || colorType == 4 || colorType == 6
+
+	return result, parseErr
+}
+
+// apngDelay converts an fcTL delay fraction of a second to milliseconds. A
+// zero denominator means hundredths.
+func apngDelay(num, den []byte) int {
+	n := int(binary.BigEndian.Uint16(num))
+	d := int(binary.BigEndian.Uint16(den))
+	if d == 0 {
+		d = 100
+	}
+	return (n*1000 + d/2) / d
+}
 
-	return result, nil
+// parsePLTE splits a PLTE chunk into RGB entries. The length must be a
+// multiple of 3 holding 1 to 256 entries.
+func parsePLTE(data []byte) ([][3]uint8, bool) {
+	if len(data) == 0 || len(data)%3 != 0 || len(data) > 256*3 {
+		return nil, false
+	}
+	palette := make([][3]uint8, len(data)/3)
+	for i := range palette {
+		copy(palette[i][:], data[i*3:])
+	}
+	return palette, true
+}
+
+// parsePHYs records the pixels per unit of a pHYs chunk. Unit 1 is the meter
+// and converts to DPI; otherwise only the pixel aspect ratio is known.
+func parsePHYs(data []byte, res *Result) {
+	x := binary.BigEndian.Uint32(data[0:4])
+	y := binary.BigEndian.Uint32(data[4:8])
+	if x == 0 || y == 0 {
+		return
+	}
+	res.Additional["PixelsPerUnitX"] = x
+	res.Additional["PixelsPerUnitY"] = y
+
+	if data[8] == 1 {
+		res.Additional["DPIX"] = dpiFromPixelsPerMeter(x)
+		res.Additional["DPIY"] = dpiFromPixelsPerMeter(y)
+		return
+	}
+	// Width of a pixel relative to its height
+	res.Additional["PixelAspectRatio"] = float64(y) / float64(x)
+}
+
+// parseICCP decodes an iCCP chunk: a profile name, a NUL separator, the
+// compression method, then the zlib-compressed profile.
+func parseICCP(data []byte) (ICCProfile, bool) {
+	sep := bytes.IndexByte(data, 0)
+	// Method 0 (deflate) is the only one defined
+	if sep < 1 || sep+2 > len(data) || data[sep+1] != 0 {
+		return ICCProfile{}, false
+	}
+
+	profile, err := inflateLimited(data[sep+2:], maxICCProfileSize)
+	if err != nil {
+		return ICCProfile{}, false
+	}
+
+	icc, ok := parseICCProfile(profile)
+	if !ok {
+		return ICCProfile{}, false
+	}
+	icc.Name = decodeTextBytes(data[:sep])
+	return icc, true
+}
+
+// pngChannels returns the samples per pixel of an IHDR color type. Indexed
+// pixels are a single palette index.
+func pngChannels(colorType int) int {
+	switch colorType {
+	case 2:
+		return 3
+	case 4:
+		return 2
+	case 6:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// parseTRNS records the transparency chunk: one alpha value per palette
+// entry for indexed images, or a single fully transparent color (one gray
+// or three RGB samples) for grayscale and truecolor images.
+func parseTRNS(data []byte, colorType int, res *Result) bool {
+	switch colorType {
+	case 3:
+		if len(data) == 0 {
+			return false
+		}
+		res.Additional["PaletteAlpha"] = append([]byte(nil), data...)
+	case 0, 2:
+		samples, ok := pngSamples(data, colorType)
+		if !ok {
+			return false
+		}
+		res.Additional["TransparentColor"] = samples
+	default:
+		// Types with an alpha channel may not carry tRNS
+		return false
+	}
+	return true
+}
+
+// parseBKGD records the suggested background: a palette index for indexed
+// images, otherwise gray or RGB samples at the image bit depth.
+func parseBKGD(data []byte, colorType int, res *Result) {
+	if colorType == 3 {
+		if len(data) >= 1 {
+			res.Additional["BackgroundColorIndex"] = int(data[0])
+		}
+		return
+	}
+	if samples, ok := pngSamples(data, colorType); ok {
+		res.Additional["BackgroundColor"] = samples
+	}
+}
+
+// pngSamples reads the 16-bit gray or RGB samples of a tRNS or bKGD chunk.
+func pngSamples(data []byte, colorType int) ([]uint16, bool) {
+	n := 1
+	if colorType == 2 || colorType == 6 {
+		n = 3
+	}
+	if len(data) < 2*n {
+		return nil, false
+	}
+	samples := make([]uint16, n)
+	for i := range samples {
+		samples[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return samples, true
+}
+
+// parsePNGText decodes a tEXt, zTXt or iTXt chunk into Additional["Text"],
+// keyed by keyword. iTXt entries with a language tag are keyed
+// "keyword-language" and their translated keyword is kept in
+// Additional["TextTranslatedKeywords"] under the same key.
+func parsePNGText(chunkType string, data []byte, res *Result) {
+	sep := bytes.IndexByte(data, 0)
+	if sep < 1 {
+		return
+	}
+	key := decodeTextBytes(data[:sep])
+	rest := data[sep+1:]
+
+	var text string
+	switch chunkType {
+	case "tEXt":
+		text = decodeTextBytes(rest)
+
+	case "zTXt":
+		// Compression method 0 (deflate) is the only one defined
+		if len(rest) < 1 || rest[0] != 0 {
+			return
+		}
+		inflated, err := inflateLimited(rest[1:], maxPNGTextSize)
+		if err != nil {
+			return
+		}
+		text = decodeTextBytes(inflated)
+
+	case "iTXt":
+		// Compression flag and method, then language tag and translated keyword
+		if len(rest) < 2 {
+			return
+		}
+		compressed, method := rest[0] == 1, rest[1]
+		fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(fields) < 3 {
+			return
+		}
+		language, translated, payload := string(fields[0]), string(fields[1]), fields[2]
+		if compressed {
+			if method != 0 {
+				return
+			}
+			inflated, err := inflateLimited(payload, maxPNGTextSize)
+			if err != nil {
+				return
+			}
+			payload = inflated
+		}
+		if language != "" {
+			key += "-" + language
+		}
+		if translated != "" {
+			translations, _ := res.Additional["TextTranslatedKeywords"].(map[string]string)
+			if translations == nil {
+				translations = make(map[string]string)
+				res.Additional["TextTranslatedKeywords"] = translations
+			}
+			translations[key] = translated
+		}
+		text = string(payload)
+	}
+
+	texts, _ := res.Additional["Text"].(map[string]string)
+	if texts == nil {
+		texts = make(map[string]string)
+		res.Additional["Text"] = texts
+	}
+	texts[key] = text
+}
+
+// inflateLimited decompresses a zlib stream, failing when the output would
+// exceed limit bytes so a small chunk cannot expand without bound.
+func inflateLimited(data []byte, limit int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: decompressed data exceeds %d bytes", ErrInvalidData, limit)
+	}
+	return out, nil
 }
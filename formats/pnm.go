@@ -0,0 +1,113 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxPNMHeaderBytes bounds how much is read while looking for the header fields.
+const maxPNMHeaderBytes = 4096
+
+// ExtractPNM extracts metadata from a Netpbm PBM, PGM or PPM file in either
+// the ASCII (P1-P3) or binary (P4-P6) variant.
+func ExtractPNM(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := readUpTo(r, maxPNMHeaderBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PNM header: %w", err)
+	}
+	if len(header) < 2 || header[0] != 'P' || header[1] < '1' || header[1] > '6' {
+		return nil, fmt.Errorf("%w: invalid PNM magic number", ErrInvalidData)
+	}
+
+	variant := header[1] - '0'
+	isBitmap := variant == 1 || variant == 4
+
+	// Width, height and (except for bitmaps) the maximum sample value
+	fieldCount := 3
+	if isBitmap {
+		fieldCount = 2
+	}
+	fields := pnmHeaderFields(header[2:], fieldCount)
+	if len(fields) < fieldCount {
+		return nil, fmt.Errorf("%w: incomplete PNM header", ErrInvalidData)
+	}
+
+	result := newResult()
+	result.Width = fields[0]
+	result.Height = fields[1]
+
+	channels := 1
+	result.ColorSpace = "Grayscale"
+	if variant == 3 || variant == 6 {
+		channels = 3
+		result.ColorSpace = "RGB"
+	}
+
+	bitsPerSample := 1
+	if !isBitmap {
+		maxValue := fields[2]
+		if maxValue <= 0 || maxValue > 65535 {
+			return nil, fmt.Errorf("%w: invalid PNM maximum value %d", ErrInvalidData, maxValue)
+		}
+		bitsPerSample = 8
+		if maxValue > 255 {
+			bitsPerSample = 16
+		}
+		result.Additional["MaxValue"] = maxValue
+	}
+	result.ColorDepth = bitsPerSample * channels
+
+	switch variant {
+	case 1, 4:
+		result.Additional["Variant"] = "PBM"
+	case 2, 5:
+		result.Additional["Variant"] = "PGM"
+	default:
+		result.Additional["Variant"] = "PPM"
+	}
+	if variant <= 3 {
+		result.Additional["Encoding"] = "ASCII"
+	} else {
+		result.Additional["Encoding"] = "Binary"
+	}
+
+	return result, nil
+}
+
+// pnmHeaderFields returns up to n whitespace-separated integers from data,
+// skipping '#' comments that run to the end of the line.
+func pnmHeaderFields(data []byte, n int) []int {
+	var fields []int
+	i := 0
+	for i < len(data) && len(fields) < n {
+		c := data[i]
+		switch {
+		case c == '#':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+				i++
+			}
+			v, err := strconv.Atoi(string(data[start:i]))
+			if err != nil {
+				return fields
+			}
+			fields = append(fields, v)
+		default:
+			return fields
+		}
+	}
+	return fields
+}
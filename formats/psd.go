@@ -0,0 +1,89 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// psdColorModes maps PSD color mode values to their names.
+var psdColorModes = map[uint16]string{
+	0: "Bitmap",
+	1: "Grayscale",
+	2: "Indexed",
+	3: "RGB",
+	4: "CMYK",
+	7: "Multichannel",
+	8: "Duotone",
+	9: "Lab",
+}
+
+// ExtractPSD extracts metadata from a Photoshop PSD or PSB file.
+func ExtractPSD(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read file header (26 bytes)
+	header := make([]byte, 26)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read PSD header: %w", err)
+	}
+
+	if string(header[0:4]) != "8BPS" {
+		return nil, fmt.Errorf("%w: missing 8BPS signature", ErrInvalidData)
+	}
+
+	version := binary.BigEndian.Uint16(header[4:6])
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("%w: unsupported PSD version %d", ErrInvalidData, version)
+	}
+
+	// Bytes 6-11 are reserved. PSB (version 2) keeps the same 32-bit
+	// dimension fields as PSD; only the allowed maximum grows.
+	channels := int(binary.BigEndian.Uint16(header[12:14]))
+	height := binary.BigEndian.Uint32(header[14:18])
+	width := binary.BigEndian.Uint32(header[18:22])
+	depth := int(binary.BigEndian.Uint16(header[22:24]))
+	colorMode := binary.BigEndian.Uint16(header[24:26])
+
+	result := newResult()
+	result.Width = int(width)
+	result.Height = int(height)
+	result.ColorDepth = depth * channels
+
+	switch colorMode {
+	case 0, 1, 8: // Bitmap, Grayscale, Duotone
+		result.ColorSpace = "Grayscale"
+		if colorMode == 1 && channels >= 2 {
+			result.ColorSpace = "GrayscaleAlpha"
+		}
+	case 2:
+		result.ColorSpace = "Indexed"
+	case 3:
+		result.ColorSpace = "RGB"
+		if channels >= 4 {
+			result.ColorSpace = "RGBA"
+		}
+	case 4:
+		result.ColorSpace = "CMYK"
+	case 9:
+		result.ColorSpace = "Lab"
+	default:
+		result.ColorSpace = "Unknown"
+	}
+
+	result.Additional["Version"] = int(version)
+	result.Additional["PSB"] = version == 2
+	result.Additional["Channels"] = channels
+	result.Additional["BitsPerChannel"] = depth
+	if name, ok := psdColorModes[colorMode]; ok {
+		result.Additional["ColorMode"] = name
+	} else {
+		result.Additional["ColorMode"] = int(colorMode)
+	}
+
+	return result, nil
+}
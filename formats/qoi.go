@@ -0,0 +1,53 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ExtractQOI extracts metadata from a QOI ("Quite OK Image") file.
+func ExtractQOI(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read QOI header (14 bytes)
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read QOI header: %w", err)
+	}
+	if string(header[0:4]) != "qoif" {
+		return nil, fmt.Errorf("%w: missing qoif magic", ErrInvalidData)
+	}
+
+	channels := header[12]
+	colorspace := header[13]
+
+	result := newResult()
+	result.Width = int(binary.BigEndian.Uint32(header[4:8]))
+	result.Height = int(binary.BigEndian.Uint32(header[8:12]))
+
+	switch channels {
+	case 3:
+		result.ColorSpace = "RGB"
+		result.ColorDepth = 24
+	case 4:
+		result.ColorSpace = "RGBA"
+		result.ColorDepth = 32
+	default:
+		return nil, fmt.Errorf("%w: invalid QOI channel count %d", ErrInvalidData, channels)
+	}
+
+	result.Additional["Channels"] = int(channels)
+	result.Additional["Colorspace"] = int(colorspace)
+	if colorspace == 0 {
+		result.Additional["ColorspaceName"] = "sRGB"
+	} else {
+		result.Additional["ColorspaceName"] = "Linear"
+	}
+
+	return result, nil
+}
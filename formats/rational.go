@@ -0,0 +1,51 @@
+package formats
+
+import (
+	"strconv"
+)
+
+// Rational is an unsigned EXIF RATIONAL value, kept as its original
+// numerator and denominator so values such as an ExposureTime of 1/200
+// survive unchanged.
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+// Float64 returns the value as a float64. A zero denominator yields +Inf,
+// or NaN for 0/0.
+func (r Rational) Float64() float64 {
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats the value as "num/den".
+func (r Rational) String() string {
+	return strconv.FormatInt(r.Num, 10) + "/" + strconv.FormatInt(r.Den, 10)
+}
+
+// MarshalJSON encodes the value as a "num/den" string.
+func (r Rational) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(r.String())), nil
+}
+
+// SRational is a signed EXIF SRATIONAL value.
+type SRational struct {
+	Num int64
+	Den int64
+}
+
+// Float64 returns the value as a float64. A zero denominator yields ±Inf,
+// or NaN for 0/0.
+func (r SRational) Float64() float64 {
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats the value as "num/den".
+func (r SRational) String() string {
+	return strconv.FormatInt(r.Num, 10) + "/" + strconv.FormatInt(r.Den, 10)
+}
+
+// MarshalJSON encodes the value as a "num/den" string.
+func (r SRational) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(r.String())), nil
+}
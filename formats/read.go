@@ -0,0 +1,84 @@
+package formats
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// readFull fills buf from r. Unlike a bare Read it never returns a partially
+// filled buffer: input that ends mid-buffer is reported as ErrInvalidData,
+// and io.EOF is returned only when no bytes were available at all.
+func readFull(r io.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of data", ErrInvalidData)
+	}
+	return err
+}
+
+// readUpTo reads at most n bytes, tolerating a shorter input.
+func readUpTo(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// bufferedReadSeeker buffers reads from an io.ReadSeeker, so that parsers
+// issuing many small reads do not hit the underlying file every time.
+// Forward seeks within the buffered data discard it; any other seek moves
+// the underlying reader and resets the buffer.
+type bufferedReadSeeker struct {
+	rs  io.ReadSeeker
+	br  *bufio.Reader
+	pos int64 // logical position, behind rs by br.Buffered()
+}
+
+// newBufferedReadSeeker wraps rs, which must be positioned at pos.
+func newBufferedReadSeeker(rs io.ReadSeeker, pos int64) *bufferedReadSeeker {
+	if b, ok := rs.(*bufferedReadSeeker); ok {
+		return b
+	}
+	return &bufferedReadSeeker{rs: rs, br: bufio.NewReader(rs), pos: pos}
+}
+
+func (b *bufferedReadSeeker) Read(p []byte) (int, error) {
+	n, err := b.br.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *bufferedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.pos + offset
+	default:
+		n, err := b.rs.Seek(offset, whence)
+		if err != nil {
+			return 0, err
+		}
+		b.br.Reset(b.rs)
+		b.pos = n
+		return n, nil
+	}
+
+	if skip := abs - b.pos; skip >= 0 && skip <= int64(b.br.Buffered()) {
+		b.br.Discard(int(skip))
+		b.pos = abs
+		return abs, nil
+	}
+	n, err := b.rs.Seek(abs, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	b.br.Reset(b.rs)
+	b.pos = n
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Parser extracts the metadata of one format. It seeks r to the start of
+// the input itself.
+type Parser func(r io.ReadSeeker, opts Options) (*Result, error)
+
+var (
+	registryMu sync.RWMutex
+	parsers    = make(map[string]Parser)
+
+	// headerSignatures are matched against the leading bytes of the input,
+	// longest first.
+	headerSignatures []Signature
+
+	// trailerSignatures are matched against the end of the input when no
+	// header signature matched, in registration order.
+	trailerSignatures []Signature
+)
+
+// Register makes a format known to Detect, DetectTrailer and Extract. The
+// Format field of each signature is set to format; signatures with a
+// negative Offset are trailers. Header signatures are tried by decreasing
+// Length, so a longer, more specific magic wins over a shorter one, and
+// signatures of equal Length in registration order, built-in formats first.
+//
+// Register is meant to be called from init functions. It panics if format
+// is empty or already registered, if parser is nil, or if a signature has
+// no Match function or a Length outside 1 to MaxHeaderSize.
+func Register(format string, parser Parser, sigs ...Signature) {
+	if format == "" || parser == nil {
+		panic("formats: Register needs a format name and a parser")
+	}
+	for _, sig := range sigs {
+		if sig.Match == nil || sig.Length < 1 || sig.Length > MaxHeaderSize {
+			panic(fmt.Sprintf("formats: invalid signature for %s", format))
+		}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := parsers[format]; dup {
+		panic("formats: Register called twice for " + format)
+	}
+	parsers[format] = parser
+
+	// Copy on write, so DetectTrailer can keep iterating an older slice
+	// after releasing the lock
+	header := append([]Signature(nil), headerSignatures...)
+	trailer := append([]Signature(nil), trailerSignatures...)
+	for _, sig := range sigs {
+		sig.Format = format
+		if sig.Offset < 0 {
+			trailer = append(trailer, sig)
+		} else {
+			header = append(header, sig)
+		}
+	}
+	sort.SliceStable(header, func(i, j int) bool {
+		return header[i].Length > header[j].Length
+	})
+	headerSignatures, trailerSignatures = header, trailer
+}
+
+// withoutOptions adapts a parser that has no options.
+func withoutOptions(extract func(io.ReadSeeker) (*Result, error)) Parser {
+	return func(r io.ReadSeeker, _ Options) (*Result, error) {
+		return extract(r)
+	}
+}
+
+// The built-in formats register like any other.
+func init() {
+	Register("JPEG", extractJPEG, Signature{Length: 3, Match: isJPEG})
+	Register("PNG", extractPNG, Signature{Length: 8, Match: isPNG})
+	Register("GIF", extractGIF, Signature{Length: 6, Match: isGIF})
+	Register("WebP", extractWebP, Signature{Length: 12, Match: isWebP})
+	Register("AVIF", extractAVIF, Signature{Length: 12, Match: isAVIF})
+	Register("JXL", withoutOptions(ExtractJXL),
+		Signature{Length: 2, Match: isJXLCodestream},
+		Signature{Length: 12, Match: isJXLContainer})
+	Register("BMP", extractBMP, Signature{Length: 2, Match: isBMP})
+	Register("ICO", withoutOptions(ExtractICO), Signature{Length: 6, Match: isICO})
+	Register("PSD", withoutOptions(ExtractPSD), Signature{Length: 6, Match: isPSD})
+	Register("PNM", withoutOptions(ExtractPNM), Signature{Length: 3, Match: isPNM})
+	Register("HDR", withoutOptions(ExtractHDR), Signature{Length: 6, Match: isHDR})
+	Register("DDS", withoutOptions(ExtractDDS), Signature{Length: 4, Match: isDDS})
+	Register("JP2", extractJP2,
+		Signature{Length: 12, Match: isJP2},
+		Signature{Length: 4, Match: isJ2K})
+	Register("QOI", withoutOptions(ExtractQOI), Signature{Length: 4, Match: isQOI})
+	Register("CR2", extractTIFF, Signature{Length: 11, Match: isCR2})
+	Register("TIFF", extractTIFF, Signature{Length: 4, Match: isTIFF})
+	Register("PCX", withoutOptions(ExtractPCX), Signature{Length: 68, Match: isConsistentPCXHeader})
+	Register("SVG", withoutOptions(ExtractSVG), Signature{Length: 4, Match: isSVG})
+	Register("TGA", withoutOptions(ExtractTGA), Signature{Offset: -18, Length: 18, Match: isTGAFooter})
+}
@@ -2,6 +2,10 @@ package formats
 
 // Result captures format-specific metadata returned by parsers.
 type Result struct {
+	// Format overrides the detected format when the parser finds a more
+	// specific one, such as a RAW file in a TIFF container.
+	Format string
+
 	Width         int
 	Height        int
 	ColorDepth    int
@@ -9,6 +13,10 @@ type Result struct {
 	HasICCProfile bool
 	EXIF          map[string]interface{}
 	Additional    map[string]interface{}
+
+	// RawEXIF is the TIFF-structured EXIF block the EXIF map was parsed
+	// from, retained so embedded data such as thumbnails can be sliced out.
+	RawEXIF []byte
 }
 
 // newResult allocates a result with initialized maps.
@@ -0,0 +1,193 @@
+package formats
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSVGHeaderBytes bounds how much of an SVG document is scanned for the
+// root element and its title.
+const maxSVGHeaderBytes = 1 << 20
+
+// svgUnits converts absolute CSS units to pixels at 96 DPI.
+var svgUnits = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 96.0 / 72.0,
+	"pc": 16,
+	"in": 96,
+	"cm": 96 / 2.54,
+	"mm": 96 / 25.4,
+}
+
+// ExtractSVG extracts metadata from an SVG document's root element.
+func ExtractSVG(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(io.LimitReader(r, maxSVGHeaderBytes))
+	decoder.Strict = false
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		// Attribute values we care about are ASCII in every common charset
+		return input, nil
+	}
+
+	root, err := nextStartElement(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SVG root element: %w", err)
+	}
+	if root.Name.Local != "svg" {
+		return nil, fmt.Errorf("%w: root element is <%s>, not <svg>", ErrInvalidData, root.Name.Local)
+	}
+
+	result := newResult()
+	result.ColorSpace = "RGB"
+
+	var widthAttr, heightAttr, viewBoxAttr string
+	for _, attr := range root.Attr {
+		switch attr.Name.Local {
+		case "width":
+			widthAttr = attr.Value
+		case "height":
+			heightAttr = attr.Value
+		case "viewBox":
+			viewBoxAttr = attr.Value
+		}
+	}
+
+	width, widthOK := parseSVGLength(widthAttr)
+	height, heightOK := parseSVGLength(heightAttr)
+
+	if viewBox, ok := parseViewBox(viewBoxAttr); ok {
+		result.Additional["ViewBox"] = viewBox
+		if !widthOK {
+			width, widthOK = viewBox[2], true
+		}
+		if !heightOK {
+			height, heightOK = viewBox[3], true
+		}
+	}
+
+	if widthOK {
+		result.Width = int(math.Ceil(width))
+	}
+	if heightOK {
+		result.Height = int(math.Ceil(height))
+	}
+
+	if title, ok := svgTitle(decoder); ok {
+		result.Additional["Title"] = title
+	}
+
+	return result, nil
+}
+
+// nextStartElement returns the next start element in the document.
+func nextStartElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// svgTitle returns the text of the root element's <title> child. Scanning
+// stops at the first child that is not descriptive content.
+func svgTitle(decoder *xml.Decoder) (string, bool) {
+	depth := 0
+	inTitle := false
+	var title strings.Builder
+
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				switch t.Name.Local {
+				case "title":
+					inTitle = true
+				case "desc", "metadata":
+				default:
+					return "", false
+				}
+			}
+			depth++
+
+		case xml.EndElement:
+			depth--
+			if depth < 0 {
+				return "", false
+			}
+			if inTitle && depth == 0 {
+				return strings.TrimSpace(title.String()), true
+			}
+
+		case xml.CharData:
+			if inTitle {
+				title.Write(t)
+			}
+		}
+	}
+}
+
+// parseSVGLength converts a width/height attribute to pixels. Relative units
+// such as percentages cannot be resolved and report false.
+func parseSVGLength(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	end := len(value)
+	for end > 0 && (value[end-1] >= 'a' && value[end-1] <= 'z' || value[end-1] == '%') {
+		end--
+	}
+
+	scale, ok := svgUnits[value[end:]]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * scale, true
+}
+
+// parseViewBox parses the four numbers of a viewBox attribute.
+func parseViewBox(value string) ([4]float64, bool) {
+	var box [4]float64
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) != 4 {
+		return box, false
+	}
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return box, false
+		}
+		box[i] = n
+	}
+	if box[2] <= 0 || box[3] <= 0 {
+		return box, false
+	}
+	return box, true
+}
@@ -0,0 +1,86 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// tgaFooterSig is the signature that ends a TGA 2.0 file.
+const tgaFooterSig = "TRUEVISION-XFILE.\x00"
+
+// ExtractTGA extracts metadata from a Truevision TGA file.
+func ExtractTGA(r io.ReadSeeker) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read TGA header (18 bytes)
+	header := make([]byte, 18)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read TGA header: %w", err)
+	}
+
+	if !isPlausibleTGAHeader(header) {
+		return nil, fmt.Errorf("%w: invalid TGA header", ErrInvalidData)
+	}
+
+	imageType := header[2]
+	pixelDepth := int(header[16])
+	descriptor := header[17]
+	alphaBits := int(descriptor & 0x0F)
+
+	result := newResult()
+	result.Width = int(binary.LittleEndian.Uint16(header[12:14]))
+	result.Height = int(binary.LittleEndian.Uint16(header[14:16]))
+	result.ColorDepth = pixelDepth
+
+	switch imageType {
+	case 1, 9: // Color-mapped
+		result.ColorSpace = "Indexed"
+	case 3, 11: // Grayscale
+		result.ColorSpace = "Grayscale"
+	default: // Truecolor
+		result.ColorSpace = "RGB"
+		if alphaBits > 0 {
+			result.ColorSpace = "RGBA"
+		}
+	}
+
+	result.Additional["ImageType"] = int(imageType)
+	result.Additional["RLE"] = imageType >= 9
+	result.Additional["AlphaBits"] = alphaBits
+	// Bit 5 of the descriptor selects a top-left origin, bit 4 right-to-left
+	result.Additional["TopDown"] = descriptor&0x20 != 0
+	result.Additional["RightToLeft"] = descriptor&0x10 != 0
+	if header[1] == 1 {
+		result.Additional["ColorMapLength"] = int(binary.LittleEndian.Uint16(header[5:7]))
+		result.Additional["ColorMapDepth"] = int(header[7])
+	}
+
+	return result, nil
+}
+
+// isPlausibleTGAHeader reports whether an 18-byte header has internally
+// consistent TGA fields. TGA has no leading magic, so this is only a
+// heuristic and is not used for auto-detection on its own.
+func isPlausibleTGAHeader(header []byte) bool {
+	if len(header) < 18 || header[1] > 1 {
+		return false
+	}
+	switch header[2] {
+	case 1, 2, 3, 9, 10, 11:
+	default:
+		return false
+	}
+	switch header[16] {
+	case 8, 15, 16, 24, 32:
+	default:
+		return false
+	}
+	width := binary.LittleEndian.Uint16(header[12:14])
+	height := binary.LittleEndian.Uint16(header[14:16])
+	return width > 0 && height > 0
+}
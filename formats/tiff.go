@@ -0,0 +1,416 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxTIFFPages bounds the IFD chain walked to count pages, so corrupt or
+// cyclic next-IFD offsets cannot keep the parser busy.
+const maxTIFFPages = 4096
+
+// maxTIFFEXIFSize bounds how much of the file is read to decode its tags
+// into EXIF. Tag values may live anywhere, so smaller files are read whole;
+// larger ones, such as camera RAW files, keep their tags near the start and
+// values past the limit are skipped.
+const maxTIFFEXIFSize = 16 << 20
+
+// maxTIFFSubIFDs bounds the SubIFDs followed from one IFD.
+const maxTIFFSubIFDs = 16
+
+// tiffTypeIFD is the TIFF field type of an IFD offset, used by SubIFDs.
+const tiffTypeIFD = 13
+
+// TIFF tags read for every page
+const (
+	tiffTagNewSubfileType  = 0x00FE
+	tiffTagImageWidth      = 0x0100
+	tiffTagImageLength     = 0x0101
+	tiffTagBitsPerSample   = 0x0102
+	tiffTagCompression     = 0x0103
+	tiffTagPhotometric     = 0x0106
+	tiffTagMake            = 0x010F
+	tiffTagStripOffsets    = 0x0111
+	tiffTagSamplesPerPixel = 0x0115
+	tiffTagStripByteCounts = 0x0117
+	tiffTagSubIFDs         = 0x014A
+	tiffTagExtraSamples    = 0x0152
+	tiffTagJPEGOffset      = 0x0201
+	tiffTagJPEGLength      = 0x0202
+	tiffTagICCProfile      = 0x8773
+	tiffTagDNGVersion      = 0xC612
+)
+
+// tiffCompressions names the common TIFF Compression values.
+var tiffCompressions = map[int]string{
+	1:     "None",
+	2:     "CCITT RLE",
+	3:     "CCITT Group 3",
+	4:     "CCITT Group 4",
+	5:     "LZW",
+	6:     "JPEG (old-style)",
+	7:     "JPEG",
+	8:     "Deflate",
+	32773: "PackBits",
+	32946: "Deflate",
+	50000: "Zstandard",
+}
+
+// TIFFPage describes one image (IFD) of a multi-page TIFF.
+type TIFFPage struct {
+	Width         int `json:"width"`
+	Height        int `json:"height"`
+	BitsPerSample int `json:"bitsPerSample"`
+}
+
+// tiffIFD holds the tags of one IFD that describe its image.
+type tiffIFD struct {
+	values map[uint16][]uint32
+	make   string
+	next   uint32
+}
+
+// area returns the pixel count of the IFD's image.
+func (ifd tiffIFD) area() int {
+	return ifd.value(tiffTagImageWidth, 0) * ifd.value(tiffTagImageLength, 0)
+}
+
+// value returns the first value of tag, or def when it is absent.
+func (ifd tiffIFD) value(tag uint16, def int) int {
+	if v := ifd.values[tag]; len(v) > 0 {
+		return int(v[0])
+	}
+	return def
+}
+
+// ExtractTIFF extracts metadata from a TIFF file.
+func ExtractTIFF(r io.ReadSeeker) (*Result, error) {
+	return extractTIFF(r, Options{})
+}
+
+func extractTIFF(r io.ReadSeeker, opts Options) (*Result, error) {
+	// Reset to beginning
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read TIFF header (8 bytes): byte order, magic 42, first IFD offset
+	header := make([]byte, 8)
+	if err := readFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read TIFF header: %w", err)
+	}
+	var byteOrder binary.ByteOrder
+	switch string(header[0:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: invalid TIFF byte order", ErrInvalidData)
+	}
+	if byteOrder.Uint16(header[2:4]) != 42 {
+		return nil, fmt.Errorf("%w: invalid TIFF magic number", ErrInvalidData)
+	}
+
+	// Canon CR2 marks its header right after the first IFD offset
+	marker := make([]byte, 3)
+	cr2 := readFull(r, marker) == nil && string(header[0:2]) == "II" && string(marker) == "CR\x02"
+
+	size := opts.InputSize
+	if size <= 0 {
+		if size, err = r.Seek(0, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	result := newResult()
+
+	// Walk the IFD chain; each IFD is one page. A visited set stops
+	// cycles, and the walk ends quietly at the first unreadable IFD.
+	var pages []TIFFPage
+	var ifds []tiffIFD
+	visited := make(map[uint32]bool)
+	for offset := byteOrder.Uint32(header[4:8]); offset != 0 && len(pages) < maxTIFFPages; {
+		if visited[offset] {
+			break
+		}
+		visited[offset] = true
+
+		ifd, err := readTIFFIFD(r, int64(offset), byteOrder)
+		if err != nil {
+			if len(pages) == 0 {
+				return nil, fmt.Errorf("failed to read TIFF IFD: %w", err)
+			}
+			break
+		}
+		ifds = append(ifds, ifd)
+		pages = append(pages, TIFFPage{
+			Width:         ifd.value(tiffTagImageWidth, 0),
+			Height:        ifd.value(tiffTagImageLength, 0),
+			BitsPerSample: ifd.value(tiffTagBitsPerSample, 1),
+		})
+		offset = ifd.next
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: TIFF has no IFD", ErrInvalidData)
+	}
+
+	// The first page describes the image. RAW files keep thumbnails and
+	// previews in their IFDs, so the largest full-resolution one is used.
+	main := ifds[0]
+	result.Format = tiffRawFormat(cr2, ifds[0])
+	if result.Format != "" {
+		images := tiffImages(r, ifds, byteOrder)
+		main = tiffMainImage(images)
+		if offset, length, ok := tiffPreview(images, size); ok {
+			result.Additional["PreviewOffset"] = offset
+			result.Additional["PreviewLength"] = length
+		}
+	}
+
+	result.Width = main.value(tiffTagImageWidth, 0)
+	result.Height = main.value(tiffTagImageLength, 0)
+	bits := main.value(tiffTagBitsPerSample, 1)
+	samples := main.value(tiffTagSamplesPerPixel, 1)
+	result.ColorDepth = bits * samples
+	result.ColorSpace = tiffColorSpace(main.value(tiffTagPhotometric, -1), samples, len(main.values[tiffTagExtraSamples]) > 0)
+	result.HasICCProfile = ifds[0].values[tiffTagICCProfile] != nil || main.values[tiffTagICCProfile] != nil
+
+	compression := main.value(tiffTagCompression, 1)
+	result.Additional["Compression"] = compression
+	if name, ok := tiffCompressions[compression]; ok {
+		result.Additional["CompressionName"] = name
+	}
+	result.Additional["ByteOrder"] = string(header[0:2])
+	result.Additional["BitsPerSample"] = bits
+	result.Additional["SamplesPerPixel"] = samples
+	if result.Format == "" {
+		// The IFDs of a RAW file are renditions of one photo, not pages
+		result.Additional["PageCount"] = len(pages)
+		result.Additional["Pages"] = pages
+	}
+
+	// IFD0 tags such as Make, Model and DateTime, plus the Exif and GPS
+	// directories, decode like an EXIF block
+	if !opts.SkipEXIF {
+		whole := size <= maxTIFFEXIFSize
+		if !whole {
+			size = maxTIFFEXIFSize
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return result, nil
+		}
+		data := make([]byte, size)
+		if err := readFull(r, data); err != nil {
+			return result, nil
+		}
+		// Offsets past a partial read are not errors
+		if opts.Strict && whole {
+			if err := validateTIFF(data); err != nil {
+				return result, err
+			}
+		}
+		exifOpts := opts
+		if !whole {
+			exifOpts.Logger = nil
+		}
+		if exif, err := parseTIFF(data, exifOpts); err == nil {
+			// Later pages are not a thumbnail directory
+			delete(exif, "IFD1")
+			result.EXIF = exif
+		}
+	}
+
+	return result, nil
+}
+
+// readTIFFIFD reads the IFD at offset, keeping the SHORT and LONG values
+// of the tags that describe the image.
+func readTIFFIFD(r io.ReadSeeker, offset int64, byteOrder binary.ByteOrder) (tiffIFD, error) {
+	ifd := tiffIFD{values: make(map[uint16][]uint32)}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return ifd, err
+	}
+	countBytes := make([]byte, 2)
+	if err := readFull(r, countBytes); err != nil {
+		return ifd, err
+	}
+	entries := make([]byte, 12*int(byteOrder.Uint16(countBytes))+4)
+	if err := readFull(r, entries); err != nil {
+		return ifd, err
+	}
+	ifd.next = byteOrder.Uint32(entries[len(entries)-4:])
+
+	for e := entries[:len(entries)-4]; len(e) >= 12; e = e[12:] {
+		tag := byteOrder.Uint16(e[0:2])
+		switch tag {
+		case tiffTagNewSubfileType, tiffTagImageWidth, tiffTagImageLength, tiffTagBitsPerSample,
+			tiffTagCompression, tiffTagPhotometric, tiffTagMake, tiffTagStripOffsets,
+			tiffTagSamplesPerPixel, tiffTagStripByteCounts, tiffTagSubIFDs, tiffTagExtraSamples,
+			tiffTagJPEGOffset, tiffTagJPEGLength, tiffTagICCProfile, tiffTagDNGVersion:
+		default:
+			continue
+		}
+
+		dataType := byteOrder.Uint16(e[2:4])
+		count := byteOrder.Uint32(e[4:8])
+		if tag == tiffTagICCProfile || tag == tiffTagDNGVersion {
+			// Only the presence of the tag is reported
+			ifd.values[tag] = []uint32{count}
+			continue
+		}
+		if tag == tiffTagMake && dataType == exifTypeASCII {
+			dataType = exifTypeByte
+		} else if tag == tiffTagSubIFDs && dataType == tiffTypeIFD {
+			dataType = exifTypeLong
+		}
+		if (dataType != exifTypeShort && dataType != exifTypeLong && tag != tiffTagMake) || count == 0 || count > 64 {
+			continue
+		}
+
+		size := getDataTypeSize(dataType)
+		raw := e[8:12]
+		if int(count)*size > 4 {
+			pos, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return ifd, err
+			}
+			raw = make([]byte, int(count)*size)
+			if _, err := r.Seek(int64(byteOrder.Uint32(e[8:12])), io.SeekStart); err != nil {
+				return ifd, err
+			}
+			if err := readFull(r, raw); err != nil {
+				continue
+			}
+			if _, err := r.Seek(pos, io.SeekStart); err != nil {
+				return ifd, err
+			}
+		}
+
+		if tag == tiffTagMake {
+			if dataType == exifTypeByte {
+				ifd.make = string(bytes.TrimRight(raw[:count], "\x00 "))
+			}
+			continue
+		}
+		values := make([]uint32, count)
+		for i := range values {
+			if dataType == exifTypeShort {
+				values[i] = uint32(byteOrder.Uint16(raw[i*2:]))
+			} else {
+				values[i] = byteOrder.Uint32(raw[i*4:])
+			}
+		}
+		ifd.values[tag] = values
+	}
+	return ifd, nil
+}
+
+// tiffRawFormat names the camera RAW format of a TIFF-based file from its
+// header and first IFD, or returns "" for a plain TIFF.
+func tiffRawFormat(cr2 bool, ifd0 tiffIFD) string {
+	if cr2 {
+		return "CR2"
+	}
+	if ifd0.values[tiffTagDNGVersion] != nil {
+		return "DNG"
+	}
+	// Nikon and Sony keep the sensor data in a SubIFD
+	if len(ifd0.values[tiffTagSubIFDs]) > 0 {
+		switch maker := strings.ToUpper(ifd0.make); {
+		case strings.HasPrefix(maker, "NIKON"):
+			return "NEF"
+		case strings.HasPrefix(maker, "SONY"):
+			return "ARW"
+		}
+	}
+	return ""
+}
+
+// tiffImages returns the IFD chain followed by the SubIFDs it points to.
+func tiffImages(r io.ReadSeeker, ifds []tiffIFD, byteOrder binary.ByteOrder) []tiffIFD {
+	images := append([]tiffIFD(nil), ifds...)
+	for _, ifd := range ifds {
+		subs := ifd.values[tiffTagSubIFDs]
+		if len(subs) > maxTIFFSubIFDs {
+			subs = subs[:maxTIFFSubIFDs]
+		}
+		for _, offset := range subs {
+			if sub, err := readTIFFIFD(r, int64(offset), byteOrder); err == nil {
+				images = append(images, sub)
+			}
+		}
+	}
+	return images
+}
+
+// tiffMainImage returns the largest full-resolution image, falling back to
+// the first IFD.
+func tiffMainImage(images []tiffIFD) tiffIFD {
+	main := images[0]
+	found := false
+	for _, ifd := range images {
+		// Bit 0 of NewSubfileType marks a reduced-resolution rendition
+		if ifd.value(tiffTagNewSubfileType, 0)&1 != 0 || ifd.area() == 0 {
+			continue
+		}
+		if !found || ifd.area() > main.area() {
+			main, found = ifd, true
+		}
+	}
+	return main
+}
+
+// tiffPreview locates the largest JPEG rendition that fits in the file: a
+// JPEGInterchangeFormat block, as in Nikon and Sony preview IFDs, or a
+// single JPEG-compressed strip, as in CR2 IFD0 and DNG preview IFDs.
+func tiffPreview(images []tiffIFD, size int64) (offset, length int, found bool) {
+	for _, ifd := range images {
+		off, n := ifd.value(tiffTagJPEGOffset, 0), ifd.value(tiffTagJPEGLength, 0)
+		if n == 0 {
+			strips, counts := ifd.values[tiffTagStripOffsets], ifd.values[tiffTagStripByteCounts]
+			compression := ifd.value(tiffTagCompression, 1)
+			photometric := ifd.value(tiffTagPhotometric, -1)
+			// Lossless JPEG sensor data is CFA or LinearRaw, and a CR2 raw
+			// IFD has no ImageWidth
+			if (compression != 6 && compression != 7) || photometric == 32803 || photometric == 34892 ||
+				ifd.value(tiffTagImageWidth, 0) == 0 || len(strips) != 1 || len(counts) != 1 {
+				continue
+			}
+			off, n = int(strips[0]), int(counts[0])
+		}
+		if n > length && int64(off)+int64(n) <= size {
+			offset, length, found = off, n, true
+		}
+	}
+	return offset, length, found
+}
+
+// tiffColorSpace maps a PhotometricInterpretation to a color space.
+func tiffColorSpace(photometric, samples int, extra bool) string {
+	switch photometric {
+	case 0, 1: // WhiteIsZero, BlackIsZero
+		if extra && samples >= 2 {
+			return "GrayscaleAlpha"
+		}
+		return "Grayscale"
+	case 2, 6: // RGB, YCbCr
+		if extra && samples >= 4 {
+			return "RGBA"
+		}
+		return "RGB"
+	case 3: // Palette
+		return "Indexed"
+	case 5: // Separated
+		return "CMYK"
+	case 8, 9, 10: // CIELab, ICCLab, ITULab
+		return "Lab"
+	case 32803, 34892: // CFA, LinearRaw: sensor data that develops to RGB
+		return "RGB"
+	}
+	return "Unknown"
+}
@@ -0,0 +1,34 @@
+package formats
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Undefined is the raw value of an EXIF UNDEFINED tag that has no known
+// text decoding. It encodes to JSON as {"size": n, "hex": "..."} instead of
+// the base64 string a bare []byte would produce.
+type Undefined []byte
+
+// Size returns the length of the value in bytes.
+func (u Undefined) Size() int {
+	return len(u)
+}
+
+// Hex returns the value as lowercase hexadecimal.
+func (u Undefined) Hex() string {
+	return hex.EncodeToString(u)
+}
+
+// String returns the value as hexadecimal.
+func (u Undefined) String() string {
+	return u.Hex()
+}
+
+// MarshalJSON encodes the value as an object with its size and hex bytes.
+func (u Undefined) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Size int    `json:"size"`
+		Hex  string `json:"hex"`
+	}{u.Size(), u.Hex()})
+}
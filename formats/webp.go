@@ -1,12 +1,23 @@
 package formats
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"image/color"
 	"io"
 )
 
+// maxWebPMetadataChunk bounds the size of an EXIF, XMP or ICCP chunk that is
+// read into memory.
+const maxWebPMetadataChunk = 16 << 20
+
 // ExtractWebP extracts metadata from a WebP file.
 func ExtractWebP(r io.ReadSeeker) (*Result, error) {
+	return extractWebP(r, Options{})
+}
+
+func extractWebP(r io.ReadSeeker, opts Options) (*Result, error) {
 	// Reset to beginning
 	_, err := r.Seek(0, io.SeekStart)
 	if err != nil {
@@ -15,7 +26,7 @@ func ExtractWebP(r io.ReadSeeker) (*Result, error) {
 
 	// Read RIFF header (12 bytes)
 	header := make([]byte, 12)
-	_, err = r.Read(header)
+	err = readFull(r, header)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read WebP header: %w", err)
 	}
@@ -30,51 +41,138 @@ func ExtractWebP(r io.ReadSeeker) (*Result, error) {
 		return nil, fmt.Errorf("%w: missing WEBP signature", ErrInvalidData)
 	}
 
-	// Read chunk type (4 bytes)
-	chunkType := make([]byte, 4)
-	_, err = r.Read(chunkType)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read WebP chunk type: %w", err)
+	// The RIFF size covers everything after the first 8 bytes; an input
+	// shorter than that, or a chunk running past its end, was cut short
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(header[4:8]))
+	if opts, err = opts.withInputSize(r); err != nil {
+		return nil, err
 	}
-	chunkTypeStr := string(chunkType)
+	inputSize := opts.InputSize
+	chunkEnd := int64(12)
+	var parseErr error
 
 	hasAnimation := false
 	hasAlpha := false
+	extended := false
 	result := newResult()
 
-	// Handle different WebP formats
-	switch chunkTypeStr {
-	case "VP8 ":
-		// Simple lossy format
-		err = parseVP8(r, result)
-		if err != nil {
-			return nil, err
-		}
+	frameDurations := []int{}
+	duration := 0
 
-	case "VP8L":
-		// Lossless format
-		err = parseVP8L(r, result)
+	// Walk the chunks; each payload is padded to an even length. A
+	// metadata chunk cut short ends the walk as a truncated file.
+chunks:
+	for first := true; ; first = false {
+		chunkType, size, err := readRIFFChunkHeader(r)
 		if err != nil {
-			return nil, err
+			if first {
+				return nil, fmt.Errorf("failed to read WebP chunk header: %w", err)
+			}
+			break
 		}
-		hasAlpha = true // VP8L supports alpha
-
-	case "VP8X":
-		// Extended format (supports animation, alpha, etc.)
-		err = parseVP8X(r, result)
+		start, err := r.Seek(0, io.SeekCurrent)
 		if err != nil {
 			return nil, err
 		}
-		// Extract animation and alpha from additional metadata
-		if anim, ok := result.Additional["Animation"].(bool); ok {
-			hasAnimation = anim
+		chunkEnd = start + size
+
+		switch chunkType {
+		case "VP8 ":
+			// Simple lossy format; an extended file's canvas size takes precedence
+			if !extended {
+				if err := parseVP8(r, size, result); err != nil {
+					return nil, err
+				}
+			}
+
+		case "VP8L":
+			// Lossless format
+			if !extended {
+				alpha, err := parseVP8L(r, size, result)
+				if err != nil {
+					return nil, err
+				}
+				hasAlpha = alpha
+			}
+
+		case "VP8X":
+			// Extended format (supports animation, alpha, etc.)
+			err = parseVP8X(r, size, result)
+			if err != nil {
+				return nil, err
+			}
+			extended = true
+			// Extract animation and alpha from additional metadata
+			if anim, ok := result.Additional["Animation"].(bool); ok {
+				hasAnimation = anim
+			}
+			if alpha, ok := result.Additional["Alpha"].(bool); ok {
+				hasAlpha = alpha
+			}
+
+		case "ANIM":
+			// Background color (BGRA) and loop count
+			if size >= 6 {
+				anim := make([]byte, 6)
+				if err := readFull(r, anim); err != nil {
+					break chunks
+				}
+				result.Additional["BackgroundColor"] = color.NRGBA{R: anim[2], G: anim[1], B: anim[0], A: anim[3]}
+				result.Additional["LoopCount"] = int(binary.LittleEndian.Uint16(anim[4:6]))
+			}
+
+		case "ANMF":
+			// Frame X, Y, width and height precede the 24-bit duration in ms
+			if size >= 15 {
+				frame := make([]byte, 15)
+				if err := readFull(r, frame); err != nil {
+					break chunks
+				}
+				d := int(frame[12]) | int(frame[13])<<8 | int(frame[14])<<16
+				frameDurations = append(frameDurations, d)
+				duration += d
+			}
+
+		case "EXIF", "XMP ", "ICCP":
+			if chunkType == "EXIF" && opts.SkipEXIF {
+				break
+			}
+			// The size comes from the file; check it before allocating
+			if err := opts.checkLength(r, size, "WebP "+chunkType+" chunk"); err != nil {
+				parseErr = err
+				break chunks
+			}
+			if size > maxWebPMetadataChunk {
+				opts.log("skipped WebP chunk", "type", chunkType, "error", "chunk too large", "size", size)
+				break
+			}
+			payload := make([]byte, size)
+			if err := readFull(r, payload); err != nil {
+				break chunks
+			}
+			parseWebPMetadata(chunkType, payload, result, opts)
+
+		default:
+			if first {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, chunkType)
+			}
+			// Alpha data is known but not inspected
+			if chunkType != "ALPH" {
+				opts.log("unknown WebP chunk", "type", chunkType)
+			}
 		}
-		if alpha, ok := result.Additional["Alpha"].(bool); ok {
-			hasAlpha = alpha
+
+		next := start + size + size&1
+		if _, err := r.Seek(next, io.SeekStart); err != nil {
+			return nil, err
 		}
+	}
 
-	default:
-		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, chunkTypeStr)
+	if inputSize < riffEnd || inputSize < chunkEnd {
+		result.Additional["Truncated"] = true
+		if opts.Strict && parseErr == nil {
+			parseErr = fmt.Errorf("%w: WebP is shorter than its RIFF size", ErrInvalidData)
+		}
 	}
 
 	result.ColorSpace = "RGB"
@@ -83,96 +181,144 @@ func ExtractWebP(r io.ReadSeeker) (*Result, error) {
 	}
 	result.Additional["HasAnimation"] = hasAnimation
 	result.Additional["HasAlpha"] = hasAlpha
+	if hasAnimation {
+		result.Additional["FrameCount"] = len(frameDurations)
+		result.Additional["FrameDurations"] = frameDurations
+		result.Additional["Duration"] = duration
+	}
+
+	return result, parseErr
+}
 
-	return result, nil
+// parseWebPMetadata decodes an EXIF, XMP or ICCP chunk payload.
+func parseWebPMetadata(chunkType string, payload []byte, res *Result, opts Options) {
+	switch chunkType {
+	case "EXIF":
+		// Some writers keep the JPEG APP1 identifier
+		payload = bytes.TrimPrefix(payload, []byte("Exif\x00\x00"))
+		if opts.DeferEXIF {
+			res.RawEXIF = payload
+			return
+		}
+		exifData, err := parseTIFF(payload, opts)
+		if err != nil {
+			opts.log("skipped WebP chunk", "type", "EXIF", "error", err)
+			return
+		}
+		for k, v := range exifData {
+			res.EXIF[k] = v
+		}
+		res.RawEXIF = payload
+
+	case "XMP ":
+		if props, err := parseXMP(payload); err == nil {
+			res.Additional["XMP"] = props
+		} else {
+			opts.log("skipped WebP chunk", "type", "XMP ", "error", err)
+		}
+
+	case "ICCP":
+		res.HasICCProfile = true
+		if icc, ok := parseICCProfile(payload); ok {
+			res.Additional["ICCProfile"] = icc
+		} else {
+			opts.log("skipped WebP chunk", "type", "ICCP", "error", "invalid profile")
+		}
+	}
+}
+
+// readRIFFChunkHeader reads a chunk's FourCC and little-endian payload size.
+func readRIFFChunkHeader(r io.Reader) (string, int64, error) {
+	header := make([]byte, 8)
+	if err := readFull(r, header); err != nil {
+		return "", 0, err
+	}
+	return string(header[0:4]), int64(binary.LittleEndian.Uint32(header[4:8])), nil
 }
 
 // parseVP8 parses a simple VP8 (lossy) WebP chunk
-func parseVP8(r io.ReadSeeker, res *Result) error {
-	// Read chunk size (already read, but we need to skip it)
-	// VP8 format: 3 bytes key frame header, then dimensions
+func parseVP8(r io.Reader, size int64, res *Result) error {
+	// VP8 format: 3-byte frame tag, 3-byte start code, then dimensions
+	if size < 10 {
+		return fmt.Errorf("%w: VP8 chunk too short", ErrInvalidData)
+	}
 	keyFrame := make([]byte, 10)
-	_, err := r.Read(keyFrame)
+	err := readFull(r, keyFrame)
 	if err != nil {
 		return fmt.Errorf("failed to read VP8 key frame: %w", err)
 	}
 
-	// Verify key frame signature
-	if keyFrame[0] != 0x9D || keyFrame[1] != 0x01 || keyFrame[2] != 0x2A {
+	// Verify key frame start code
+	if keyFrame[3] != 0x9D || keyFrame[4] != 0x01 || keyFrame[5] != 0x2A {
 		return fmt.Errorf("%w: invalid VP8 key frame", ErrInvalidData)
 	}
 
-	// Dimensions are in the key frame (14-bit values, little-endian)
-	width := int(keyFrame[6]) | (int(keyFrame[7]&0x3F) << 8)
-	height := int(keyFrame[8]) | (int(keyFrame[9]&0x3F) << 8)
-
-	res.Width = width + 1
-	res.Height = height + 1
+	// Dimensions are 14-bit little-endian values; the top two bits are scaling
+	res.Width = int(binary.LittleEndian.Uint16(keyFrame[6:8]) & 0x3FFF)
+	res.Height = int(binary.LittleEndian.Uint16(keyFrame[8:10]) & 0x3FFF)
 	res.ColorDepth = 24 // VP8 is always 24-bit RGB
 
 	return nil
 }
 
-// parseVP8L parses a VP8L (lossless) WebP chunk
-func parseVP8L(r io.ReadSeeker, res *Result) error {
+// parseVP8L parses a VP8L (lossless) WebP chunk and reports whether the
+// encoder marked the alpha channel as used.
+func parseVP8L(r io.Reader, size int64, res *Result) (bool, error) {
+	if size < 5 {
+		return false, fmt.Errorf("%w: VP8L chunk too short", ErrInvalidData)
+	}
 	// Read VP8L header (5 bytes)
 	header := make([]byte, 5)
-	_, err := r.Read(header)
+	err := readFull(r, header)
 	if err != nil {
-		return fmt.Errorf("failed to read VP8L header: %w", err)
+		return false, fmt.Errorf("failed to read VP8L header: %w", err)
 	}
 
 	// Verify VP8L signature
 	if header[0] != 0x2F {
-		return fmt.Errorf("%w: invalid VP8L signature", ErrInvalidData)
+		return false, fmt.Errorf("%w: invalid VP8L signature", ErrInvalidData)
 	}
 
-	// Dimensions are encoded in the header (14 bits each)
-	width := int(header[1]) | (int(header[2]&0x3F) << 8)
-	height := int(header[2]>>6) | (int(header[3]&0xF) << 2) | (int(header[4]&0x3F) << 10)
+	// 14-bit width-1, 14-bit height-1, alpha_is_used, 3-bit version
+	bits := binary.LittleEndian.Uint32(header[1:5])
+	res.Width = int(bits&0x3FFF) + 1
+	res.Height = int((bits>>14)&0x3FFF) + 1
 
-	res.Width = width + 1
-	res.Height = height + 1
-	res.ColorDepth = 32 // VP8L supports alpha, so 32-bit RGBA
+	alpha := bits&(1<<28) != 0
+	res.ColorDepth = 24
+	if alpha {
+		res.ColorDepth = 32
+	}
 
-	return nil
+	return alpha, nil
 }
 
 // parseVP8X parses a VP8X (extended) WebP chunk
-func parseVP8X(r io.ReadSeeker, res *Result) error {
-	// Read VP8X header (10 bytes)
+func parseVP8X(r io.Reader, size int64, res *Result) error {
+	if size < 10 {
+		return fmt.Errorf("%w: VP8X chunk too short", ErrInvalidData)
+	}
+	// Flags, 3 reserved bytes, then 24-bit canvas width-1 and height-1
 	header := make([]byte, 10)
-	_, err := r.Read(header)
+	err := readFull(r, header)
 	if err != nil {
 		return fmt.Errorf("failed to read VP8X header: %w", err)
 	}
 
-	// Verify VP8X signature
-	if string(header[0:4]) != "VP8X" {
-		return fmt.Errorf("%w: invalid VP8X signature", ErrInvalidData)
-	}
-
-	// Flags (1 byte)
-	flags := header[4]
-
-	// Dimensions (3 bytes width, 3 bytes height, stored as 24-bit little-endian)
-	// Width: bytes 6-8 (24 bits)
-	width := int(header[6]) | (int(header[7]) << 8) | (int(header[8]&0x0F) << 16)
-	// Height: upper 4 bits of byte 8, bytes 9-10 (20 bits total, but we use 24)
-	height := int(header[8]>>4) | (int(header[9]) << 4)
-
-	res.Width = width + 1
-	res.Height = height + 1
+	flags := header[0]
+	res.Width = (int(header[4]) | int(header[5])<<8 | int(header[6])<<16) + 1
+	res.Height = (int(header[7]) | int(header[8])<<8 | int(header[9])<<16) + 1
 	res.ColorDepth = 24
 	if (flags & 0x10) != 0 {
 		res.ColorDepth = 32 // Has alpha
 	}
 
-	res.Additional["Reserved"] = (flags & 0xE0) >> 5
+	res.Additional["Reserved"] = flags & 0xC1
 	res.Additional["ICC"] = (flags & 0x20) != 0
 	res.Additional["Alpha"] = (flags & 0x10) != 0
 	res.Additional["EXIF"] = (flags & 0x08) != 0
-	res.Additional["XMP"] = (flags & 0x04) != 0
+	// XMP holds the parsed packet, as it does for JPEG
+	res.Additional["HasXMP"] = (flags & 0x04) != 0
 	res.Additional["Animation"] = (flags & 0x02) != 0
 
 	// Check for ICC profile
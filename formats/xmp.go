@@ -0,0 +1,239 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xmpAPP1Prefix identifies a standard XMP packet in a JPEG APP1 segment.
+const xmpAPP1Prefix = "http://ns.adobe.com/xap/1.0/\x00"
+
+// xmpExtensionPrefix identifies an Extended XMP chunk in a JPEG APP1 segment.
+// It is followed by a 32-byte GUID, the full length and the chunk's offset.
+const xmpExtensionPrefix = "http://ns.adobe.com/xmp/extension/\x00"
+
+// XML namespaces of the XMP properties that are extracted.
+const (
+	xmpNSRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpNSDC        = "http://purl.org/dc/elements/1.1/"
+	xmpNSXMP       = "http://ns.adobe.com/xap/1.0/"
+	xmpNSPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	xmpNSNote      = "http://ns.adobe.com/xmp/note/"
+)
+
+// xmpPrefixes maps namespace URIs to the conventional prefixes used as keys.
+var xmpPrefixes = map[string]string{
+	xmpNSDC:        "dc",
+	xmpNSXMP:       "xmp",
+	xmpNSPhotoshop: "photoshop",
+	xmpNSNote:      "xmpNote",
+}
+
+// xmpProperties lists the properties reported, keyed by prefixed name.
+var xmpProperties = map[string]bool{
+	"dc:creator":            true,
+	"dc:title":              true,
+	"dc:description":        true,
+	"xmp:Rating":            true,
+	"photoshop:DateCreated": true,
+	// GUID of the Extended XMP belonging to this packet
+	"xmpNote:HasExtendedXMP": true,
+}
+
+// parseXMP extracts the known properties from an XMP packet. Properties may
+// be written as attributes of rdf:Description or as child elements; arrays
+// (rdf:Seq, rdf:Bag) become []string and language alternatives (rdf:Alt)
+// resolve to the x-default entry.
+func parseXMP(packet []byte) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	decoder.Strict = false
+	props := make(map[string]interface{})
+
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			if len(props) > 0 {
+				return props, nil
+			}
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if start.Name.Space == xmpNSRDF && start.Name.Local == "Description" {
+			for _, attr := range start.Attr {
+				if key, ok := xmpKey(attr.Name); ok {
+					props[key] = xmpValue(key, attr.Value)
+				}
+			}
+			continue
+		}
+
+		if key, ok := xmpKey(start.Name); ok {
+			if value, ok := readXMPProperty(decoder); ok {
+				if s, isString := value.(string); isString {
+					props[key] = xmpValue(key, s)
+				} else {
+					props[key] = value
+				}
+			}
+		}
+	}
+}
+
+// xmpKey returns the prefixed name of a reported property.
+func xmpKey(name xml.Name) (string, bool) {
+	prefix, ok := xmpPrefixes[name.Space]
+	if !ok {
+		return "", false
+	}
+	key := prefix + ":" + name.Local
+	return key, xmpProperties[key]
+}
+
+// xmpValue converts numeric properties from their text form.
+func xmpValue(key, value string) interface{} {
+	if key == "xmp:Rating" {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return n
+		}
+	}
+	return value
+}
+
+// readXMPProperty consumes a property element up to its end tag and returns
+// its value: the text of a simple property, the items of an rdf:Seq or
+// rdf:Bag, or the x-default (else first) item of an rdf:Alt.
+func readXMPProperty(decoder *xml.Decoder) (interface{}, bool) {
+	var text strings.Builder
+	var items []string
+	var item strings.Builder
+	container := ""
+	alt := ""
+	inItem := false
+	itemLang := ""
+
+	for depth := 1; depth > 0; {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space != xmpNSRDF {
+				continue
+			}
+			switch t.Name.Local {
+			case "Seq", "Bag", "Alt":
+				container = t.Name.Local
+			case "li":
+				inItem = true
+				item.Reset()
+				itemLang = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" {
+						itemLang = attr.Value
+					}
+				}
+			}
+
+		case xml.EndElement:
+			depth--
+			if inItem && t.Name.Space == xmpNSRDF && t.Name.Local == "li" {
+				inItem = false
+				value := strings.TrimSpace(item.String())
+				items = append(items, value)
+				if itemLang == "x-default" {
+					alt = value
+				}
+			}
+
+		case xml.CharData:
+			if inItem {
+				item.Write(t)
+			} else if container == "" {
+				text.Write(t)
+			}
+		}
+	}
+
+	switch container {
+	case "Alt":
+		if alt == "" && len(items) > 0 {
+			alt = items[0]
+		}
+		return alt, true
+	case "Seq", "Bag":
+		return items, true
+	default:
+		return strings.TrimSpace(text.String()), true
+	}
+}
+
+// xmpExtensions collects Extended XMP chunks keyed by GUID.
+type xmpExtensions map[string]*xmpExtension
+
+type xmpExtension struct {
+	length uint32
+	chunks []xmpChunk
+}
+
+type xmpChunk struct {
+	offset uint32
+	data   []byte
+}
+
+// add records the chunk in an APP1 payload that follows xmpExtensionPrefix.
+func (e xmpExtensions) add(payload []byte) {
+	if len(payload) < 40 {
+		return
+	}
+	guid := string(payload[:32])
+	ext, ok := e[guid]
+	if !ok {
+		ext = &xmpExtension{length: binary.BigEndian.Uint32(payload[32:36])}
+		e[guid] = ext
+	}
+	ext.chunks = append(ext.chunks, xmpChunk{
+		offset: binary.BigEndian.Uint32(payload[36:40]),
+		data:   payload[40:],
+	})
+}
+
+// assemble stitches the chunks for guid together in offset order. It fails
+// when chunks are missing or the result does not match the declared length.
+func (e xmpExtensions) assemble(guid string) ([]byte, bool) {
+	ext, ok := e[guid]
+	if !ok {
+		return nil, false
+	}
+
+	sort.Slice(ext.chunks, func(i, j int) bool {
+		return ext.chunks[i].offset < ext.chunks[j].offset
+	})
+
+	var merged []byte
+	for _, chunk := range ext.chunks {
+		switch {
+		case int64(chunk.offset) < int64(len(merged)):
+			continue // duplicate chunk
+		case int64(chunk.offset) > int64(len(merged)):
+			return nil, false // gap
+		}
+		merged = append(merged, chunk.data...)
+	}
+
+	if int64(len(merged)) != int64(ext.length) {
+		return nil, false
+	}
+	return merged, true
+}
@@ -0,0 +1,48 @@
+package imx
+
+import (
+	"math"
+	"strconv"
+)
+
+// GeoURI returns an RFC 5870 geo URI for the EXIF GPS position, such as
+// "geo:46.960133,-7.439600", with the altitude in meters appended when it
+// is known: "geo:46.960133,-7.439600,540.5". It reports false when the
+// image has no valid GPS coordinates.
+func (md *ImageMetadata) GeoURI() (string, bool) {
+	lat, lon, ok := md.gpsPosition()
+	if !ok {
+		return "", false
+	}
+	uri := "geo:" + formatCoordinate(lat) + "," + formatCoordinate(lon)
+	if alt, ok := md.EXIFFloat("GPSAltitude"); ok {
+		uri += "," + strconv.FormatFloat(math.Round(alt*100)/100, 'f', -1, 64)
+	}
+	return uri, true
+}
+
+// GoogleMapsURL returns a Google Maps link to the EXIF GPS position, or
+// false when the image has no valid GPS coordinates.
+func (md *ImageMetadata) GoogleMapsURL() (string, bool) {
+	lat, lon, ok := md.gpsPosition()
+	if !ok {
+		return "", false
+	}
+	return "https://maps.google.com/?q=" + formatCoordinate(lat) + "," + formatCoordinate(lon), true
+}
+
+// gpsPosition returns the decimal GPS coordinates, rejecting values outside
+// the valid latitude and longitude ranges.
+func (md *ImageMetadata) gpsPosition() (lat, lon float64, ok bool) {
+	lat, okLat := md.EXIFFloat("GPSLatitude")
+	lon, okLon := md.EXIFFloat("GPSLongitude")
+	if !okLat || !okLon || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// formatCoordinate renders degrees with six decimals, about 0.1 m.
+func formatCoordinate(degrees float64) string {
+	return strconv.FormatFloat(degrees, 'f', 6, 64)
+}
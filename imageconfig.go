@@ -0,0 +1,119 @@
+package imx
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"sync"
+)
+
+// imageConfigFormats are the magic strings registered with the image
+// package for formats the standard library has no decoder for. A "?"
+// matches any byte.
+var imageConfigFormats = []struct {
+	format Format
+	magic  string
+}{
+	{FormatWebP, "RIFF????WEBP"},
+	{FormatBMP, "BM"},
+	{FormatAVIF, "????ftypavif"},
+	{FormatAVIF, "????ftypavis"},
+	{FormatJXL, "\xff\x0a"},
+	{FormatJXL, "\x00\x00\x00\x0cJXL \x0d\x0a\x87\x0a"},
+	{FormatICO, "\x00\x00\x01\x00"},
+	{FormatPSD, "8BPS"},
+	{FormatHDR, "#?RADIANCE"},
+	{FormatHDR, "#?RGBE"},
+	{FormatDDS, "DDS "},
+	{FormatJP2, "\x00\x00\x00\x0cjP  \x0d\x0a\x87\x0a"},
+	{FormatJP2, "\xff\x4f\xff\x51"},
+	{FormatQOI, "qoif"},
+}
+
+var registerImageConfigOnce sync.Once
+
+// RegisterImageConfig registers the formats imx understands but the
+// standard library does not (WebP, BMP, AVIF, JXL, ...) with the image
+// package, so image.DecodeConfig reports their dimensions and color model.
+// Decoding pixels with image.Decode fails for these formats.
+//
+// Registration is global and happens once; later calls do nothing.
+func RegisterImageConfig() {
+	registerImageConfigOnce.Do(func() {
+		for _, f := range imageConfigFormats {
+			format := f.format
+			image.RegisterFormat(strings.ToLower(string(format)), f.magic,
+				func(io.Reader) (image.Image, error) {
+					return nil, errors.New("imx: decoding " + string(format) + " pixels is not supported")
+				},
+				func(r io.Reader) (image.Config, error) {
+					return decodeImageConfig(r)
+				})
+		}
+	})
+}
+
+// decodeImageConfig extracts the metadata behind an image.Config. Only a
+// prefix of r is read, as MetadataFromURL fetches one: the headers of the
+// registered formats sit well within it, and the pixel data is not needed.
+func decodeImageConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(io.LimitReader(r, defaultFetchLimit))
+	if err != nil {
+		return image.Config{}, err
+	}
+	md, err := MetadataFromBytes(data, WithoutEXIF())
+	// A parser cut short by the prefix still found the dimensions
+	if err != nil && !(errors.Is(err, ErrPartial) && md.Width > 0 && md.Height > 0) {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: md.ColorModel(),
+		Width:      md.Width,
+		Height:     md.Height,
+	}, nil
+}
+
+// ColorModel maps ColorSpace and ColorDepth to the closest color.Model.
+// Indexed images with a palette in Additional["Palette"] (PNG and GIF) map
+// to a color.Palette, with the PNG tRNS alpha applied; other indexed images
+// map to color.RGBAModel.
+func (md *ImageMetadata) ColorModel() color.Model {
+	switch md.ColorSpace {
+	case ColorSpaceIndexed:
+		if entries, ok := md.Additional["Palette"].([][3]uint8); ok && len(entries) > 0 {
+			alpha, _ := md.Additional["PaletteAlpha"].([]byte)
+			palette := make(color.Palette, len(entries))
+			for i, e := range entries {
+				c := color.NRGBA{R: e[0], G: e[1], B: e[2], A: 0xFF}
+				if i < len(alpha) {
+					c.A = alpha[i]
+				}
+				palette[i] = c
+			}
+			return palette
+		}
+	case ColorSpaceGrayscale:
+		if md.ColorDepth > 8 {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case ColorSpaceGrayscaleAlpha:
+		if md.ColorDepth > 16 {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	case ColorSpaceRGBA:
+		if md.ColorDepth > 32 {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	case ColorSpaceCMYK, ColorSpaceYCCK:
+		return color.CMYKModel
+	}
+	if md.ColorDepth > 32 {
+		return color.RGBA64Model
+	}
+	return color.RGBAModel
+}
@@ -2,10 +2,15 @@ package imx
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"imx/formats"
@@ -15,6 +20,10 @@ var defaultHTTPClient = &http.Client{
 	Timeout: 15 * time.Second,
 }
 
+// defaultFetchLimit is how much of a remote image MetadataFromURL downloads
+// unless WithFetchLimit says otherwise.
+const defaultFetchLimit = 4 << 20
+
 // Metadata reads an image file and extracts comprehensive metadata including
 // format, dimensions, color information, EXIF data, and ICC profiles.
 //
@@ -28,12 +37,21 @@ var defaultHTTPClient = &http.Client{
 //		log.Fatal(err)
 //	}
 //	fmt.Printf("Format: %s, Dimensions: %dx%d\n", md.Format, md.Width, md.Height)
-func Metadata(filepath string) (*ImageMetadata, error) {
-	return MetadataFromFile(filepath)
+//
+// Options such as WithMaxBytes or WithoutEXIF tune the extraction; every
+// entry point accepts them.
+func Metadata(filepath string, opts ...Option) (*ImageMetadata, error) {
+	return MetadataFromFile(filepath, opts...)
 }
 
 // MetadataFromFile extracts metadata from an image on disk.
-func MetadataFromFile(path string) (*ImageMetadata, error) {
+func MetadataFromFile(path string, opts ...Option) (*ImageMetadata, error) {
+	return MetadataFromFileContext(context.Background(), path, opts...)
+}
+
+// MetadataFromFileContext is like MetadataFromFile but gives up with
+// ctx.Err() once ctx is done.
+func MetadataFromFileContext(ctx context.Context, path string, opts ...Option) (*ImageMetadata, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
@@ -45,90 +63,360 @@ func MetadataFromFile(path string) (*ImageMetadata, error) {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	return metadataFromSeeker(file, info.Size())
+	return metadataFromSeeker(ctx, file, info.Size(), newOptions(opts))
+}
+
+// MetadataFromFS extracts metadata from a file in fsys, such as an embed.FS
+// or a zip archive. Files that cannot seek are read through io.ReaderAt when
+// they implement it and are buffered in memory otherwise.
+func MetadataFromFS(fsys fs.FS, name string, opts ...Option) (*ImageMetadata, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	o := newOptions(opts)
+	switch f := file.(type) {
+	case io.ReadSeeker:
+		return metadataFromSeeker(context.Background(), f, info.Size(), o)
+	case io.ReaderAt:
+		return metadataFromSeeker(context.Background(), io.NewSectionReader(f, 0, info.Size()), info.Size(), o)
+	}
+
+	data, err := readAllLimited(file, o.maxBytes)
+	if err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	return metadataFromSeeker(context.Background(), bytes.NewReader(data), int64(len(data)), o)
 }
 
 // MetadataFromBytes extracts metadata from an in-memory byte slice.
-func MetadataFromBytes(data []byte) (*ImageMetadata, error) {
+func MetadataFromBytes(data []byte, opts ...Option) (*ImageMetadata, error) {
 	reader := bytes.NewReader(data)
-	return metadataFromSeeker(reader, int64(len(data)))
+	return metadataFromSeeker(context.Background(), reader, int64(len(data)), newOptions(opts))
 }
 
 // MetadataFromReader reads all data from r into memory and extracts metadata.
-func MetadataFromReader(r io.Reader) (*ImageMetadata, error) {
-	data, err := io.ReadAll(r)
+func MetadataFromReader(r io.Reader, opts ...Option) (*ImageMetadata, error) {
+	return MetadataFromReaderContext(context.Background(), r, opts...)
+}
+
+// MetadataFromReaderContext is like MetadataFromReader but stops reading r
+// and gives up with ctx.Err() once ctx is done.
+func MetadataFromReaderContext(ctx context.Context, r io.Reader, opts ...Option) (*ImageMetadata, error) {
+	o := newOptions(opts)
+	data, err := readAllLimited(&contextReader{ctx: ctx, r: r}, o.maxBytes)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
 	}
-	return MetadataFromBytes(data)
+	return metadataFromSeeker(ctx, bytes.NewReader(data), int64(len(data)), o)
 }
 
 // MetadataFromReaderAt extracts metadata from any io.ReaderAt with a known size.
-func MetadataFromReaderAt(r io.ReaderAt, size int64) (*ImageMetadata, error) {
+func MetadataFromReaderAt(r io.ReaderAt, size int64, opts ...Option) (*ImageMetadata, error) {
 	section := io.NewSectionReader(r, 0, size)
-	return metadataFromSeeker(section, size)
+	return metadataFromSeeker(context.Background(), section, size, newOptions(opts))
 }
 
 // MetadataFromURL downloads an image from a URL and extracts metadata.
-func MetadataFromURL(url string) (*ImageMetadata, error) {
-	resp, err := defaultHTTPClient.Get(url)
+func MetadataFromURL(url string, opts ...Option) (*ImageMetadata, error) {
+	return MetadataFromURLContext(context.Background(), url, opts...)
+}
+
+// MetadataFromURLContext is like MetadataFromURL but binds the request to
+// ctx, so cancelling ctx aborts the download. The returned error wraps
+// both ErrFetchFailed and the context error.
+//
+// Only the first WithFetchLimit bytes (4 MiB by default) are downloaded,
+// using a Range request when the server supports one. If the metadata
+// extends past that prefix the error wraps ErrFetchLimit.
+//
+// data: URIs, base64 or percent-encoded, are decoded in place rather than
+// fetched; WithMaxBytes limits their decoded size.
+func MetadataFromURLContext(ctx context.Context, url string, opts ...Option) (*ImageMetadata, error) {
+	o := newOptions(opts)
+	if isDataURI(url) {
+		data, err := decodeDataURI(url, o.maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		return metadataFromSeeker(ctx, bytes.NewReader(data), int64(len(data)), o)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
 	}
+	if o.fetchLimit > 0 {
+		// One byte past the limit tells a capped body from a complete one
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", o.fetchLimit))
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFetchFailed, ctxErr)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("%w: unexpected status code %d from %s", ErrFetchFailed, resp.StatusCode, url)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		total = contentRangeSize(resp.Header.Get("Content-Range"))
+	}
+	if o.maxBytes > 0 && total > o.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrTooLarge, total, o.maxBytes)
+	}
+
+	limit := o.fetchLimit
+	if o.maxBytes > 0 && (limit <= 0 || o.maxBytes < limit) {
+		limit = o.maxBytes
+	}
+	data, err := readAllLimited(resp.Body, limit)
+	truncated := false
+	if errors.Is(err, ErrTooLarge) && limit != o.maxBytes {
+		// Past the fetch limit only the prefix is parsed
+		data, err = data[:limit], nil
+		truncated = true
+	}
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFetchFailed, ctxErr)
+		}
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	if total > int64(len(data)) {
+		truncated = true
+	}
+
+	md, err := metadataFromSeeker(ctx, bytes.NewReader(data), int64(len(data)), o)
+	if truncated && ctx.Err() == nil {
+		if err != nil {
+			return md, fmt.Errorf("%w: %d bytes fetched: %w", ErrFetchLimit, len(data), err)
+		}
+		if md.Width <= 0 || md.Height <= 0 {
+			return nil, fmt.Errorf("%w: dimensions not found in the first %d bytes", ErrFetchLimit, len(data))
+		}
+		if total > 0 {
+			md.FileSize = total
+		}
+		// Only the prefix was parsed, so the end of the file is unknown
+		delete(md.Additional, "Truncated")
+	}
+	return md, err
+}
+
+// contentRangeSize returns the complete length from a Content-Range header
+// such as "bytes 0-1023/4096", or -1 when it is unknown.
+func contentRangeSize(header string) int64 {
+	slash := strings.LastIndexByte(header, '/')
+	if slash < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(header[slash+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
 
-	return MetadataFromBytes(data)
+// readAllLimited reads r to the end, failing with ErrTooLarge as soon as
+// more than max bytes arrive; the bytes read so far are returned with the
+// error. A max of zero or less means no limit.
+func readAllLimited(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return data, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, max)
+	}
+	return data, nil
 }
 
-func metadataFromSeeker(rs io.ReadSeeker, size int64) (*ImageMetadata, error) {
-	magicBytes := make([]byte, 16)
-	n, err := rs.Read(magicBytes)
-	if err != nil && n == 0 {
+// metadataFromSeeker detects the format of rs and runs its parser. Parsers
+// read through a contextReadSeeker, so a done ctx fails their next read or
+// seek and the extraction returns ctx.Err().
+func metadataFromSeeker(ctx context.Context, rs io.ReadSeeker, size int64, opts options) (*ImageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.maxBytes > 0 && size > opts.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrTooLarge, size, opts.maxBytes)
+	}
+	rs = &contextReadSeeker{contextReader{ctx: ctx, r: rs}, rs}
+
+	// Fill as much of the header as the input has; a single Read may
+	// return fewer bytes even when more are available
+	magicBytes := make([]byte, formats.MaxHeaderSize)
+	n, err := io.ReadFull(rs, magicBytes)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
 	}
 	magicBytes = magicBytes[:n]
 
 	format := formats.Detect(magicBytes)
 	if format == "" {
-		return nil, ErrUnsupportedFormat
+		// Some formats can only be recognized from their footer
+		format, err = formats.DetectTrailer(rs, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
+		}
+	}
+	if format == "" {
+		format = string(opts.formatHint)
+	}
+	if format == "" {
+		return nil, unsupportedFormatError(magicBytes)
 	}
 
 	if _, err := rs.Seek(0, io.SeekStart); err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
 	}
 
-	md := &ImageMetadata{
-		Format:     Format(format),
-		FileSize:   size,
-		EXIF:       make(map[string]interface{}),
-		Additional: make(map[string]interface{}),
+	parserOpts := opts.parserOptions(size)
+	result, err := formats.ExtractWithOptions(format, rs, parserOpts)
+	// Parsers may treat a failed read as the end of the data; a cancelled
+	// extraction must not return that partial result
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
 	}
-
-	result, err := formats.Extract(format, rs)
 	if err != nil {
+		if result != nil {
+			// The parser stopped early but kept what it read before the error
+			return metadataFromResult(Format(format), size, result, parserOpts), fmt.Errorf("%w: failed to extract %s metadata: %w", ErrPartial, format, err)
+		}
 		return nil, fmt.Errorf("failed to extract %s metadata: %w", format, err)
 	}
+	if opts.strict && (result.Width <= 0 || result.Height <= 0) {
+		return nil, fmt.Errorf("failed to extract %s metadata: %w: missing image dimensions", format, formats.ErrInvalidData)
+	}
 
-	md.Width = result.Width
-	md.Height = result.Height
-	md.ColorDepth = result.ColorDepth
-	md.ColorSpace = ColorSpace(result.ColorSpace)
-	md.HasICCProfile = result.HasICCProfile
+	return metadataFromResult(Format(format), size, result, parserOpts), nil
+}
+
+// unsupportedFormatError explains why header was not recognized. Inputs in
+// a known container get an error naming the container's content type.
+func unsupportedFormatError(header []byte) error {
+	if form, ok := formats.RIFFForm(header); ok {
+		return fmt.Errorf("%w: %w: RIFF form type %q", ErrUnsupportedFormat, ErrUnsupportedContainer, strings.TrimSpace(form))
+	}
+	return ErrUnsupportedFormat
+}
+
+// metadataFromResult copies a format parser's result into ImageMetadata.
+// All parsing lives in the formats package; this is the only place the two
+// representations meet. opts are the options the result was parsed with.
+func metadataFromResult(format Format, size int64, result *formats.Result, opts formats.Options) *ImageMetadata {
+	md := &ImageMetadata{
+		Format:        format,
+		Width:         result.Width,
+		Height:        result.Height,
+		ColorDepth:    result.ColorDepth,
+		ColorSpace:    ColorSpace(result.ColorSpace),
+		HasICCProfile: result.HasICCProfile,
+		FileSize:      size,
+		EXIF:          make(map[string]interface{}),
+		Additional:    make(map[string]interface{}),
+		rawEXIF:       result.RawEXIF,
+	}
+	if result.Format != "" {
+		md.Format = Format(result.Format)
+	}
 	if len(result.EXIF) > 0 {
 		md.EXIF = result.EXIF
 	}
 	if len(result.Additional) > 0 {
 		md.Additional = result.Additional
 	}
+	md.setAnimation()
+	md.setChannels()
+	md.setResolution()
+	md.setOrientation()
+	if opts.DeferEXIF && len(result.RawEXIF) > 0 {
+		// Set last, so the fields above do not decode the deferred block
+		md.lazyEXIF = &lazyEXIF{opts: opts}
+	}
 
-	return md, nil
+	return md
+}
+
+// colorSpaceChannels is the channel count of each color space.
+var colorSpaceChannels = map[ColorSpace]int{
+	ColorSpaceGrayscale:      1,
+	ColorSpaceIndexed:        1,
+	ColorSpaceGrayscaleAlpha: 2,
+	ColorSpaceRGB:            3,
+	ColorSpaceLab:            3,
+	ColorSpaceRGBA:           4,
+	ColorSpaceCMYK:           4,
+	ColorSpaceYCCK:           4,
+}
+
+// setChannels derives HasAlpha and Channels from the color space and the
+// per-format alpha and transparency keys.
+func (md *ImageMetadata) setChannels() {
+	md.Channels = colorSpaceChannels[md.ColorSpace]
+	if md.Channels == 0 {
+		if n, ok := md.AdditionalInt("Channels"); ok {
+			md.Channels = int(n)
+		}
+	}
+
+	md.HasAlpha = md.ColorSpace == ColorSpaceRGBA || md.ColorSpace == ColorSpaceGrayscaleAlpha
+	for _, key := range []string{"HasAlpha", "HasTransparency"} {
+		if alpha, _ := md.Additional[key].(bool); alpha {
+			md.HasAlpha = true
+		}
+	}
+}
+
+// setAnimation derives the animation fields from the format-specific
+// Additional keys, which parsers keep setting for compatibility.
+func (md *ImageMetadata) setAnimation() {
+	for _, key := range []string{"HasAnimation", "IsAnimated", "Animation"} {
+		if animated, _ := md.Additional[key].(bool); animated {
+			md.IsAnimated = true
+		}
+	}
+
+	md.FrameCount = 1
+	if n, ok := md.AdditionalInt("FrameCount"); ok {
+		md.FrameCount = int(n)
+	}
+	if md.FrameCount > 1 {
+		md.IsAnimated = true
+	}
+	if ms, ok := md.AdditionalInt("Duration"); ok {
+		md.Duration = time.Duration(ms) * time.Millisecond
+	}
+	if n, ok := md.AdditionalInt("LoopCount"); ok {
+		md.LoopCount = int(n)
+	}
 }
@@ -1,9 +1,33 @@
 package imx
 
 import (
+	"bufio"
 	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
 
 	"imx/formats"
 )
@@ -152,12 +176,13 @@ func createMinimalWebP() []byte {
 	// Minimal WebP: RIFF header, VP8 chunk
 	webp := []byte{
 		0x52, 0x49, 0x46, 0x46, // "RIFF"
-		0x00, 0x00, 0x00, 0x00, // File size (dummy)
+		0x16, 0x00, 0x00, 0x00, // RIFF size
 		0x57, 0x45, 0x42, 0x50, // "WEBP"
 		0x56, 0x50, 0x38, 0x20, // "VP8 "
-		0x00, 0x00, 0x00, 0x00, // Chunk size (dummy)
+		0x0A, 0x00, 0x00, 0x00, // Chunk size
+		0x10, 0x02, 0x00, // Frame tag (key frame)
 		0x9D, 0x01, 0x2A, // Key frame signature
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Dimensions (dummy)
+		0x64, 0x00, 0x64, 0x00, // 100x100
 	}
 	return webp
 }
@@ -298,6 +323,11 @@ func TestMetadata_GIF(t *testing.T) {
 	if md.Width != 100 || md.Height != 100 {
 		t.Errorf("Dimensions = %dx%d, want 100x100", md.Width, md.Height)
 	}
+
+	// A two-entry global color table needs one bit per pixel
+	if md.ColorDepth != 1 || md.Additional["PaletteBitDepth"] != 24 {
+		t.Errorf("ColorDepth = %d, PaletteBitDepth = %v, want 1, 24", md.ColorDepth, md.Additional["PaletteBitDepth"])
+	}
 }
 
 // TestMetadata_WebP tests WebP metadata extraction
@@ -352,6 +382,29 @@ func TestMetadata_BMP(t *testing.T) {
 	}
 }
 
+func TestMetadata_BMPDPI(t *testing.T) {
+	bmp := createMinimalBMP()
+	binary.LittleEndian.PutUint32(bmp[38:], 2835) // 72.009 DPI
+	binary.LittleEndian.PutUint32(bmp[42:], 11811)
+
+	md, err := MetadataFromBytes(bmp)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["DPIX"] != 72 || md.Additional["DPIY"] != 300 {
+		t.Errorf("DPI = %vx%v, want 72x300", md.Additional["DPIX"], md.Additional["DPIY"])
+	}
+
+	// Unset resolution leaves DPI out
+	md, err = MetadataFromBytes(createMinimalBMP())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["DPIX"]; ok {
+		t.Errorf("DPIX = %v, want unset", md.Additional["DPIX"])
+	}
+}
+
 // TestImageMetadata_Struct tests the ImageMetadata struct fields
 func TestImageMetadata_Struct(t *testing.T) {
 	md := &ImageMetadata{
@@ -394,7 +447,3739 @@ func TestImageMetadata_Struct(t *testing.T) {
 // BenchmarkDetectFormat benchmarks format detection
 func BenchmarkDetectFormat(b *testing.B) {
 	magicBytes := []byte{0xFF, 0xD8, 0xFF, 0xE0}
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		formats.Detect(magicBytes)
 	}
 }
+
+func TestDetect_ZeroAllocs(t *testing.T) {
+	inputs := [][]byte{
+		createMinimalJPEG(),
+		createMinimalPNG(),
+		createMinimalGIF(),
+		createMinimalWebP(),
+		createMinimalAVIF("avif"),
+		[]byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n"),
+		[]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`),
+		bytes.Repeat([]byte("no signature "), 10), // every signature is tried
+	}
+	for _, in := range inputs {
+		if len(in) > formats.MaxHeaderSize {
+			in = in[:formats.MaxHeaderSize]
+		}
+		if n := testing.AllocsPerRun(100, func() { formats.Detect(in) }); n != 0 {
+			t.Errorf("Detect(%q) allocates %v times per call, want 0", in[:4], n)
+		}
+	}
+}
+
+// makeBox builds an ISO-BMFF box from its type and payload parts
+func makeBox(boxType string, parts ...[]byte) []byte {
+	var payload []byte
+	for _, p := range parts {
+		payload = append(payload, p...)
+	}
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box[0:4], uint32(8+len(payload)))
+	copy(box[4:8], boxType)
+	return append(box, payload...)
+}
+
+// createMinimalAVIF creates a minimal AVIF with a primary item, ispe, nclx colr and pixi
+func createMinimalAVIF(brand string) []byte {
+	ftyp := makeBox("ftyp", []byte(brand), []byte{0, 0, 0, 0}, []byte("mif1miaf"))
+	pitm := makeBox("pitm", []byte{0, 0, 0, 0}, []byte{0x00, 0x01})
+	ispe := makeBox("ispe", []byte{0, 0, 0, 0},
+		[]byte{0x00, 0x00, 0x07, 0x80}, // Width (1920)
+		[]byte{0x00, 0x00, 0x04, 0x38}, // Height (1080)
+	)
+	colr := makeBox("colr", []byte("nclx"),
+		[]byte{0x00, 0x09}, // BT.2020 primaries
+		[]byte{0x00, 0x10}, // PQ transfer
+		[]byte{0x00, 0x09}, // BT.2020 matrix
+		[]byte{0x80},       // Full range
+	)
+	pixi := makeBox("pixi", []byte{0, 0, 0, 0}, []byte{0x03, 0x0A, 0x0A, 0x0A})
+	// Unrelated ispe for a second item to make sure pitm is honored
+	thumb := makeBox("ispe", []byte{0, 0, 0, 0}, []byte{0, 0, 0, 0x10}, []byte{0, 0, 0, 0x10})
+	ipco := makeBox("ipco", thumb, ispe, colr, pixi)
+	ipma := makeBox("ipma", []byte{0, 0, 0, 0},
+		[]byte{0, 0, 0, 2},                         // Entry count
+		[]byte{0x00, 0x02, 0x01, 0x01},             // Item 2 -> property 1
+		[]byte{0x00, 0x01, 0x03, 0x02, 0x83, 0x04}, // Item 1 -> properties 2, 3, 4
+	)
+	meta := makeBox("meta", []byte{0, 0, 0, 0}, pitm, makeBox("iprp", ipco, ipma))
+	return append(ftyp, meta...)
+}
+
+func TestMetadata_AVIF(t *testing.T) {
+	md, err := MetadataFromBytes(createMinimalAVIF("avif"))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	if md.Format != FormatAVIF {
+		t.Errorf("Format = %v, want AVIF", md.Format)
+	}
+	if md.Width != 1920 || md.Height != 1080 {
+		t.Errorf("Dimensions = %dx%d, want 1920x1080", md.Width, md.Height)
+	}
+	if md.ColorDepth != 30 {
+		t.Errorf("ColorDepth = %d, want 30", md.ColorDepth)
+	}
+	if md.Additional["TransferCharacteristics"] != 16 || md.Additional["HDR"] != true {
+		t.Errorf("nclx = %v, want PQ HDR", md.Additional)
+	}
+	if md.Additional["Animation"] != false {
+		t.Errorf("Animation = %v, want false", md.Additional["Animation"])
+	}
+
+	seq, err := MetadataFromBytes(createMinimalAVIF("avis"))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(avis) error = %v", err)
+	}
+	if seq.Additional["Animation"] != true {
+		t.Errorf("Animation = %v, want true", seq.Additional["Animation"])
+	}
+}
+
+// TestDetect_BeyondSixteenBytes verifies signatures that need more than 16 header bytes
+func TestDetect_BeyondSixteenBytes(t *testing.T) {
+	// Major brand mif1 with avif listed as the third compatible brand (offset 24)
+	ftyp := makeBox("ftyp", []byte("mif1"), []byte{0, 0, 0, 0}, []byte("mif1miafavif"))
+	if got := formats.Detect(ftyp); got != "AVIF" {
+		t.Errorf("Detect() = %q, want AVIF", got)
+	}
+	if got := formats.Detect(ftyp[:16]); got != "" {
+		t.Errorf("Detect(first 16 bytes) = %q, want no match", got)
+	}
+}
+
+// bitWriter packs LSB-first bit fields the way JPEG XL headers are encoded
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) write(v uint64, bits int) {
+	for i := 0; i < bits; i++ {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte((v>>uint(i))&1) << uint(w.n%8)
+		w.n++
+	}
+}
+
+func TestMetadata_JXL(t *testing.T) {
+	// Bare codestream: 1080 rows, 16:9 ratio, default metadata
+	cs := &bitWriter{}
+	cs.write(0, 1)     // div8
+	cs.write(1, 2)     // U32 selector: 1 + u(13)
+	cs.write(1079, 13) // height - 1
+	cs.write(5, 3)     // ratio 16:9
+	cs.write(1, 1)     // all_default
+	codestream := append([]byte{0xFF, 0x0A}, cs.data...)
+
+	md, err := MetadataFromBytes(codestream)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatJXL {
+		t.Errorf("Format = %v, want JXL", md.Format)
+	}
+	if md.Width != 1920 || md.Height != 1080 {
+		t.Errorf("Dimensions = %dx%d, want 1920x1080", md.Width, md.Height)
+	}
+	if md.ColorDepth != 24 || md.ColorSpace != ColorSpaceRGB {
+		t.Errorf("ColorDepth/ColorSpace = %d/%s, want 24/RGB", md.ColorDepth, md.ColorSpace)
+	}
+
+	// Container: 64x32 16-bit grayscale with default alpha
+	cs = &bitWriter{}
+	cs.write(1, 1)  // div8
+	cs.write(3, 5)  // height/8 - 1
+	cs.write(7, 3)  // ratio 2:1
+	cs.write(0, 1)  // all_default
+	cs.write(0, 1)  // extra_fields
+	cs.write(0, 1)  // float_sample
+	cs.write(3, 2)  // U32 selector: 1 + u(6)
+	cs.write(15, 6) // bits_per_sample - 1
+	cs.write(1, 1)  // modular_16bit_buffer_sufficient
+	cs.write(1, 2)  // num_extra_channels = 1
+	cs.write(1, 1)  // d_alpha
+	cs.write(0, 1)  // xyb_encoded
+	cs.write(0, 1)  // color_encoding.all_default
+	cs.write(0, 1)  // want_icc
+	cs.write(1, 2)  // color_space = kGrey
+	jxlc := makeBox("jxlc", []byte{0xFF, 0x0A}, cs.data)
+	container := []byte{0x00, 0x00, 0x00, 0x0C, 0x4A, 0x58, 0x4C, 0x20, 0x0D, 0x0A, 0x87, 0x0A}
+	container = append(container, makeBox("ftyp", []byte("jxl "), []byte{0, 0, 0, 0}, []byte("jxl "))...)
+	container = append(container, jxlc...)
+
+	md, err = MetadataFromBytes(container)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(container) error = %v", err)
+	}
+	if md.Width != 64 || md.Height != 32 {
+		t.Errorf("Dimensions = %dx%d, want 64x32", md.Width, md.Height)
+	}
+	if md.ColorDepth != 24 || md.ColorSpace != ColorSpaceGrayscaleAlpha {
+		t.Errorf("ColorDepth/ColorSpace = %d/%s, want 24/GrayscaleAlpha", md.ColorDepth, md.ColorSpace)
+	}
+	if md.Additional["Container"] != true {
+		t.Errorf("Container = %v, want true", md.Additional["Container"])
+	}
+}
+
+// encodePaletted renders a paletted image using only the first `used` palette entries
+func encodePaletted(paletteSize, used int) *image.Paletted {
+	palette := make(color.Palette, paletteSize)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i * 10), uint8(i * 5), uint8(i * 3), 0xFF}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 16, 16), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % used)
+	}
+	return img
+}
+
+func TestExtract_UniqueColors(t *testing.T) {
+	img := encodePaletted(16, 3)
+
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := formats.ExtractWithOptions("GIF", bytes.NewReader(gifBuf.Bytes()), formats.Options{EstimateUniqueColors: true})
+	if err != nil {
+		t.Fatalf("ExtractWithOptions(GIF) error = %v", err)
+	}
+	if got := res.Additional["UniqueColors"]; got != 3 {
+		t.Errorf("GIF UniqueColors = %v, want 3", got)
+	}
+
+	// Decoding stops partway through a frame larger than the pixel budget;
+	// the walk resumes after it and the second frame's color is not counted
+	large := image.NewPaletted(image.Rect(0, 0, 2100, 2100), img.Palette)
+	for i := range large.Pix {
+		large.Pix[i] = uint8(i % 2)
+	}
+	extra := encodePaletted(16, 1)
+	for i := range extra.Pix {
+		extra.Pix[i] = 9
+	}
+	var animBuf bytes.Buffer
+	if err := gif.EncodeAll(&animBuf, &gif.GIF{Image: []*image.Paletted{large, extra}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = formats.ExtractWithOptions("GIF", bytes.NewReader(animBuf.Bytes()), formats.Options{EstimateUniqueColors: true})
+	if err != nil {
+		t.Fatalf("ExtractWithOptions(large GIF) error = %v", err)
+	}
+	if res.Additional["UniqueColors"] != 2 || res.Additional["FrameCount"] != 2 {
+		t.Errorf("large GIF UniqueColors, FrameCount = %v, %v, want 2, 2", res.Additional["UniqueColors"], res.Additional["FrameCount"])
+	}
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, encodePaletted(5, 5)); err != nil {
+		t.Fatal(err)
+	}
+	res, err = formats.ExtractWithOptions("PNG", bytes.NewReader(pngBuf.Bytes()), formats.Options{EstimateUniqueColors: true})
+	if err != nil {
+		t.Fatalf("ExtractWithOptions(PNG) error = %v", err)
+	}
+	if got := res.Additional["UniqueColors"]; got != 5 {
+		t.Errorf("PNG UniqueColors = %v, want 5", got)
+	}
+
+	// The same estimates through the public API
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"GIF", gifBuf.Bytes(), 3},
+		{"large GIF", animBuf.Bytes(), 2},
+		{"PNG", pngBuf.Bytes(), 5},
+	} {
+		md, err := MetadataFromBytes(tt.data, WithUniqueColors())
+		if err != nil {
+			t.Fatalf("MetadataFromBytes(%s, WithUniqueColors()) error = %v", tt.name, err)
+		}
+		if got := md.Additional["UniqueColors"]; got != tt.want {
+			t.Errorf("MetadataFromBytes(%s) UniqueColors = %v, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Disabled by default
+	res, err = formats.Extract("GIF", bytes.NewReader(gifBuf.Bytes()))
+	if err != nil {
+		t.Fatalf("Extract(GIF) error = %v", err)
+	}
+	if _, ok := res.Additional["UniqueColors"]; ok {
+		t.Error("UniqueColors reported without EstimateUniqueColors")
+	}
+}
+
+func TestMetadata_ParserOptions(t *testing.T) {
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, encodePaletted(16, 3), nil); err != nil {
+		t.Fatal(err)
+	}
+	// A text chunk after the image data
+	pngData := insertPNGChunk(encodeTestPNG(t), "tEXt", []byte("Comment\x00after IDAT"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		opt     Option
+		key     string
+		without bool // the option removes key rather than adding it
+	}{
+		{"WithUniqueColors", gifBuf.Bytes(), WithUniqueColors(), "UniqueColors", false},
+		{"WithStopAtImageData", pngData, WithStopAtImageData(), "Text", true},
+		{"WithoutPalette", gifBuf.Bytes(), WithoutPalette(), "Palette", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if _, ok := md.Additional[tt.key]; ok != tt.without {
+				t.Errorf("without %s: Additional[%q] present = %v, want %v", tt.name, tt.key, ok, tt.without)
+			}
+
+			md, err = MetadataFromBytes(tt.data, tt.opt)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes(%s) error = %v", tt.name, err)
+			}
+			if _, ok := md.Additional[tt.key]; ok == tt.without {
+				t.Errorf("with %s: Additional[%q] present = %v, want %v", tt.name, tt.key, ok, !tt.without)
+			}
+		})
+	}
+}
+
+func TestMetadata_SVG(t *testing.T) {
+	tests := []struct {
+		name          string
+		doc           string
+		width, height int
+		title         interface{}
+	}{
+		{
+			name:   "explicit size",
+			doc:    `<?xml version="1.0" encoding="UTF-8"?><svg xmlns="http://www.w3.org/2000/svg" width="120px" height="80.2"><title> Logo </title><rect/></svg>`,
+			width:  120,
+			height: 81,
+			title:  "Logo",
+		},
+		{
+			name:   "percentages fall back to viewBox",
+			doc:    "\n<svg width=\"100%\" height=\"100%\" viewBox=\"0 0 300.5 150\"><g/></svg>",
+			width:  301,
+			height: 150,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.Format != FormatSVG {
+				t.Errorf("Format = %v, want SVG", md.Format)
+			}
+			if md.Width != tt.width || md.Height != tt.height {
+				t.Errorf("Dimensions = %dx%d, want %dx%d", md.Width, md.Height, tt.width, tt.height)
+			}
+			if md.Additional["Title"] != tt.title {
+				t.Errorf("Title = %v, want %v", md.Additional["Title"], tt.title)
+			}
+		})
+	}
+
+	if _, err := MetadataFromBytes([]byte(`<?xml version="1.0"?><html></html>`)); err == nil {
+		t.Error("Expected error for non-SVG XML document")
+	}
+}
+
+func TestImageMetadata_FlatMap(t *testing.T) {
+	md := &ImageMetadata{
+		Format:     FormatJPEG,
+		Width:      640,
+		Height:     480,
+		ColorSpace: ColorSpaceRGB,
+		EXIF: map[string]interface{}{
+			"FNumber": 2.8,
+			"Make":    "Canon",
+		},
+		Additional: map[string]interface{}{
+			"Components": 3,
+			"ViewBox":    [4]float64{0, 0, 10.5, 20},
+			"XMP":        map[string]interface{}{"dc:title": "Sunset"},
+		},
+	}
+
+	want := map[string]string{
+		"Format":                  "JPEG",
+		"Width":                   "640",
+		"HasICCProfile":           "false",
+		"EXIF.FNumber":            "2.8",
+		"EXIF.Make":               "Canon",
+		"Additional.Components":   "3",
+		"Additional.ViewBox":      "0, 0, 10.5, 20",
+		"Additional.XMP.dc:title": "Sunset",
+	}
+
+	flat := md.FlatMap()
+	for k, v := range want {
+		if flat[k] != v {
+			t.Errorf("FlatMap()[%q] = %q, want %q", k, flat[k], v)
+		}
+	}
+}
+
+func TestMetadata_ICO(t *testing.T) {
+	ico := []byte{
+		0x00, 0x00, // Reserved
+		0x01, 0x00, // Type (icon)
+		0x03, 0x00, // Image count
+		// 16x16, 8-bit
+		0x10, 0x10, 0x00, 0x00, 0x01, 0x00, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0x36, 0x00, 0x00, 0x00,
+		// 256x256 (stored as 0), 32-bit
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x20, 0x00, 0x00, 0x10, 0x00, 0x00, 0x36, 0x01, 0x00, 0x00,
+		// 48x48, 32-bit
+		0x30, 0x30, 0x00, 0x00, 0x01, 0x00, 0x20, 0x00, 0x00, 0x04, 0x00, 0x00, 0x36, 0x11, 0x00, 0x00,
+	}
+
+	md, err := MetadataFromBytes(ico)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatICO {
+		t.Errorf("Format = %v, want ICO", md.Format)
+	}
+	if md.Width != 256 || md.Height != 256 || md.ColorDepth != 32 {
+		t.Errorf("Largest = %dx%d@%d, want 256x256@32", md.Width, md.Height, md.ColorDepth)
+	}
+	images, ok := md.Additional["Images"].([]formats.IconImage)
+	if !ok || len(images) != 3 {
+		t.Fatalf("Images = %#v, want 3 entries", md.Additional["Images"])
+	}
+	if images[0].Width != 16 || images[2].Height != 48 {
+		t.Errorf("Images = %+v", images)
+	}
+}
+
+// createMinimalTGA creates an uncompressed 32-bit top-down TGA with a 2.0 footer
+func createMinimalTGA() []byte {
+	tga := []byte{
+		0x00,                         // ID length
+		0x00,                         // Color map type
+		0x02,                         // Image type (truecolor)
+		0x00, 0x00, 0x00, 0x00, 0x00, // Color map spec
+		0x00, 0x00, 0x00, 0x00, // Origin
+		0x40, 0x00, // Width (64)
+		0x20, 0x00, // Height (32)
+		0x20, // Pixel depth (32)
+		0x28, // Descriptor: 8 alpha bits, top-left origin
+	}
+	footer := append(make([]byte, 8), []byte("TRUEVISION-XFILE.\x00")...)
+	return append(tga, footer...)
+}
+
+func TestMetadata_TGA(t *testing.T) {
+	md, err := MetadataFromBytes(createMinimalTGA())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatTGA {
+		t.Errorf("Format = %v, want TGA", md.Format)
+	}
+	if md.Width != 64 || md.Height != 32 {
+		t.Errorf("Dimensions = %dx%d, want 64x32", md.Width, md.Height)
+	}
+	if md.ColorSpace != ColorSpaceRGBA || md.Additional["TopDown"] != true {
+		t.Errorf("ColorSpace/TopDown = %v/%v, want RGBA/true", md.ColorSpace, md.Additional["TopDown"])
+	}
+
+	// Without the footer TGA cannot be detected
+	noFooter := createMinimalTGA()[:18]
+	if _, err := MetadataFromBytes(noFooter); err == nil {
+		t.Error("Expected error for TGA without footer")
+	}
+}
+
+func TestMetadata_PSD(t *testing.T) {
+	psd := []byte{
+		0x38, 0x42, 0x50, 0x53, // "8BPS"
+		0x00, 0x02, // Version (PSB)
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Reserved
+		0x00, 0x04, // Channels
+		0x00, 0x00, 0x0B, 0xB8, // Height (3000)
+		0x00, 0x00, 0x0F, 0xA0, // Width (4000)
+		0x00, 0x10, // Depth (16)
+		0x00, 0x04, // Color mode (CMYK)
+	}
+
+	md, err := MetadataFromBytes(psd)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatPSD {
+		t.Errorf("Format = %v, want PSD", md.Format)
+	}
+	if md.Width != 4000 || md.Height != 3000 {
+		t.Errorf("Dimensions = %dx%d, want 4000x3000", md.Width, md.Height)
+	}
+	if md.ColorSpace != ColorSpaceCMYK || md.ColorDepth != 64 {
+		t.Errorf("ColorSpace/ColorDepth = %v/%d, want CMYK/64", md.ColorSpace, md.ColorDepth)
+	}
+	if md.Additional["PSB"] != true {
+		t.Errorf("PSB = %v, want true", md.Additional["PSB"])
+	}
+}
+
+func TestMetadata_PNM(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		width, height int
+		colorSpace    ColorSpace
+		depth         int
+	}{
+		{"PBM ASCII", "P1\n# bitmap\n4 2\n0 1 0 1\n1 0 1 0\n", 4, 2, ColorSpaceGrayscale, 1},
+		{"PGM binary 16-bit", "P5 3 3 65535\n", 3, 3, ColorSpaceGrayscale, 16},
+		{"PPM binary", "P6\n640 # width\n480\n255\n", 640, 480, ColorSpaceRGB, 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.Format != FormatPNM {
+				t.Errorf("Format = %v, want PNM", md.Format)
+			}
+			if md.Width != tt.width || md.Height != tt.height {
+				t.Errorf("Dimensions = %dx%d, want %dx%d", md.Width, md.Height, tt.width, tt.height)
+			}
+			if md.ColorSpace != tt.colorSpace || md.ColorDepth != tt.depth {
+				t.Errorf("ColorSpace/ColorDepth = %v/%d, want %v/%d", md.ColorSpace, md.ColorDepth, tt.colorSpace, tt.depth)
+			}
+		})
+	}
+}
+
+func TestMetadata_HDR(t *testing.T) {
+	hdr := "#?RADIANCE\n# made by a test\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.5\n\n-Y 1080 +X 1920\n"
+	md, err := MetadataFromBytes([]byte(hdr))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatHDR {
+		t.Errorf("Format = %v, want HDR", md.Format)
+	}
+	if md.Width != 1920 || md.Height != 1080 {
+		t.Errorf("Dimensions = %dx%d, want 1920x1080", md.Width, md.Height)
+	}
+	if md.Additional["Format"] != "32-bit_rle_rgbe" || md.Additional["Orientation"] != "-Y +X" {
+		t.Errorf("Additional = %v", md.Additional)
+	}
+
+	rotated, err := MetadataFromBytes([]byte("#?RGBE\n\n+X 200 -Y 100\n"))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(rotated) error = %v", err)
+	}
+	if rotated.Width != 200 || rotated.Height != 100 || rotated.Additional["Orientation"] != "+X -Y" {
+		t.Errorf("Rotated = %dx%d %v", rotated.Width, rotated.Height, rotated.Additional["Orientation"])
+	}
+}
+
+// createMinimalDDS creates a DDS header with the given pixel format fields
+func createMinimalDDS(pfFlags uint32, fourCC string, bitCount uint32, dx10Format uint32) []byte {
+	dds := make([]byte, 4+124)
+	copy(dds, "DDS ")
+	h := dds[4:]
+	binary.LittleEndian.PutUint32(h[0:], 124)
+	binary.LittleEndian.PutUint32(h[4:], 0x1007|0x20000) // Caps, height, width, pixel format, mipmap count
+	binary.LittleEndian.PutUint32(h[8:], 256)            // Height
+	binary.LittleEndian.PutUint32(h[12:], 512)           // Width
+	binary.LittleEndian.PutUint32(h[24:], 10)            // Mipmap count
+	binary.LittleEndian.PutUint32(h[72:], 32)            // Pixel format size
+	binary.LittleEndian.PutUint32(h[76:], pfFlags)
+	copy(h[80:84], fourCC)
+	binary.LittleEndian.PutUint32(h[84:], bitCount)
+	if fourCC == "DX10" {
+		dx10 := make([]byte, 20)
+		binary.LittleEndian.PutUint32(dx10[0:], dx10Format)
+		dds = append(dds, dx10...)
+	}
+	return dds
+}
+
+func TestMetadata_DDS(t *testing.T) {
+	md, err := MetadataFromBytes(createMinimalDDS(0x4, "DXT5", 0, 0))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatDDS {
+		t.Errorf("Format = %v, want DDS", md.Format)
+	}
+	if md.Width != 512 || md.Height != 256 {
+		t.Errorf("Dimensions = %dx%d, want 512x256", md.Width, md.Height)
+	}
+	if md.Additional["FourCC"] != "DXT5" || md.Additional["MipMapCount"] != 10 {
+		t.Errorf("Additional = %v", md.Additional)
+	}
+
+	md, err = MetadataFromBytes(createMinimalDDS(0x4, "DX10", 0, 98))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(DX10) error = %v", err)
+	}
+	if md.Additional["DXGIFormat"] != 98 {
+		t.Errorf("DXGIFormat = %v, want 98", md.Additional["DXGIFormat"])
+	}
+
+	md, err = MetadataFromBytes(createMinimalDDS(0x41, "", 32, 0))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(RGBA) error = %v", err)
+	}
+	if md.ColorDepth != 32 || md.ColorSpace != ColorSpaceRGBA {
+		t.Errorf("ColorDepth/ColorSpace = %d/%v, want 32/RGBA", md.ColorDepth, md.ColorSpace)
+	}
+}
+
+func TestMetadata_JP2(t *testing.T) {
+	ihdr := makeBox("ihdr",
+		[]byte{0x00, 0x00, 0x02, 0x00}, // Height (512)
+		[]byte{0x00, 0x00, 0x03, 0x00}, // Width (768)
+		[]byte{0x00, 0x03},             // Components
+		[]byte{0x07, 0x07, 0x00, 0x00}, // BPC (8), compression, UnkC, IPR
+	)
+	colr := makeBox("colr", []byte{0x01, 0x00, 0x00}, []byte{0x00, 0x00, 0x00, 0x10})
+	jp2 := []byte{0x00, 0x00, 0x00, 0x0C, 0x6A, 0x50, 0x20, 0x20, 0x0D, 0x0A, 0x87, 0x0A}
+	jp2 = append(jp2, makeBox("ftyp", []byte("jp2 "), []byte{0, 0, 0, 0}, []byte("jp2 "))...)
+	jp2 = append(jp2, makeBox("jp2h", ihdr, colr)...)
+
+	md, err := MetadataFromBytes(jp2)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatJP2 {
+		t.Errorf("Format = %v, want JP2", md.Format)
+	}
+	if md.Width != 768 || md.Height != 512 || md.ColorDepth != 24 || md.ColorSpace != ColorSpaceRGB {
+		t.Errorf("Got %dx%d %d-bit %v, want 768x512 24-bit RGB", md.Width, md.Height, md.ColorDepth, md.ColorSpace)
+	}
+
+	j2k := []byte{
+		0xFF, 0x4F, 0xFF, 0x51, // SOC, SIZ
+		0x00, 0x29, 0x00, 0x00, // Lsiz, Rsiz
+		0x00, 0x00, 0x01, 0x00, // Xsiz (256)
+		0x00, 0x00, 0x00, 0x80, // Ysiz (128)
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Image offsets
+		0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x80, // Tile size
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Tile offsets
+		0x00, 0x01, // Csiz
+		0x0B, 0x01, 0x01, // 12-bit component
+	}
+	md, err = MetadataFromBytes(j2k)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(J2K) error = %v", err)
+	}
+	if md.Width != 256 || md.Height != 128 || md.ColorDepth != 12 || md.ColorSpace != ColorSpaceGrayscale {
+		t.Errorf("Got %dx%d %d-bit %v, want 256x128 12-bit Grayscale", md.Width, md.Height, md.ColorDepth, md.ColorSpace)
+	}
+}
+
+func TestMetadata_QOI(t *testing.T) {
+	qoi := []byte{
+		0x71, 0x6F, 0x69, 0x66, // "qoif"
+		0x00, 0x00, 0x01, 0x40, // Width (320)
+		0x00, 0x00, 0x00, 0xF0, // Height (240)
+		0x04, // Channels (RGBA)
+		0x01, // Colorspace (linear)
+	}
+
+	md, err := MetadataFromBytes(qoi)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatQOI {
+		t.Errorf("Format = %v, want QOI", md.Format)
+	}
+	if md.Width != 320 || md.Height != 240 || md.ColorDepth != 32 || md.ColorSpace != ColorSpaceRGBA {
+		t.Errorf("Got %dx%d %d-bit %v, want 320x240 32-bit RGBA", md.Width, md.Height, md.ColorDepth, md.ColorSpace)
+	}
+	if md.Additional["Colorspace"] != 1 {
+		t.Errorf("Colorspace = %v, want 1", md.Additional["Colorspace"])
+	}
+}
+
+func TestMetadata_PCX(t *testing.T) {
+	pcx := make([]byte, 128)
+	pcx[0] = 0x0A                                // Manufacturer
+	pcx[1] = 0x05                                // Version
+	pcx[2] = 0x01                                // RLE encoding
+	pcx[3] = 0x08                                // Bits per pixel
+	binary.LittleEndian.PutUint16(pcx[8:], 639)  // Xmax
+	binary.LittleEndian.PutUint16(pcx[10:], 479) // Ymax
+	binary.LittleEndian.PutUint16(pcx[12:], 300) // HDpi
+	binary.LittleEndian.PutUint16(pcx[14:], 300) // VDpi
+	pcx[65] = 0x03                               // Planes
+	binary.LittleEndian.PutUint16(pcx[66:], 640) // Bytes per line
+
+	md, err := MetadataFromBytes(pcx)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatPCX {
+		t.Errorf("Format = %v, want PCX", md.Format)
+	}
+	if md.Width != 640 || md.Height != 480 || md.ColorDepth != 24 || md.ColorSpace != ColorSpaceRGB {
+		t.Errorf("Got %dx%d %d-bit %v, want 640x480 24-bit RGB", md.Width, md.Height, md.ColorDepth, md.ColorSpace)
+	}
+	if md.Additional["DPIX"] != 300 {
+		t.Errorf("DPIX = %v, want 300", md.Additional["DPIX"])
+	}
+
+	// Inconsistent scan line size is not detected as PCX
+	binary.LittleEndian.PutUint16(pcx[66:], 3)
+	if _, err := MetadataFromBytes(pcx); err == nil {
+		t.Error("Expected error for inconsistent PCX header")
+	}
+}
+
+// oneByteReadSeeker returns a ReadSeeker over data whose Read yields at most one byte per call
+func oneByteReadSeeker(data []byte) io.ReadSeeker {
+	br := bytes.NewReader(data)
+	return struct {
+		io.Reader
+		io.Seeker
+	}{iotest.OneByteReader(br), br}
+}
+
+func TestExtract_ShortReads(t *testing.T) {
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, encodePaletted(16, 3), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		data   []byte
+	}{
+		{"JPEG", createJPEGWithEXIF(buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon")}}))},
+		{"PNG", createMinimalPNG()},
+		{"GIF", gifBuf.Bytes()},
+		{"BMP", createMinimalBMP()},
+		{"WebP", createWebP(
+			vp8xChunk(0x08, 320, 240), // EXIF
+			webpChunk("EXIF", buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6)}})),
+		)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			want, err := formats.Extract(tt.format, bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			got, err := formats.Extract(tt.format, oneByteReadSeeker(tt.data))
+			if err != nil {
+				t.Fatalf("Extract() with one-byte reads error = %v", err)
+			}
+			if got.Width != want.Width || got.Height != want.Height || got.ColorDepth != want.ColorDepth {
+				t.Errorf("Got %dx%d %d-bit, want %dx%d %d-bit",
+					got.Width, got.Height, got.ColorDepth, want.Width, want.Height, want.ColorDepth)
+			}
+			if len(got.EXIF) != len(want.EXIF) {
+				t.Errorf("EXIF has %d tags, want %d", len(got.EXIF), len(want.EXIF))
+			}
+		})
+	}
+
+	// A header cut short is invalid data, not a partially parsed result
+	_, err := formats.Extract("BMP", oneByteReadSeeker(createMinimalBMP()[:30]))
+	if !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("Truncated BMP error = %v, want ErrInvalidData", err)
+	}
+}
+
+func TestMetadata_ShortHeaderRead(t *testing.T) {
+	tests := []struct {
+		want Format
+		data []byte
+	}{
+		{FormatPNG, createMinimalPNG()},
+		{FormatGIF, createMinimalGIF()},
+		{FormatBMP, createMinimalBMP()},
+		// RIFF and ISO BMFF brands are only recognized from all 12 bytes
+		{FormatWebP, createMinimalWebP()},
+		{FormatAVIF, createMinimalAVIF("avif")},
+	}
+
+	for _, tt := range tests {
+		md, err := metadataFromSeeker(context.Background(), oneByteReadSeeker(tt.data), int64(len(tt.data)), options{})
+		if err != nil {
+			t.Errorf("%s: metadataFromSeeker() error = %v", tt.want, err)
+			continue
+		}
+		if md.Format != tt.want {
+			t.Errorf("Format = %v, want %v", md.Format, tt.want)
+		}
+	}
+
+	// Inputs shorter than the header buffer are not a read error
+	if _, err := metadataFromSeeker(context.Background(), oneByteReadSeeker([]byte{0x00}), 1, options{}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Tiny input error = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+// jpegSegment encodes a JPEG marker segment with the given payload
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// createJPEGWithSegments inserts segments after the SOI marker of the minimal JPEG
+func createJPEGWithSegments(segments ...[]byte) []byte {
+	base := createMinimalJPEG()
+	jpeg := append([]byte{}, base[:2]...)
+	for _, seg := range segments {
+		jpeg = append(jpeg, seg...)
+	}
+	return append(jpeg, base[2:]...)
+}
+
+func TestMetadata_JPEGXMP(t *testing.T) {
+	attrPacket := `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmp:Rating="4"
+    photoshop:DateCreated="2024-03-09T14:05:30"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+	elemPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li><rdf:li>John Roe</rdf:li></rdf:Seq></dc:creator>
+   <dc:title><rdf:Alt><rdf:li xml:lang="de">Sonnenuntergang</rdf:li><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Over the lake</rdf:li></rdf:Alt></dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	jpeg := createJPEGWithSegments(
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), attrPacket...)),
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), elemPacket...)),
+	)
+	md, err := MetadataFromBytes(jpeg)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	xmp, ok := md.Additional["XMP"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("XMP = %v, want a map", md.Additional["XMP"])
+	}
+	if got := xmp["xmp:Rating"]; got != 4 {
+		t.Errorf("xmp:Rating = %v, want 4", got)
+	}
+	if got := xmp["photoshop:DateCreated"]; got != "2024-03-09T14:05:30" {
+		t.Errorf("photoshop:DateCreated = %v", got)
+	}
+	if got, ok := xmp["dc:creator"].([]string); !ok || len(got) != 2 || got[1] != "John Roe" {
+		t.Errorf("dc:creator = %v, want [Jane Doe John Roe]", xmp["dc:creator"])
+	}
+	if got := xmp["dc:title"]; got != "Sunset" {
+		t.Errorf("dc:title = %v, want Sunset", got)
+	}
+	if got := xmp["dc:description"]; got != "Over the lake" {
+		t.Errorf("dc:description = %v, want Over the lake", got)
+	}
+}
+
+func TestMetadata_JPEGExtendedXMP(t *testing.T) {
+	const guid = "0123456789ABCDEF0123456789ABCDEF"
+	main := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:xmpNote="http://ns.adobe.com/xmp/note/" xmpNote:HasExtendedXMP="` + guid + `"/>
+</rdf:RDF></x:xmpmeta>`
+	extended := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description GDepth:Data="AAAA"/></rdf:RDF></x:xmpmeta>`
+
+	chunk := func(guid string, offset int, data string) []byte {
+		payload := []byte("http://ns.adobe.com/xmp/extension/\x00" + guid)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(extended)))
+		payload = binary.BigEndian.AppendUint32(payload, uint32(offset))
+		return jpegSegment(0xE1, append(payload, data...))
+	}
+	split := 40
+
+	jpeg := createJPEGWithSegments(
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), main...)),
+		chunk(guid, split, extended[split:]),
+		chunk("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 0, "<unrelated/>"),
+		chunk(guid, 0, extended[:split]),
+	)
+	md, err := MetadataFromBytes(jpeg)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if got := md.Additional["XMPExtended"]; got != extended {
+		t.Errorf("XMPExtended = %q, want %q", got, extended)
+	}
+
+	// A missing chunk leaves nothing to report
+	jpeg = createJPEGWithSegments(
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), main...)),
+		chunk(guid, split, extended[split:]),
+	)
+	md, err = MetadataFromBytes(jpeg)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if got, ok := md.Additional["XMPExtended"]; ok {
+		t.Errorf("XMPExtended = %q from incomplete chunks", got)
+	}
+}
+
+func TestMetadata_JPEGIPTC(t *testing.T) {
+	var iim []byte
+	dataset := func(record, id byte, value string) {
+		iim = append(iim, 0x1C, record, id, 0, 0)
+		binary.BigEndian.PutUint16(iim[len(iim)-2:], uint16(len(value)))
+		iim = append(iim, value...)
+	}
+	dataset(1, 90, "\x1b%G")
+	dataset(2, 5, "Harbor")
+	dataset(2, 25, "boats")
+	dataset(2, 25, "sunset")
+	dataset(2, 80, "Jane Doe")
+	dataset(2, 110, "Example News")
+	dataset(2, 120, "Fishing boats at dusk")
+
+	resource := func(id uint16, data []byte) []byte {
+		b := []byte("8BIM")
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0) // empty, padded name
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = append(b, data...)
+		if len(data)%2 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	payload := []byte("Photoshop 3.0\x00")
+	payload = append(payload, resource(0x03ED, make([]byte, 16))...)
+	payload = append(payload, resource(0x0404, iim)...)
+
+	md, err := MetadataFromBytes(createJPEGWithSegments(jpegSegment(0xED, payload)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	iptc, ok := md.Additional["IPTC"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("IPTC = %v, want a map", md.Additional["IPTC"])
+	}
+	if got := iptc["ObjectName"]; got != "Harbor" {
+		t.Errorf("ObjectName = %v, want Harbor", got)
+	}
+	if got, ok := iptc["Keywords"].([]string); !ok || len(got) != 2 || got[0] != "boats" || got[1] != "sunset" {
+		t.Errorf("Keywords = %v, want [boats sunset]", iptc["Keywords"])
+	}
+	if got, ok := iptc["By-line"].([]string); !ok || len(got) != 1 || got[0] != "Jane Doe" {
+		t.Errorf("By-line = %v, want [Jane Doe]", iptc["By-line"])
+	}
+	if got := iptc["Credit"]; got != "Example News" {
+		t.Errorf("Credit = %v, want Example News", got)
+	}
+	if got := iptc["Caption-Abstract"]; got != "Fishing boats at dusk" {
+		t.Errorf("Caption-Abstract = %v, want Fishing boats at dusk", got)
+	}
+}
+
+// createICCProfile builds a minimal display RGB profile whose only tag is desc
+func createICCProfile(description string) []byte {
+	// multiLocalizedUnicodeType with a single en-US record
+	desc := []byte("mluc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, 1)
+	desc = binary.BigEndian.AppendUint32(desc, 12)
+	desc = append(desc, "enUS"...)
+	desc = binary.BigEndian.AppendUint32(desc, uint32(2*len(description)))
+	desc = binary.BigEndian.AppendUint32(desc, 28)
+	for _, c := range description {
+		desc = binary.BigEndian.AppendUint16(desc, uint16(c))
+	}
+
+	profile := make([]byte, 128)
+	copy(profile[4:], "lcms")
+	profile[8], profile[9] = 4, 0x30 // Version 4.3.0
+	copy(profile[12:], "mntr")
+	copy(profile[16:], "RGB ")
+	copy(profile[20:], "XYZ ")
+	copy(profile[36:], "acsp")
+	binary.BigEndian.PutUint32(profile[64:], 1) // Media-relative colorimetric
+	profile = binary.BigEndian.AppendUint32(profile, 1)
+	profile = append(profile, "desc"...)
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(profile)+8))
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(desc)))
+	profile = append(profile, desc...)
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	return profile
+}
+
+func TestMetadata_JPEGICCProfile(t *testing.T) {
+	profile := createICCProfile("Display P3")
+	chunk := func(seq int, data []byte) []byte {
+		payload := append([]byte("ICC_PROFILE\x00"), byte(seq), 2)
+		return jpegSegment(0xE2, append(payload, data...))
+	}
+	split := 100
+
+	// Chunks may arrive out of order
+	jpeg := createJPEGWithSegments(chunk(2, profile[split:]), chunk(1, profile[:split]))
+	md, err := MetadataFromBytes(jpeg)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if !md.HasICCProfile {
+		t.Error("HasICCProfile = false, want true")
+	}
+
+	icc, ok := md.Additional["ICCProfile"].(formats.ICCProfile)
+	if !ok {
+		t.Fatalf("ICCProfile = %v, want formats.ICCProfile", md.Additional["ICCProfile"])
+	}
+	want := formats.ICCProfile{
+		Description:     "Display P3",
+		ColorSpace:      "RGB",
+		ConnectionSpace: "XYZ",
+		DeviceClass:     "Display Device Profile",
+		RenderingIntent: "Media-Relative Colorimetric",
+		Version:         "4.3.0",
+		CMM:             "lcms",
+		Size:            uint32(len(profile)),
+	}
+	if icc != want {
+		t.Errorf("ICCProfile = %+v, want %+v", icc, want)
+	}
+
+	// A missing chunk still reports the profile's presence
+	md, err = MetadataFromBytes(createJPEGWithSegments(chunk(1, profile[:split])))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["ICCProfile"]; ok || !md.HasICCProfile {
+		t.Errorf("Incomplete profile: HasICCProfile = %v, ICCProfile = %v", md.HasICCProfile, md.Additional["ICCProfile"])
+	}
+}
+
+// createPNGWithICCP inserts an iCCP chunk holding the compressed profile after IHDR
+func createPNGWithICCP(name string, profile []byte) []byte {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(profile)
+	zw.Close()
+
+	data := append([]byte(name), 0, 0)
+	return createPNGWithChunk("iCCP", append(data, compressed.Bytes()...))
+}
+
+func TestMetadata_PNGICCProfile(t *testing.T) {
+	md, err := MetadataFromBytes(createPNGWithICCP("Display P3", createICCProfile("Display P3")))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if !md.HasICCProfile {
+		t.Error("HasICCProfile = false, want true")
+	}
+	icc, ok := md.Additional["ICCProfile"].(formats.ICCProfile)
+	if !ok {
+		t.Fatalf("ICCProfile = %v, want formats.ICCProfile", md.Additional["ICCProfile"])
+	}
+	if icc.Name != "Display P3" || icc.ColorSpace != "RGB" || icc.Description != "Display P3" {
+		t.Errorf("ICCProfile = %+v, want name, color space and description", icc)
+	}
+
+	// A profile that inflates past the cap is reported but not parsed
+	bomb := append(createICCProfile("bomb"), make([]byte, 16<<20)...)
+	md, err = MetadataFromBytes(createPNGWithICCP("bomb", bomb))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["ICCProfile"]; ok || !md.HasICCProfile {
+		t.Errorf("Oversized profile: HasICCProfile = %v, ICCProfile = %v", md.HasICCProfile, md.Additional["ICCProfile"])
+	}
+}
+
+func TestMetadata_JPEGDensity(t *testing.T) {
+	md, err := MetadataFromBytes(createMinimalJPEG())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["DensityUnit"] != "dpi" || md.Additional["DPIX"] != 72 || md.Additional["DPIY"] != 72 {
+		t.Errorf("Density = %v %vx%v, want dpi 72x72", md.Additional["DensityUnit"], md.Additional["DPIX"], md.Additional["DPIY"])
+	}
+
+	// Dots per centimetre, followed by a JFXX thumbnail extension
+	jfif := []byte{'J', 'F', 'I', 'F', 0, 1, 2, 2, 0, 0x76, 0, 0x3B, 0, 0}
+	jfxx := []byte{'J', 'F', 'X', 'X', 0, 0x13, 1, 1, 0xFF}
+	base := createMinimalJPEG()
+	jpeg := append([]byte{0xFF, 0xD8}, jpegSegment(0xE0, jfif)...)
+	jpeg = append(jpeg, jpegSegment(0xE0, jfxx)...)
+	jpeg = append(jpeg, base[20:]...)
+
+	md, err = MetadataFromBytes(jpeg)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["DensityUnit"] != "dpcm" || md.Additional["XDensity"] != 118 || md.Additional["YDensity"] != 59 {
+		t.Errorf("Density = %v %vx%v, want dpcm 118x59", md.Additional["DensityUnit"], md.Additional["XDensity"], md.Additional["YDensity"])
+	}
+	if _, ok := md.Additional["DPIX"]; ok {
+		t.Errorf("DPIX = %v, want unset for dpcm", md.Additional["DPIX"])
+	}
+	if md.Width != 100 || md.Height != 100 {
+		t.Errorf("Dimensions = %dx%d, want 100x100", md.Width, md.Height)
+	}
+}
+
+// createJPEGWithSOF builds a 32x16 JPEG holding only a frame header with one
+// component per sampling factor byte
+func createJPEGWithSOF(marker, precision byte, sampling ...byte) []byte {
+	sof := []byte{precision, 0x00, 0x10, 0x00, 0x20, byte(len(sampling))}
+	for i, hv := range sampling {
+		sof = append(sof, byte(i+1), hv, 0)
+	}
+	jpeg := append([]byte{0xFF, 0xD8}, jpegSegment(marker, sof)...)
+	return append(jpeg, 0xFF, 0xD9)
+}
+
+func TestMetadata_JPEGColorDepth(t *testing.T) {
+	tests := []struct {
+		name       string
+		marker     byte
+		precision  byte
+		components byte
+		colorSpace ColorSpace
+		colorDepth int
+	}{
+		{"grayscale", 0xC0, 8, 1, ColorSpaceGrayscale, 8},
+		{"RGB", 0xC0, 8, 3, ColorSpaceRGB, 24},
+		{"CMYK", 0xC2, 8, 4, ColorSpaceCMYK, 32},
+		{"12-bit grayscale", 0xC1, 12, 1, ColorSpaceGrayscale, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampling := make([]byte, tt.components)
+			for i := range sampling {
+				sampling[i] = 0x11
+			}
+			md, err := MetadataFromBytes(createJPEGWithSOF(tt.marker, tt.precision, sampling...))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.ColorDepth != tt.colorDepth || md.ColorSpace != tt.colorSpace {
+				t.Errorf("ColorDepth, ColorSpace = %d, %s, want %d, %s", md.ColorDepth, md.ColorSpace, tt.colorDepth, tt.colorSpace)
+			}
+			if md.Additional["BitsPerSample"] != int(tt.precision) {
+				t.Errorf("BitsPerSample = %v, want %d", md.Additional["BitsPerSample"], tt.precision)
+			}
+			if md.Width != 32 || md.Height != 16 {
+				t.Errorf("Dimensions = %dx%d, want 32x16", md.Width, md.Height)
+			}
+		})
+	}
+}
+
+func TestMetadata_JPEGEncoding(t *testing.T) {
+	tests := []struct {
+		marker      byte
+		encoding    string
+		progressive bool
+	}{
+		{0xC0, "Baseline", false},
+		{0xC1, "Baseline", false},
+		{0xC2, "Progressive", true},
+		{0xC3, "Lossless", false},
+		{0xCA, "Progressive", true},
+	}
+
+	for _, tt := range tests {
+		md, err := MetadataFromBytes(createJPEGWithSOF(tt.marker, 8, 0x11, 0x11, 0x11))
+		if err != nil {
+			t.Fatalf("SOF %#x: MetadataFromBytes() error = %v", tt.marker, err)
+		}
+		if md.Additional["Encoding"] != tt.encoding || md.Additional["Progressive"] != tt.progressive {
+			t.Errorf("SOF %#x: Encoding, Progressive = %v, %v, want %s, %v",
+				tt.marker, md.Additional["Encoding"], md.Additional["Progressive"], tt.encoding, tt.progressive)
+		}
+	}
+}
+
+func TestMetadata_JPEGChromaSubsampling(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampling []byte
+		want     interface{}
+	}{
+		{"4:4:4", []byte{0x11, 0x11, 0x11}, "4:4:4"},
+		{"4:2:2", []byte{0x21, 0x11, 0x11}, "4:2:2"},
+		{"4:2:0", []byte{0x22, 0x11, 0x11}, "4:2:0"},
+		{"4:1:1", []byte{0x41, 0x11, 0x11}, "4:1:1"},
+		{"mismatched chroma", []byte{0x22, 0x11, 0x21}, nil},
+		{"grayscale", []byte{0x11}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(createJPEGWithSOF(0xC0, 8, tt.sampling...))
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if got := md.Additional["ChromaSubsampling"]; got != tt.want {
+				t.Errorf("ChromaSubsampling = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata_JPEGComment(t *testing.T) {
+	md, err := MetadataFromBytes(createJPEGWithSegments(jpegSegment(0xFE, []byte("Exported for print\x00"))))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["Comment"] != "Exported for print" {
+		t.Errorf("Comment = %q, want %q", md.Additional["Comment"], "Exported for print")
+	}
+
+	// Multiple comments are collected in order; Latin-1 is accepted
+	md, err = MetadataFromBytes(createJPEGWithSegments(
+		jpegSegment(0xFE, []byte("first")),
+		jpegSegment(0xFE, []byte("caf\xe9")),
+	))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	comments, ok := md.Additional["Comment"].([]string)
+	if !ok || len(comments) != 2 || comments[0] != "first" || comments[1] != "café" {
+		t.Errorf("Comment = %#v, want [first café]", md.Additional["Comment"])
+	}
+}
+
+func TestMetadata_JPEGAdobeTransform(t *testing.T) {
+	adobe := func(transform byte) []byte {
+		return jpegSegment(0xEE, []byte{'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, transform})
+	}
+	cmyk := createJPEGWithSOF(0xC0, 8, 0x11, 0x11, 0x11, 0x11)
+	withAdobe := func(transform byte) []byte {
+		return append(append([]byte{0xFF, 0xD8}, adobe(transform)...), cmyk[2:]...)
+	}
+
+	tests := []struct {
+		name       string
+		jpeg       []byte
+		colorSpace ColorSpace
+		inverted   interface{}
+	}{
+		{"CMYK without APP14", cmyk, ColorSpaceCMYK, nil},
+		{"Adobe CMYK", withAdobe(0), ColorSpaceCMYK, true},
+		{"Adobe YCCK", withAdobe(2), ColorSpaceYCCK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.jpeg)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.ColorSpace != tt.colorSpace {
+				t.Errorf("ColorSpace = %v, want %v", md.ColorSpace, tt.colorSpace)
+			}
+			if md.Additional["InvertedCMYK"] != tt.inverted {
+				t.Errorf("InvertedCMYK = %v, want %v", md.Additional["InvertedCMYK"], tt.inverted)
+			}
+		})
+	}
+
+	md, err := MetadataFromBytes(withAdobe(2))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["AdobeTransform"] != 2 {
+		t.Errorf("AdobeTransform = %v, want 2", md.Additional["AdobeTransform"])
+	}
+}
+
+func TestMetadata_JPEGMPF(t *testing.T) {
+	le := binary.LittleEndian
+	mpf := []byte("MPF\x00II*\x00")
+	mpf = le.AppendUint32(mpf, 8)
+	mpf = le.AppendUint16(mpf, 3)
+	// MPFVersion, NumberOfImages, MPEntry (two 16-byte entries after the IFD)
+	mpf = append(mpf, 0x00, 0xB0, 7, 0, 4, 0, 0, 0, '0', '1', '0', '0')
+	mpf = append(mpf, 0x01, 0xB0, 4, 0, 1, 0, 0, 0, 2, 0, 0, 0)
+	mpf = append(mpf, 0x02, 0xB0, 7, 0, 32, 0, 0, 0, 50, 0, 0, 0)
+	mpf = le.AppendUint32(mpf, 0) // no next IFD
+	for _, entry := range [][3]uint32{{0x20030000, 1000, 0}, {0x00020002, 500, 200}} {
+		mpf = le.AppendUint32(mpf, entry[0])
+		mpf = le.AppendUint32(mpf, entry[1])
+		mpf = le.AppendUint32(mpf, entry[2])
+		mpf = le.AppendUint32(mpf, 0)
+	}
+
+	md, err := MetadataFromBytes(createJPEGWithSegments(jpegSegment(0xE2, mpf)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	images, ok := md.Additional["MPF"].([]formats.MPImage)
+	if !ok || len(images) != 2 {
+		t.Fatalf("MPF = %#v, want two images", md.Additional["MPF"])
+	}
+	want := []formats.MPImage{
+		{Attributes: 0x20030000, Type: "Baseline MP Primary Image", Size: 1000, Offset: 0},
+		// Offsets are relative to the TIFF header at file offset 2+4+4
+		{Attributes: 0x00020002, Type: "Multi-Frame Disparity", Size: 500, Offset: 10 + 200},
+	}
+	for i := range want {
+		if images[i] != want[i] {
+			t.Errorf("MPF[%d] = %+v, want %+v", i, images[i], want[i])
+		}
+	}
+}
+
+func TestMetadata_JPEGMPFPointers(t *testing.T) {
+	// An MP Index carrying EXIF sub-IFD pointers, which parseMPF ignores
+	data := createJPEGWithSegments(jpegSegment(0xE2, append([]byte("MPF\x00"), fanOutTIFF()...)))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := MetadataFromBytes(data, WithoutEXIF())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("MetadataFromBytes() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("MetadataFromBytes() did not return within a second")
+	}
+}
+
+func TestMetadata_GIFTiming(t *testing.T) {
+	tests := []struct {
+		name      string
+		loopCount int
+	}{
+		{"infinite", 0},
+		{"three loops", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			anim := &gif.GIF{
+				Image:     []*image.Paletted{encodePaletted(4, 4), encodePaletted(4, 2), encodePaletted(4, 3)},
+				Delay:     []int{10, 25, 4},
+				LoopCount: tt.loopCount,
+			}
+			if err := gif.EncodeAll(&buf, anim); err != nil {
+				t.Fatal(err)
+			}
+
+			md, err := MetadataFromBytes(buf.Bytes())
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			delays, _ := md.Additional["FrameDelays"].([]int)
+			if len(delays) != 3 || delays[0] != 100 || delays[1] != 250 || delays[2] != 40 {
+				t.Errorf("FrameDelays = %v, want [100 250 40]", md.Additional["FrameDelays"])
+			}
+			if md.Additional["Duration"] != 390 {
+				t.Errorf("Duration = %v, want 390", md.Additional["Duration"])
+			}
+			if md.Additional["LoopCount"] != tt.loopCount {
+				t.Errorf("LoopCount = %v, want %d", md.Additional["LoopCount"], tt.loopCount)
+			}
+		})
+	}
+}
+
+func TestMetadata_GIFComment(t *testing.T) {
+	base := createMinimalGIF()
+	const screenEnd = 13 // header and screen descriptor
+	gifData := append([]byte{}, base[:screenEnd]...)
+	gifData = append(gifData, make([]byte, 6)...) // two-entry global color table
+	// Comment split across two sub-blocks, then an unknown extension
+	gifData = append(gifData, 0x21, 0xFE, 7, 'C', 'r', 'e', 'a', 't', 'e', 'd', 9, ' ', 'w', 'i', 't', 'h', ' ', 'G', 'I', 'M', 0)
+	gifData = append(gifData, 0x21, 0x01, 2, 0xAA, 0xBB, 0)
+	gifData = append(gifData, base[screenEnd+3:]...)
+
+	md, err := MetadataFromBytes(gifData)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["Comment"] != "Created with GIM" {
+		t.Errorf("Comment = %q, want %q", md.Additional["Comment"], "Created with GIM")
+	}
+	if md.Additional["FrameCount"] != 1 {
+		t.Errorf("FrameCount = %v, want 1", md.Additional["FrameCount"])
+	}
+}
+
+// webpChunk encodes a RIFF chunk, padding odd-sized payloads
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// createWebP wraps chunks in a RIFF WEBP container
+func createWebP(chunks ...[]byte) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(webp[4:], uint32(4+len(body)))
+	return append(webp, body...)
+}
+
+// vp8xChunk builds a VP8X chunk for a canvas of the given size
+func vp8xChunk(flags byte, width, height int) []byte {
+	payload := []byte{flags, 0, 0, 0}
+	payload = append(payload, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	payload = append(payload, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+	return webpChunk("VP8X", payload)
+}
+
+func TestMetadata_WebPAnimation(t *testing.T) {
+	anmf := func(durationMS int) []byte {
+		payload := make([]byte, 16)
+		payload[6], payload[9] = 99, 49 // 100x50 frame
+		payload[12], payload[13], payload[14] = byte(durationMS), byte(durationMS>>8), byte(durationMS>>16)
+		// Odd-sized frame data exercises the chunk padding rule
+		payload = append(payload, webpChunk("VP8L", []byte{0x2F, 0, 0, 0})...)
+		return webpChunk("ANMF", append(payload, 0xAB))
+	}
+	webp := createWebP(
+		vp8xChunk(0x12, 400, 300), // alpha, animation
+		webpChunk("ANIM", []byte{0x30, 0x20, 0x10, 0xFF, 2, 0}),
+		anmf(100),
+		anmf(70000),
+		anmf(40),
+	)
+
+	md, err := MetadataFromBytes(webp)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Width != 400 || md.Height != 300 {
+		t.Errorf("Dimensions = %dx%d, want 400x300", md.Width, md.Height)
+	}
+	if md.Additional["HasAnimation"] != true || md.ColorSpace != ColorSpaceRGBA {
+		t.Errorf("HasAnimation = %v, ColorSpace = %v, want true, RGBA", md.Additional["HasAnimation"], md.ColorSpace)
+	}
+	if md.Additional["FrameCount"] != 3 || md.Additional["Duration"] != 70140 || md.Additional["LoopCount"] != 2 {
+		t.Errorf("FrameCount, Duration, LoopCount = %v, %v, %v, want 3, 70140, 2",
+			md.Additional["FrameCount"], md.Additional["Duration"], md.Additional["LoopCount"])
+	}
+	if !md.IsAnimated || md.FrameCount != 3 || md.Duration != 70140*time.Millisecond || md.LoopCount != 2 {
+		t.Errorf("Animation fields = %v, %d, %v, %d, want true, 3, 1m10.14s, 2", md.IsAnimated, md.FrameCount, md.Duration, md.LoopCount)
+	}
+	durations, _ := md.Additional["FrameDurations"].([]int)
+	if len(durations) != 3 || durations[0] != 100 || durations[1] != 70000 || durations[2] != 40 {
+		t.Errorf("FrameDurations = %v, want [100 70000 40]", md.Additional["FrameDurations"])
+	}
+	if md.Additional["BackgroundColor"] != (color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF}) {
+		t.Errorf("BackgroundColor = %v, want {16 32 48 255}", md.Additional["BackgroundColor"])
+	}
+}
+
+func TestMetadata_WebPSimple(t *testing.T) {
+	vp8 := webpChunk("VP8 ", []byte{0x10, 0x02, 0x00, 0x9D, 0x01, 0x2A, 0x40, 0x01, 0xF0, 0x00})
+	// VP8L: width-1 = 319, height-1 = 239, alpha_is_used
+	bits := uint32(319) | uint32(239)<<14 | 1<<28
+	vp8l := webpChunk("VP8L", binary.LittleEndian.AppendUint32([]byte{0x2F}, bits))
+
+	tests := []struct {
+		name       string
+		webp       []byte
+		colorSpace ColorSpace
+	}{
+		{"lossy", createWebP(vp8), ColorSpaceRGB},
+		{"lossless", createWebP(vp8l), ColorSpaceRGBA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.webp)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.Width != 320 || md.Height != 240 || md.ColorSpace != tt.colorSpace {
+				t.Errorf("Got %dx%d %v, want 320x240 %v", md.Width, md.Height, md.ColorSpace, tt.colorSpace)
+			}
+		})
+	}
+}
+
+func TestMetadata_WebPMetadataChunks(t *testing.T) {
+	vp8 := webpChunk("VP8 ", []byte{0x10, 0x02, 0x00, 0x9D, 0x01, 0x2A, 0x40, 0x01, 0xF0, 0x00})
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6)}})
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="4"/></rdf:RDF></x:xmpmeta>!`)
+
+	webp := createWebP(
+		vp8xChunk(0x2C, 320, 240), // ICC, EXIF, XMP
+		webpChunk("ICCP", createICCProfile("sRGB")),
+		vp8,
+		webpChunk("XMP ", xmp),
+		webpChunk("EXIF", tiff),
+	)
+
+	md, err := MetadataFromBytes(webp)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Width != 320 || md.Height != 240 {
+		t.Errorf("Dimensions = %dx%d, want 320x240", md.Width, md.Height)
+	}
+	if md.EXIF["Make"] != "Canon" || md.EXIF["Orientation"] != uint16(6) {
+		t.Errorf("EXIF Make, Orientation = %v, %v, want Canon, 6", md.EXIF["Make"], md.EXIF["Orientation"])
+	}
+	if xmpProps, _ := md.Additional["XMP"].(map[string]interface{}); xmpProps["xmp:Rating"] != 4 {
+		t.Errorf("XMP = %v, want xmp:Rating 4", md.Additional["XMP"])
+	}
+	if md.Additional["HasXMP"] != true {
+		t.Errorf("HasXMP = %v, want true", md.Additional["HasXMP"])
+	}
+	if icc, ok := md.Additional["ICCProfile"].(formats.ICCProfile); !ok || icc.Description != "sRGB" || !md.HasICCProfile {
+		t.Errorf("ICCProfile = %v, HasICCProfile = %v, want sRGB profile", md.Additional["ICCProfile"], md.HasICCProfile)
+	}
+
+	// A chunk size past the end of the file is rejected before the payload
+	// is allocated, keeping what was read before it
+	truncated := createWebP(vp8xChunk(0x08, 320, 240), webpChunk("EXIF", tiff))
+	binary.LittleEndian.PutUint32(truncated[len(truncated)-len(tiff)-4:], 15<<20)
+	md, err = MetadataFromBytes(truncated)
+	if !errors.Is(err, formats.ErrInvalidData) {
+		t.Fatalf("truncated EXIF: MetadataFromBytes() error = %v, want ErrInvalidData", err)
+	}
+	if md == nil || md.Width != 320 || len(md.EXIF) != 0 {
+		t.Errorf("truncated EXIF: metadata = %+v, want 320 wide with no EXIF", md)
+	}
+
+	// The VP8X flag alone does not put anything under XMP
+	md, err = MetadataFromBytes(createWebP(vp8xChunk(0x04, 320, 240), vp8))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["XMP"]; ok || md.Additional["HasXMP"] != true {
+		t.Errorf("XMP, HasXMP = %v, %v, want no XMP and HasXMP true", md.Additional["XMP"], md.Additional["HasXMP"])
+	}
+}
+
+// createBMPWithBitfields builds a BI_BITFIELDS BMP whose header is dibSize
+// bytes long; masks are written right after the 40-byte BITMAPINFOHEADER fields
+func createBMPWithBitfields(dibSize uint32, bitsPerPixel uint16, masks ...uint32) []byte {
+	bmp := createMinimalBMP()
+	binary.LittleEndian.PutUint32(bmp[14:], dibSize)
+	binary.LittleEndian.PutUint16(bmp[28:], bitsPerPixel)
+	binary.LittleEndian.PutUint32(bmp[30:], 3) // BI_BITFIELDS
+	for _, m := range masks {
+		bmp = binary.LittleEndian.AppendUint32(bmp, m)
+	}
+	if extra := 14 + int(dibSize) - len(bmp); extra > 0 {
+		bmp = append(bmp, make([]byte, extra)...)
+	}
+	return bmp
+}
+
+func TestMetadata_BMPBitfields(t *testing.T) {
+	tests := []struct {
+		name       string
+		bmp        []byte
+		layout     string
+		alphaMask  uint32
+		colorSpace ColorSpace
+	}{
+		{"16-bit 565", createBMPWithBitfields(40, 16, 0xF800, 0x07E0, 0x001F), "R5G6B5", 0, ColorSpaceRGB},
+		{"16-bit 555", createBMPWithBitfields(40, 16, 0x7C00, 0x03E0, 0x001F), "R5G5B5", 0, ColorSpaceRGB},
+		{"32-bit V4 with alpha", createBMPWithBitfields(108, 32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0xFF000000), "R8G8B8A8", 0xFF000000, ColorSpaceRGBA},
+		{"32-bit V4 without alpha", createBMPWithBitfields(108, 32, 0x00FF0000, 0x0000FF00, 0x000000FF, 0), "R8G8B8", 0, ColorSpaceRGB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.bmp)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.Additional["BitfieldLayout"] != tt.layout || md.ColorSpace != tt.colorSpace {
+				t.Errorf("BitfieldLayout, ColorSpace = %v, %v, want %s, %v", md.Additional["BitfieldLayout"], md.ColorSpace, tt.layout, tt.colorSpace)
+			}
+			if md.Additional["AlphaMask"] != tt.alphaMask {
+				t.Errorf("AlphaMask = %#x, want %#x", md.Additional["AlphaMask"], tt.alphaMask)
+			}
+		})
+	}
+}
+
+func TestMetadata_BMPColorSpace(t *testing.T) {
+	le := binary.LittleEndian
+	profile := createICCProfile("Adobe RGB")
+
+	v5 := createMinimalBMP()
+	v5 = append(v5, make([]byte, 124-40)...)
+	le.PutUint32(v5[14:], 124)
+	le.PutUint32(v5[14+56:], 0x4D424544) // PROFILE_EMBEDDED
+	le.PutUint32(v5[14+108:], 4)         // LCS_GM_IMAGES
+	le.PutUint32(v5[14+112:], 124)       // Profile directly after the header
+	le.PutUint32(v5[14+116:], uint32(len(profile)))
+	v5 = append(v5, profile...)
+
+	md, err := MetadataFromBytes(v5)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if !md.HasICCProfile || md.Additional["ColorSpaceType"] != "Embedded Profile" || md.Additional["RenderingIntent"] != "Perceptual" {
+		t.Errorf("HasICCProfile, ColorSpaceType, RenderingIntent = %v, %v, %v, want true, Embedded Profile, Perceptual",
+			md.HasICCProfile, md.Additional["ColorSpaceType"], md.Additional["RenderingIntent"])
+	}
+	if icc, ok := md.Additional["ICCProfile"].(formats.ICCProfile); !ok || icc.Description != "Adobe RGB" {
+		t.Errorf("ICCProfile = %v, want Adobe RGB", md.Additional["ICCProfile"])
+	}
+	if md.Width != 100 || md.Height != 100 {
+		t.Errorf("Dimensions = %dx%d, want 100x100", md.Width, md.Height)
+	}
+
+	// A profile pointer past the end of the file only loses the profile
+	corrupt := append([]byte{}, v5...)
+	le.PutUint32(corrupt[14+116:], 1<<20)
+	var skipped []string
+	md, err = MetadataFromBytes(corrupt, WithLogger(func(event string, _ ...interface{}) {
+		skipped = append(skipped, event)
+	}))
+	if err != nil {
+		t.Fatalf("corrupt profile: MetadataFromBytes() error = %v", err)
+	}
+	if md.Width != 100 || md.Height != 100 || !md.HasICCProfile || md.Additional["ICCProfile"] != nil {
+		t.Errorf("corrupt profile: %dx%d, HasICCProfile = %v, ICCProfile = %v, want 100x100 with no profile",
+			md.Width, md.Height, md.HasICCProfile, md.Additional["ICCProfile"])
+	}
+	if len(skipped) != 1 || skipped[0] != "skipped BMP color profile" {
+		t.Errorf("corrupt profile: logged %v, want the skipped profile", skipped)
+	}
+
+	// Calibrated RGB V4 header with 16.16 gamma values
+	v4 := createMinimalBMP()
+	v4 = append(v4, make([]byte, 108-40)...)
+	le.PutUint32(v4[14:], 108)
+	le.PutUint32(v4[14+96:], 0x00023333) // ~2.2
+	le.PutUint32(v4[14+100:], 0x00020000)
+	le.PutUint32(v4[14+104:], 0x00010000)
+
+	md, err = MetadataFromBytes(v4)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["ColorSpaceType"] != "Calibrated RGB" || md.HasICCProfile {
+		t.Errorf("ColorSpaceType = %v, HasICCProfile = %v, want Calibrated RGB, false", md.Additional["ColorSpaceType"], md.HasICCProfile)
+	}
+	if gamma, _ := md.Additional["GammaRed"].(float64); gamma < 2.19 || gamma > 2.21 || md.Additional["GammaGreen"] != 2.0 || md.Additional["GammaBlue"] != 1.0 {
+		t.Errorf("Gamma = %v/%v/%v, want 2.2/2/1", md.Additional["GammaRed"], md.Additional["GammaGreen"], md.Additional["GammaBlue"])
+	}
+}
+
+// createPNGWithChunk inserts a chunk after IHDR
+func createPNGWithChunk(chunkType string, data []byte) []byte {
+	return insertPNGChunk(createMinimalPNG(), chunkType, data)
+}
+
+// insertPNGChunk inserts a chunk before the trailing IEND chunk
+func insertPNGChunk(png []byte, chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = append(chunk, 0, 0, 0, 0) // CRC (dummy)
+
+	iend := len(png) - 12
+	return append(append(png[:iend:iend], chunk...), png[iend:]...)
+}
+
+func TestMetadata_PNGPhysicalDimensions(t *testing.T) {
+	phys := func(x, y uint32, unit byte) []byte {
+		data := binary.BigEndian.AppendUint32(nil, x)
+		data = binary.BigEndian.AppendUint32(data, y)
+		return append(data, unit)
+	}
+
+	md, err := MetadataFromBytes(createPNGWithChunk("pHYs", phys(11811, 2835, 1)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["DPIX"] != 300 || md.Additional["DPIY"] != 72 {
+		t.Errorf("DPI = %vx%v, want 300x72", md.Additional["DPIX"], md.Additional["DPIY"])
+	}
+
+	// Unknown unit: only the aspect ratio is meaningful
+	md, err = MetadataFromBytes(createPNGWithChunk("pHYs", phys(2, 1, 0)))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["DPIX"]; ok {
+		t.Errorf("DPIX = %v, want unset", md.Additional["DPIX"])
+	}
+	if md.Additional["PixelAspectRatio"] != 0.5 {
+		t.Errorf("PixelAspectRatio = %v, want 0.5", md.Additional["PixelAspectRatio"])
+	}
+}
+
+func TestMetadata_PNGText(t *testing.T) {
+	deflate := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write([]byte(s))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	png := createPNGWithChunk("tEXt", []byte("Software\x00GIMP 2.10"))
+	png = insertPNGChunk(png, "zTXt", append([]byte("parameters\x00\x00"), deflate("steps: 20, sampler: Euler a")...))
+	png = insertPNGChunk(png, "iTXt", []byte("Title\x00\x00\x00fr\x00Titre\x00Café"))
+	png = insertPNGChunk(png, "iTXt", append([]byte("Description\x00\x01\x00\x00\x00"), deflate("Ünïcode text")...))
+	// Decompresses past the cap and is dropped
+	png = insertPNGChunk(png, "zTXt", append([]byte("bomb\x00\x00"), deflate(strings.Repeat("a", 4<<20+1))...))
+
+	md, err := MetadataFromBytes(png)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+
+	texts, ok := md.Additional["Text"].(map[string]string)
+	if !ok {
+		t.Fatalf("Text = %v, want map[string]string", md.Additional["Text"])
+	}
+	want := map[string]string{
+		"Software":    "GIMP 2.10",
+		"parameters":  "steps: 20, sampler: Euler a",
+		"Title-fr":    "Café",
+		"Description": "Ünïcode text",
+	}
+	if len(texts) != len(want) {
+		t.Errorf("Text = %v, want %v", texts, want)
+	}
+	for k, v := range want {
+		if texts[k] != v {
+			t.Errorf("Text[%q] = %q, want %q", k, texts[k], v)
+		}
+	}
+	if translated, _ := md.Additional["TextTranslatedKeywords"].(map[string]string); translated["Title-fr"] != "Titre" {
+		t.Errorf("TextTranslatedKeywords = %v, want Title-fr: Titre", md.Additional["TextTranslatedKeywords"])
+	}
+}
+
+func TestMetadata_PNGTransparency(t *testing.T) {
+	// Truecolor: one transparent RGB color and an RGB background
+	png := createPNGWithChunk("tRNS", []byte{0, 0xFF, 0, 0x80, 0, 0})
+	png = insertPNGChunk(png, "bKGD", []byte{0, 1, 0, 2, 0, 3})
+	md, err := MetadataFromBytes(png)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["HasTransparency"] != true {
+		t.Errorf("HasTransparency = %v, want true", md.Additional["HasTransparency"])
+	}
+	if got, _ := md.Additional["TransparentColor"].([]uint16); len(got) != 3 || got[0] != 255 || got[1] != 128 || got[2] != 0 {
+		t.Errorf("TransparentColor = %v, want [255 128 0]", md.Additional["TransparentColor"])
+	}
+	if got, _ := md.Additional["BackgroundColor"].([]uint16); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("BackgroundColor = %v, want [1 2 3]", md.Additional["BackgroundColor"])
+	}
+
+	// Indexed: per-entry palette alpha and a background palette index
+	png = createPNGWithChunk("tRNS", []byte{0x00, 0x80})
+	png = insertPNGChunk(png, "bKGD", []byte{5})
+	png[25] = 3 // Color type
+	md, err = MetadataFromBytes(png)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if got, _ := md.Additional["PaletteAlpha"].([]byte); !bytes.Equal(got, []byte{0x00, 0x80}) || md.Additional["HasTransparency"] != true {
+		t.Errorf("PaletteAlpha = %v, HasTransparency = %v, want [0 128], true", md.Additional["PaletteAlpha"], md.Additional["HasTransparency"])
+	}
+	if md.Additional["BackgroundColorIndex"] != 5 {
+		t.Errorf("BackgroundColorIndex = %v, want 5", md.Additional["BackgroundColorIndex"])
+	}
+
+	// Without tRNS only an alpha color type is transparent
+	md, err = MetadataFromBytes(createMinimalPNG())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["HasTransparency"] != false {
+		t.Errorf("HasTransparency = %v, want false", md.Additional["HasTransparency"])
+	}
+}
+
+func TestMetadata_PNGColorDepth(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitDepth  byte
+		colorType byte
+		want      int
+	}{
+		{"1-bit grayscale", 1, 0, 1},
+		{"8-bit RGB", 8, 2, 24},
+		{"8-bit indexed", 8, 3, 8},
+		{"8-bit grayscale alpha", 8, 4, 16},
+		{"16-bit RGBA", 16, 6, 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			png := createMinimalPNG()
+			png[24], png[25] = tt.bitDepth, tt.colorType
+			md, err := MetadataFromBytes(png)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.ColorDepth != tt.want || md.Additional["BitDepth"] != int(tt.bitDepth) {
+				t.Errorf("ColorDepth, BitDepth = %d, %v, want %d, %d", md.ColorDepth, md.Additional["BitDepth"], tt.want, tt.bitDepth)
+			}
+		})
+	}
+}
+
+func TestMetadata_APNG(t *testing.T) {
+	actl := binary.BigEndian.AppendUint32(nil, 12)
+	actl = binary.BigEndian.AppendUint32(actl, 0)
+	apng := createPNGWithChunk("acTL", actl)
+	apng = insertPNGChunk(apng, "IDAT", []byte{0x78, 0x9C, 0x03, 0x00})
+	apng = insertPNGChunk(apng, "tEXt", []byte("Software\x00after IDAT"))
+
+	md, err := MetadataFromBytes(apng)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["IsAnimated"] != true || md.Additional["FrameCount"] != 12 || md.Additional["LoopCount"] != 0 {
+		t.Errorf("IsAnimated, FrameCount, LoopCount = %v, %v, %v, want true, 12, 0",
+			md.Additional["IsAnimated"], md.Additional["FrameCount"], md.Additional["LoopCount"])
+	}
+	// Chunks after the skipped image data are still read
+	if texts, _ := md.Additional["Text"].(map[string]string); texts["Software"] != "after IDAT" {
+		t.Errorf("Text = %v, want Software after IDAT", md.Additional["Text"])
+	}
+
+	// A real static PNG reports no animation
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	md, err = MetadataFromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["IsAnimated"]; ok || md.Width != 3 || md.Height != 2 {
+		t.Errorf("Static PNG: IsAnimated = %v, %dx%d, want unset, 3x2", md.Additional["IsAnimated"], md.Width, md.Height)
+	}
+}
+
+func TestExtract_PNGStopAtImageData(t *testing.T) {
+	data := createPNGWithChunk("pHYs", []byte{0, 0, 0x0B, 0x13, 0, 0, 0x0B, 0x13, 1})
+	data = insertPNGChunk(data, "IDAT", []byte{0x78, 0x9C, 0x03, 0x00})
+	data = insertPNGChunk(data, "tEXt", []byte("Software\x00after IDAT"))
+
+	res, err := formats.ExtractWithOptions("PNG", bytes.NewReader(data), formats.Options{StopAtImageData: true})
+	if err != nil {
+		t.Fatalf("ExtractWithOptions(PNG) error = %v", err)
+	}
+	if res.Width != 100 || res.Additional["DPIX"] != 72 {
+		t.Errorf("Width, DPIX = %d, %v, want 100, 72", res.Width, res.Additional["DPIX"])
+	}
+	if _, ok := res.Additional["Text"]; ok {
+		t.Errorf("Text = %v, want unset after stopping at IDAT", res.Additional["Text"])
+	}
+
+	res, err = formats.ExtractWithOptions("PNG", bytes.NewReader(data), formats.Options{})
+	if err != nil {
+		t.Fatalf("ExtractWithOptions(PNG) error = %v", err)
+	}
+	if _, ok := res.Additional["Text"]; !ok {
+		t.Error("Text missing with the default options")
+	}
+}
+
+// createLargePNG encodes a noisy image into a multi-megabyte PNG split across many IDAT chunks
+func createLargePNG(b *testing.B) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 1500, 1500))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkExtractPNG(b *testing.B) {
+	data := createLargePNG(b)
+	b.Logf("PNG size: %d bytes", len(data))
+
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"FullScan", nil},
+		{"StopAtImageData", []Option{WithStopAtImageData()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := MetadataFromBytes(data, bm.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// cancelAfterReader cancels a context once n bytes have been read through it
+type cancelAfterReader struct {
+	r      io.ReadSeeker
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfterReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.n -= n; c.n <= 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func (c *cancelAfterReader) Seek(offset int64, whence int) (int64, error) {
+	return c.r.Seek(offset, whence)
+}
+
+func TestMetadata_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := MetadataFromReaderContext(ctx, bytes.NewReader(createMinimalPNG())); !errors.Is(err, context.Canceled) {
+		t.Errorf("MetadataFromReaderContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := MetadataFromFileContext(ctx, "testdata/missing.png"); err == nil {
+		t.Error("MetadataFromFileContext() on a missing file succeeded")
+	}
+
+	// Cancelling mid-parse discards the partial result; detection reads the
+	// whole file first, then the parser gets through SOI and one segment header
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	jpeg := createJPEGWithSegments(jpegSegment(0xFE, []byte("first")), jpegSegment(0xFE, []byte("second")))
+	rs := &cancelAfterReader{r: bytes.NewReader(jpeg), n: len(jpeg) + 6, cancel: cancel}
+	if _, err := metadataFromSeeker(ctx, rs, int64(len(jpeg)), options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("metadataFromSeeker() after cancel error = %v, want context.Canceled", err)
+	}
+
+	md, err := MetadataFromReaderContext(context.Background(), bytes.NewReader(createMinimalPNG()))
+	if err != nil || md.Format != FormatPNG {
+		t.Errorf("MetadataFromReaderContext() = %v, %v, want PNG", md, err)
+	}
+}
+
+func TestMetadataFromURLContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			// Send part of the body, then stall until the client goes away
+			w.Write(createMinimalPNG()[:16])
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		w.Write(createMinimalPNG())
+	}))
+	defer server.Close()
+
+	md, err := MetadataFromURLContext(context.Background(), server.URL+"/image.png")
+	if err != nil || md.Format != FormatPNG {
+		t.Fatalf("MetadataFromURLContext() = %v, %v, want PNG", md, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = MetadataFromURLContext(ctx, server.URL+"/slow")
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrFetchFailed) {
+		t.Errorf("MetadataFromURLContext() error = %v, want ErrFetchFailed and context.DeadlineExceeded", err)
+	}
+}
+
+func TestMetadata_Options(t *testing.T) {
+	png := createMinimalPNG()
+
+	// WithMaxBytes applies to every entry point
+	if _, err := MetadataFromBytes(png, WithMaxBytes(int64(len(png)-1))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("MetadataFromBytes() over the limit error = %v, want ErrTooLarge", err)
+	}
+	if _, err := MetadataFromReader(bytes.NewReader(png), WithMaxBytes(16)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("MetadataFromReader() over the limit error = %v, want ErrTooLarge", err)
+	}
+	if md, err := MetadataFromReader(bytes.NewReader(png), WithMaxBytes(int64(len(png)))); err != nil || md.Format != FormatPNG {
+		t.Errorf("MetadataFromReader() at the limit = %v, %v, want PNG", md, err)
+	}
+
+	// The hint is only used when detection fails
+	noFooter := createMinimalTGA()[:18]
+	md, err := MetadataFromBytes(noFooter, WithFormatHint(FormatTGA))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() with TGA hint error = %v", err)
+	}
+	if md.Format != FormatTGA || md.Width != 64 || md.Height != 32 {
+		t.Errorf("Hinted TGA = %v %dx%d, want TGA 64x32", md.Format, md.Width, md.Height)
+	}
+	if md, err := MetadataFromBytes(png, WithFormatHint(FormatTGA)); err != nil || md.Format != FormatPNG {
+		t.Errorf("MetadataFromBytes() with a wrong hint = %v, %v, want PNG", md, err)
+	}
+
+	// WithoutEXIF leaves EXIF undecoded
+	tiff := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0112, 6)}})
+	jpeg := createJPEGWithEXIF(tiff)
+	if md, err := MetadataFromBytes(jpeg); err != nil || len(md.EXIF) == 0 {
+		t.Fatalf("MetadataFromBytes() EXIF = %v, %v, want tags", md, err)
+	}
+	md, err = MetadataFromBytes(jpeg, WithoutEXIF())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() WithoutEXIF error = %v", err)
+	}
+	if len(md.EXIF) != 0 || md.rawEXIF != nil {
+		t.Errorf("EXIF = %v, want none", md.EXIF)
+	}
+	if _, err := md.Thumbnail(); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("Thumbnail() error = %v, want ErrNoThumbnail", err)
+	}
+
+	// The skipped EXIF segment does not hide an XMP segment after it, and
+	// a PNG eXIf chunk is skipped the same way
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description xmp:Rating="3" xmlns:xmp="http://ns.adobe.com/xap/1.0/"/></rdf:RDF></x:xmpmeta>`
+	jpeg = createJPEGWithSegments(
+		jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...)),
+		jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)),
+	)
+	md, err = MetadataFromBytes(jpeg, WithoutEXIF())
+	if err != nil || len(md.EXIF) != 0 || md.Additional["XMP"] == nil || md.Width != 100 {
+		t.Errorf("WithoutEXIF with XMP = %v, %v, want XMP and no EXIF", md, err)
+	}
+	md, err = MetadataFromBytes(createPNGWithChunk("eXIf", tiff), WithoutEXIF())
+	if err != nil || len(md.EXIF) != 0 || md.Width != 100 {
+		t.Errorf("WithoutEXIF PNG = %v, %v, want no EXIF", md, err)
+	}
+}
+
+func TestMetadata_LazyEXIF(t *testing.T) {
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"),
+		shortEntry(0x0112, 6),
+		rationalEntry(0x011A, 300, 1),
+		rationalEntry(0x011B, 300, 1),
+	}})
+
+	md, err := MetadataFromBytes(createJPEGWithSOFAndEXIF(tiff), WithLazyEXIF())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if len(md.EXIF) != 0 || md.Orientation != OrientationUnknown || md.DPIX != 0 {
+		t.Errorf("before use: EXIF = %v, Orientation = %v, DPIX = %v, want nothing decoded", md.EXIF, md.Orientation, md.DPIX)
+	}
+
+	// Concurrent first uses decode once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, _ := md.EXIFString("Make"); got != "Canon" {
+				t.Errorf("EXIFString(Make) = %q, want Canon", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if md.EXIF["Make"] != "Canon" || md.Orientation != OrientationRotate90 || md.DPIX != 300 || md.DPIY != 300 {
+		t.Errorf("after use: EXIF = %v, Orientation = %v, DPI = %vx%v, want Canon, 6, 300x300", md.EXIF, md.Orientation, md.DPIX, md.DPIY)
+	}
+
+	// FlatMap reports the derived fields of the block it decodes
+	md, err = MetadataFromBytes(createPNGWithChunk("eXIf", tiff), WithLazyEXIF(), WithGroupedEXIF())
+	if err != nil {
+		t.Fatalf("PNG: MetadataFromBytes() error = %v", err)
+	}
+	flat := md.FlatMap()
+	if flat["EXIF.IFD0.Make"] != "Canon" || flat["Orientation"] != "6" {
+		t.Errorf("PNG: FlatMap() EXIF.IFD0.Make, Orientation = %q, %q, want Canon, 6", flat["EXIF.IFD0.Make"], flat["Orientation"])
+	}
+
+	// Without the option LoadEXIF returns what extraction decoded
+	md, err = MetadataFromBytes(createJPEGWithSOFAndEXIF(tiff))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if exif := md.LoadEXIF(); exif["Make"] != "Canon" || md.Orientation != OrientationRotate90 {
+		t.Errorf("LoadEXIF() = %v, Orientation = %v, want Canon, 6", exif, md.Orientation)
+	}
+}
+
+func TestMetadata_StrictOption(t *testing.T) {
+	jpeg := createJPEGWithSOF(0xC0, 8, 0x22, 0x11, 0x11)
+	if _, err := MetadataFromBytes(jpeg, WithStrict(true)); err != nil {
+		t.Fatalf("MetadataFromBytes() strict on a complete JPEG error = %v", err)
+	}
+
+	// Cutting off EOI is tolerated by default but not in strict mode
+	truncated := jpeg[:len(jpeg)-2]
+	if _, err := MetadataFromBytes(truncated); err != nil {
+		t.Errorf("MetadataFromBytes() on a truncated JPEG error = %v", err)
+	}
+	if _, err := MetadataFromBytes(truncated, WithStrict(true)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() strict on a truncated JPEG error = %v, want ErrInvalidData", err)
+	}
+
+	png := createMinimalPNG()
+	if _, err := MetadataFromBytes(png, WithStrict(true)); err != nil {
+		t.Fatalf("MetadataFromBytes() strict on a complete PNG error = %v", err)
+	}
+	if _, err := MetadataFromBytes(png[:len(png)-12], WithStrict(true)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() strict without IEND error = %v, want ErrInvalidData", err)
+	}
+
+	// Unknown dimensions are rejected
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="50%"></svg>`)
+	if _, err := MetadataFromBytes(svg); err != nil {
+		t.Errorf("MetadataFromBytes() on an unsized SVG error = %v", err)
+	}
+	if _, err := MetadataFromBytes(svg, WithStrict(true)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() strict on an unsized SVG error = %v, want ErrInvalidData", err)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMetadataFromURL_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(createMinimalPNG())
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	md, err := MetadataFromURL(server.URL, WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil || md.Format != FormatPNG {
+		t.Fatalf("MetadataFromURL() = %v, %v, want PNG", md, err)
+	}
+	if transport.requests != 1 {
+		t.Errorf("Custom client requests = %d, want 1", transport.requests)
+	}
+
+	if _, err := MetadataFromURL(server.URL, WithMaxBytes(16)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("MetadataFromURL() over the limit error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestMetadataFromURL_FetchLimit(t *testing.T) {
+	// A PNG followed by a megabyte of trailing data
+	large := append(createMinimalPNG(), make([]byte, 1<<20)...)
+	// A JPEG whose frame header sits behind a large comment
+	late := createJPEGWithSegments(jpegSegment(0xFE, bytes.Repeat([]byte("x"), 4096)))
+
+	var ranges []string
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch r.URL.Path {
+		case "/ranged.png":
+			http.ServeContent(w, r, "ranged.png", time.Time{}, bytes.NewReader(large))
+		case "/late.jpg":
+			http.ServeContent(w, r, "late.jpg", time.Time{}, bytes.NewReader(late))
+		default:
+			// Ignore Range and stream everything
+			n, _ := w.Write(large)
+			sent = n
+		}
+	}))
+	defer server.Close()
+
+	md, err := MetadataFromURL(server.URL+"/ranged.png", WithFetchLimit(1024))
+	if err != nil || md.Format != FormatPNG || md.Width != 100 || md.FileSize != int64(len(large)) {
+		t.Fatalf("MetadataFromURL() ranged = %v, %v, want PNG", md, err)
+	}
+	if ranges[0] != "bytes=0-1024" {
+		t.Errorf("Range = %q, want bytes=0-1024", ranges[0])
+	}
+
+	md, err = MetadataFromURL(server.URL+"/plain.png", WithFetchLimit(1024))
+	if err != nil || md.Format != FormatPNG {
+		t.Errorf("MetadataFromURL() without Range support = %v, %v, want PNG", md, err)
+	}
+	if sent != len(large) {
+		t.Errorf("Server sent %d bytes, want %d", sent, len(large))
+	}
+
+	if _, err := MetadataFromURL(server.URL+"/late.jpg", WithFetchLimit(512)); !errors.Is(err, ErrFetchLimit) {
+		t.Errorf("MetadataFromURL() with metadata past the limit error = %v, want ErrFetchLimit", err)
+	}
+	md, err = MetadataFromURL(server.URL+"/late.jpg", WithFetchLimit(0))
+	if err != nil || md.Width != 100 {
+		t.Errorf("MetadataFromURL() without a limit = %v, %v, want a 100px JPEG", md, err)
+	}
+	if last := ranges[len(ranges)-1]; last != "" {
+		t.Errorf("Range without a limit = %q, want none", last)
+	}
+
+	// WithMaxBytes still rejects the complete size reported by the server
+	if _, err := MetadataFromURL(server.URL+"/ranged.png", WithMaxBytes(4096)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("MetadataFromURL() with total over WithMaxBytes error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestMetadata_ChunkLengthGuard(t *testing.T) {
+	// A chunk header claiming 2 GiB of data in a tiny file
+	png := createMinimalPNG()
+	crafted := append(png[:33:33], 0x7F, 0xFF, 0xFF, 0xF0, 't', 'E', 'X', 't')
+	if _, err := MetadataFromBytes(crafted); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() with a 2 GiB chunk error = %v, want ErrInvalidData", err)
+	}
+	if _, err := formats.ExtractPNG(bytes.NewReader(crafted)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("ExtractPNG() with a 2 GiB chunk error = %v, want ErrInvalidData", err)
+	}
+
+	// A segment length pointing past the end of the file
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'E', 'x', 'i', 'f', 0, 0}
+	if _, err := MetadataFromBytes(jpeg); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() with an overlong segment error = %v, want ErrInvalidData", err)
+	}
+
+	// The absolute cap is configurable
+	text := createPNGWithChunk("tEXt", []byte("Comment\x00"+strings.Repeat("x", 64)))
+	if _, err := MetadataFromBytes(text); err != nil {
+		t.Errorf("MetadataFromBytes() error = %v", err)
+	}
+	if _, err := MetadataFromBytes(text, WithMaxChunkSize(32)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() over WithMaxChunkSize error = %v, want ErrInvalidData", err)
+	}
+
+	// WebP metadata chunks and AVIF boxes are held to the same limits
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, strings.Repeat("x", 64))}})
+	webp := createWebP(vp8xChunk(0x08, 320, 240), webpChunk("EXIF", tiff))
+	if _, err := MetadataFromBytes(webp); err != nil {
+		t.Errorf("MetadataFromBytes(WebP) error = %v", err)
+	}
+	if _, err := MetadataFromBytes(webp, WithMaxChunkSize(32)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes(WebP) over WithMaxChunkSize error = %v, want ErrInvalidData", err)
+	}
+	avif := createMinimalAVIF("avif")
+	if _, err := MetadataFromBytes(avif, WithMaxChunkSize(32)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes(AVIF) over WithMaxChunkSize error = %v, want ErrInvalidData", err)
+	}
+	// A meta box claiming 15 MiB in a short file
+	crafted = append(makeBox("ftyp", []byte("avif"), []byte{0, 0, 0, 0}, []byte("mif1")), 0x00, 0xF0, 0x00, 0x00, 'm', 'e', 't', 'a', 0, 0, 0, 0)
+	if _, err := MetadataFromBytes(crafted); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes(AVIF) with a 15 MiB box error = %v, want ErrInvalidData", err)
+	}
+	if _, err := formats.ExtractAVIF(bytes.NewReader(crafted)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("ExtractAVIF() with a 15 MiB box error = %v, want ErrInvalidData", err)
+	}
+}
+
+func TestMetadataBatch(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.png": createMinimalPNG(),
+		"b.jpg": createMinimalJPEG(),
+		"c.bin": []byte("not an image"),
+		"d.gif": createMinimalGIF(),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(dir+"/"+name, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{dir + "/d.gif", dir + "/c.bin", dir + "/a.png", dir + "/missing.png", dir + "/b.jpg"}
+
+	results, err := MetadataBatch(paths, 2)
+	if err != nil {
+		t.Fatalf("MetadataBatch() error = %v", err)
+	}
+	if len(results) != len(paths) {
+		t.Fatalf("len(results) = %d, want %d", len(results), len(paths))
+	}
+	want := []Format{FormatGIF, "", FormatPNG, "", FormatJPEG}
+	for i, r := range results {
+		if r.Path != paths[i] {
+			t.Errorf("results[%d].Path = %s, want %s", i, r.Path, paths[i])
+		}
+		if want[i] == "" {
+			if r.Err == nil || r.Metadata != nil {
+				t.Errorf("results[%d] = %v, %v, want an error", i, r.Metadata, r.Err)
+			}
+			continue
+		}
+		if r.Err != nil || r.Metadata.Format != want[i] {
+			t.Errorf("results[%d] = %v, %v, want %s", i, r.Metadata, r.Err, want[i])
+		}
+	}
+	if !errors.Is(results[1].Err, ErrUnsupportedFormat) {
+		t.Errorf("results[1].Err = %v, want ErrUnsupportedFormat", results[1].Err)
+	}
+
+	// A cancelled context fails every file that was not processed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = MetadataBatchContext(ctx, paths, 4)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("MetadataBatchContext() error = %v, want context.Canceled", err)
+	}
+	for i, r := range results {
+		if r.Path != paths[i] || r.Err == nil {
+			t.Errorf("results[%d] = %+v, want an error for %s", i, r, paths[i])
+		}
+	}
+
+	if results, err := MetadataBatch(nil, 0); err != nil || len(results) != 0 {
+		t.Errorf("MetadataBatch(nil) = %v, %v, want no results", results, err)
+	}
+}
+
+func TestDetectFormatAPI(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want Format
+	}{
+		{createMinimalJPEG(), FormatJPEG},
+		{createMinimalPNG(), FormatPNG},
+		{createMinimalGIF(), FormatGIF},
+		{createMinimalWebP(), FormatWebP},
+		{createMinimalBMP(), FormatBMP},
+	}
+
+	for _, tt := range tests {
+		got, err := DetectFormat(bytes.NewReader(tt.data))
+		if err != nil || got != tt.want {
+			t.Errorf("DetectFormat() = %v, %v, want %v", got, err, tt.want)
+		}
+		if got := DetectFormatFromBytes(tt.data); got != tt.want {
+			t.Errorf("DetectFormatFromBytes() = %v, want %v", got, tt.want)
+		}
+	}
+
+	if _, err := DetectFormat(strings.NewReader("plain text")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("DetectFormat() on text error = %v, want ErrUnsupportedFormat", err)
+	}
+	if got := DetectFormatFromBytes(nil); got != "" {
+		t.Errorf("DetectFormatFromBytes(nil) = %v, want empty", got)
+	}
+
+	// Footer signatures need the whole input
+	if got := DetectFormatFromBytes(createMinimalTGA()); got != FormatTGA {
+		t.Errorf("DetectFormatFromBytes() TGA = %v, want TGA", got)
+	}
+
+	// A bufio.Reader keeps the peeked header for the caller
+	png := createMinimalPNG()
+	br := bufio.NewReader(bytes.NewReader(png))
+	if got, err := DetectFormat(br); err != nil || got != FormatPNG {
+		t.Fatalf("DetectFormat(bufio.Reader) = %v, %v, want PNG", got, err)
+	}
+	md, err := MetadataFromReader(br)
+	if err != nil || md.Width != 100 {
+		t.Errorf("MetadataFromReader() after DetectFormat = %v, %v, want a 100px PNG", md, err)
+	}
+}
+
+func TestMetadata_NonImageRIFF(t *testing.T) {
+	riff := func(form string) []byte {
+		data := []byte("RIFF\x04\x00\x00\x00" + form)
+		return append(data, make([]byte, 32)...)
+	}
+
+	for _, form := range []string{"AVI ", "WAVE"} {
+		_, err := MetadataFromBytes(riff(form))
+		if !errors.Is(err, ErrUnsupportedFormat) || !errors.Is(err, ErrUnsupportedContainer) {
+			t.Errorf("%s: error = %v, want ErrUnsupportedFormat and ErrUnsupportedContainer", form, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), strings.TrimSpace(form)) {
+			t.Errorf("%s: error %q does not name the form type", form, err)
+		}
+		if _, err := DetectFormat(bytes.NewReader(riff(form))); !errors.Is(err, ErrUnsupportedContainer) {
+			t.Errorf("%s: DetectFormat() error = %v, want ErrUnsupportedContainer", form, err)
+		}
+	}
+
+	// Unknown bytes are not reported as a container
+	if _, err := MetadataFromBytes(make([]byte, 32)); !errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrUnsupportedContainer) {
+		t.Errorf("Unknown bytes error = %v, want only ErrUnsupportedFormat", err)
+	}
+}
+
+func TestImageMetadata_Dimensions(t *testing.T) {
+	tests := []struct {
+		width, height int
+		megapixels    float64
+		ratio         string
+	}{
+		{1920, 1080, 2.0736, "16:9"},
+		{4000, 3000, 12, "4:3"},
+		{1080, 1920, 2.0736, "9:16"},
+		{100, 100, 0.01, "1:1"},
+		{1001, 1000, 1.001, "1001:1000"},
+		{0, 1080, 0, ""},
+		{1920, 0, 0, ""},
+	}
+
+	for _, tt := range tests {
+		md := &ImageMetadata{Width: tt.width, Height: tt.height}
+		if got := md.Megapixels(); got < tt.megapixels-1e-9 || got > tt.megapixels+1e-9 {
+			t.Errorf("%dx%d: Megapixels() = %v, want %v", tt.width, tt.height, got, tt.megapixels)
+		}
+		if got := md.AspectRatioString(); got != tt.ratio {
+			t.Errorf("%dx%d: AspectRatioString() = %q, want %q", tt.width, tt.height, got, tt.ratio)
+		}
+	}
+
+	if w, h := (&ImageMetadata{Width: 1920, Height: 1080}).AspectRatio(); w != 16 || h != 9 {
+		t.Errorf("AspectRatio() = %d, %d, want 16, 9", w, h)
+	}
+}
+
+func TestImageMetadata_DisplayDimensions(t *testing.T) {
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		md := &ImageMetadata{Width: 32, Height: 16, EXIF: map[string]interface{}{"Orientation": orientation}}
+
+		w, h := md.DisplayDimensions()
+		wantW, wantH := 32, 16
+		if orientation >= 5 {
+			wantW, wantH = 16, 32
+		}
+		if w != wantW || h != wantH {
+			t.Errorf("Orientation %d: DisplayDimensions() = %dx%d, want %dx%d", orientation, w, h, wantW, wantH)
+		}
+		if md.Width != 32 || md.Height != 16 {
+			t.Errorf("Orientation %d: stored dimensions changed to %dx%d", orientation, md.Width, md.Height)
+		}
+	}
+
+	// The tag as decoded from a real EXIF block
+	tiff := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0112, 6)}})
+	md, err := MetadataFromBytes(createJPEGWithEXIF(tiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md.Width = 40
+	if w, h := md.DisplayDimensions(); w != 100 || h != 40 {
+		t.Errorf("DisplayDimensions() = %dx%d, want 100x40", w, h)
+	}
+
+	if w, h := (&ImageMetadata{Width: 40, Height: 30}).DisplayDimensions(); w != 40 || h != 30 {
+		t.Errorf("DisplayDimensions() without EXIF = %dx%d, want 40x30", w, h)
+	}
+}
+
+func TestImageMetadata_PrintSize(t *testing.T) {
+	md := &ImageMetadata{Width: 3000, Height: 1800, DPIX: 300, DPIY: 150}
+	tests := []struct {
+		unit Unit
+		w, h float64
+	}{
+		{UnitInch, 10, 12},
+		{UnitCentimeter, 25.4, 30.48},
+		{UnitMillimeter, 254, 304.8},
+	}
+	for _, tt := range tests {
+		w, h, ok := md.PrintSize(tt.unit)
+		if !ok || math.Abs(w-tt.w) > 1e-9 || math.Abs(h-tt.h) > 1e-9 {
+			t.Errorf("PrintSize(%d) = %v, %v, %v, want %v, %v", tt.unit, w, h, ok, tt.w, tt.h)
+		}
+	}
+
+	// A PNG pHYs density goes through DPIX and DPIY
+	phys := binary.BigEndian.AppendUint32(nil, 11811) // 300 DPI in pixels per meter
+	phys = binary.BigEndian.AppendUint32(phys, 11811)
+	png, err := MetadataFromBytes(insertPNGChunk(encodeTestPNG(t), "pHYs", append(phys, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, _, ok := png.PrintSize(UnitMillimeter); !ok || math.Abs(w-float64(png.Width)/11811*1000) > 1e-9 {
+		t.Errorf("PNG PrintSize() width = %v, %v", w, ok)
+	}
+
+	for name, md := range map[string]*ImageMetadata{
+		"no DPI":       {Width: 100, Height: 100},
+		"no width":     {Height: 100, DPIX: 72, DPIY: 72},
+		"unknown unit": {Width: 100, Height: 100, DPIX: 72, DPIY: 72},
+	} {
+		unit := UnitInch
+		if name == "unknown unit" {
+			unit = Unit(99)
+		}
+		if _, _, ok := md.PrintSize(unit); ok {
+			t.Errorf("%s: PrintSize() ok = true", name)
+		}
+	}
+}
+
+func TestImageMetadata_TypedAccessors(t *testing.T) {
+	md := &ImageMetadata{
+		EXIF: map[string]interface{}{
+			"Make":         "Canon",
+			"Orientation":  uint16(6),
+			"FNumber":      Rational{Num: 28, Den: 10},
+			"XResolution":  Rational{Num: 300, Den: 1},
+			"Exposure":     SRational{Num: -2, Den: 3},
+			"Broken":       Rational{Num: 1, Den: 0},
+			"GPSVersionID": []byte{2, 3, 0, 0},
+		},
+		Additional: map[string]interface{}{
+			"BitDepth": 8,
+			"Gamma":    2.2,
+			"Encoding": "Progressive",
+		},
+	}
+
+	if v, ok := md.EXIFString("Make"); !ok || v != "Canon" {
+		t.Errorf("EXIFString(Make) = %q, %v", v, ok)
+	}
+	if v, ok := md.EXIFString("FNumber"); !ok || v != "28/10" {
+		t.Errorf("EXIFString(FNumber) = %q, %v, want 28/10", v, ok)
+	}
+	if _, ok := md.EXIFString("Orientation"); ok {
+		t.Error("EXIFString(Orientation) succeeded for a number")
+	}
+
+	if v, ok := md.EXIFInt("Orientation"); !ok || v != 6 {
+		t.Errorf("EXIFInt(Orientation) = %d, %v, want 6", v, ok)
+	}
+	if v, ok := md.EXIFInt("XResolution"); !ok || v != 300 {
+		t.Errorf("EXIFInt(XResolution) = %d, %v, want 300", v, ok)
+	}
+	for _, key := range []string{"FNumber", "Broken", "Make", "GPSVersionID", "Missing"} {
+		if v, ok := md.EXIFInt(key); ok {
+			t.Errorf("EXIFInt(%s) = %d, want no value", key, v)
+		}
+	}
+
+	if v, ok := md.EXIFFloat("FNumber"); !ok || v != 2.8 {
+		t.Errorf("EXIFFloat(FNumber) = %v, %v, want 2.8", v, ok)
+	}
+	if v, ok := md.EXIFFloat("Exposure"); !ok || v > -0.66 || v < -0.67 {
+		t.Errorf("EXIFFloat(Exposure) = %v, %v, want -0.667", v, ok)
+	}
+	if v, ok := md.EXIFFloat("Orientation"); !ok || v != 6 {
+		t.Errorf("EXIFFloat(Orientation) = %v, %v, want 6", v, ok)
+	}
+	if _, ok := md.EXIFFloat("Broken"); ok {
+		t.Error("EXIFFloat(Broken) succeeded for a zero denominator")
+	}
+
+	if v, ok := md.AdditionalInt("BitDepth"); !ok || v != 8 {
+		t.Errorf("AdditionalInt(BitDepth) = %d, %v, want 8", v, ok)
+	}
+	if v, ok := md.AdditionalFloat("Gamma"); !ok || v != 2.2 {
+		t.Errorf("AdditionalFloat(Gamma) = %v, %v, want 2.2", v, ok)
+	}
+	if _, ok := md.AdditionalInt("Gamma"); ok {
+		t.Error("AdditionalInt(Gamma) succeeded for a fraction")
+	}
+	if v, ok := md.AdditionalString("Encoding"); !ok || v != "Progressive" {
+		t.Errorf("AdditionalString(Encoding) = %q, %v", v, ok)
+	}
+
+	// A nil map behaves like a missing key
+	if _, ok := (&ImageMetadata{}).EXIFInt("Orientation"); ok {
+		t.Error("EXIFInt() on empty metadata succeeded")
+	}
+}
+
+func TestRegisterImageConfig(t *testing.T) {
+	RegisterImageConfig()
+	RegisterImageConfig() // repeated calls are harmless
+
+	webp := createWebP(vp8xChunk(0x10, 300, 200))
+	cfg, name, err := image.DecodeConfig(bytes.NewReader(webp))
+	if err != nil {
+		t.Fatalf("image.DecodeConfig() WebP error = %v", err)
+	}
+	if name != "webp" || cfg.Width != 300 || cfg.Height != 200 || cfg.ColorModel != color.NRGBAModel {
+		t.Errorf("WebP config = %s %dx%d %v, want webp 300x200 NRGBA", name, cfg.Width, cfg.Height, cfg.ColorModel)
+	}
+
+	cfg, name, err = image.DecodeConfig(bytes.NewReader(createMinimalBMP()))
+	if err != nil {
+		t.Fatalf("image.DecodeConfig() BMP error = %v", err)
+	}
+	if name != "bmp" || cfg.Width != 100 || cfg.Height != 100 || cfg.ColorModel != color.RGBAModel {
+		t.Errorf("BMP config = %s %dx%d %v, want bmp 100x100 RGBA", name, cfg.Width, cfg.Height, cfg.ColorModel)
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(webp)); err == nil {
+		t.Error("image.Decode() of WebP pixels succeeded")
+	}
+
+	// Only a prefix of a large stream is read
+	stream := &countingReader{r: io.MultiReader(bytes.NewReader(createMinimalBMP()), zeroReader{})}
+	cfg, _, err = image.DecodeConfig(io.LimitReader(stream, 1<<30))
+	if err != nil || cfg.Width != 100 {
+		t.Fatalf("image.DecodeConfig() of a 1 GiB BMP = %dx%d, %v, want 100x100", cfg.Width, cfg.Height, err)
+	}
+	if stream.n > 5<<20 {
+		t.Errorf("image.DecodeConfig() read %d bytes of a 1 GiB BMP", stream.n)
+	}
+}
+
+// zeroReader is an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestImageMetadata_ColorModel(t *testing.T) {
+	tests := []struct {
+		space ColorSpace
+		depth int
+		want  color.Model
+	}{
+		{ColorSpaceGrayscale, 8, color.GrayModel},
+		{ColorSpaceGrayscale, 16, color.Gray16Model},
+		{ColorSpaceRGB, 24, color.RGBAModel},
+		{ColorSpaceRGB, 48, color.RGBA64Model},
+		{ColorSpaceRGBA, 32, color.NRGBAModel},
+		{ColorSpaceRGBA, 64, color.NRGBA64Model},
+		{ColorSpaceCMYK, 32, color.CMYKModel},
+		{ColorSpaceIndexed, 8, color.RGBAModel},
+	}
+	for _, tt := range tests {
+		md := &ImageMetadata{ColorSpace: tt.space, ColorDepth: tt.depth}
+		if got := md.ColorModel(); got != tt.want {
+			t.Errorf("%s/%d: ColorModel() = %v, want %v", tt.space, tt.depth, got, tt.want)
+		}
+	}
+
+	// A kept palette becomes the model, with PNG tRNS alpha
+	md := &ImageMetadata{ColorSpace: ColorSpaceIndexed, ColorDepth: 8, Additional: map[string]interface{}{
+		"Palette":      [][3]uint8{{255, 0, 0}, {0, 0, 255}},
+		"PaletteAlpha": []byte{0x80},
+	}}
+	palette, ok := md.ColorModel().(color.Palette)
+	if !ok || len(palette) != 2 || palette[0] != (color.NRGBA{R: 255, A: 0x80}) || palette[1] != (color.NRGBA{B: 255, A: 0xFF}) {
+		t.Errorf("ColorModel() = %v, want the two-entry palette", md.ColorModel())
+	}
+}
+
+// readOnlyFile hides the Seek and ReadAt methods of an fs.File
+type readOnlyFile struct {
+	fs.File
+}
+
+type readOnlyFS struct {
+	fs.FS
+}
+
+func (r readOnlyFS) Open(name string) (fs.File, error) {
+	f, err := r.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{f}, nil
+}
+
+func TestMetadataFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"images/a.png": {Data: createMinimalPNG()},
+		"images/b.jpg": {Data: createMinimalJPEG()},
+		"notes.txt":    {Data: []byte("not an image")},
+	}
+
+	for _, fsys := range []fs.FS{fsys, readOnlyFS{fsys}} {
+		md, err := MetadataFromFS(fsys, "images/a.png")
+		if err != nil || md.Format != FormatPNG || md.FileSize != int64(len(createMinimalPNG())) {
+			t.Errorf("MetadataFromFS(%T, a.png) = %v, %v, want PNG", fsys, md, err)
+		}
+		md, err = MetadataFromFS(fsys, "images/b.jpg")
+		if err != nil || md.Format != FormatJPEG || md.Width != 100 {
+			t.Errorf("MetadataFromFS(%T, b.jpg) = %v, %v, want a 100px JPEG", fsys, md, err)
+		}
+		if _, err := MetadataFromFS(fsys, "notes.txt"); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("MetadataFromFS(%T, notes.txt) error = %v, want ErrUnsupportedFormat", fsys, err)
+		}
+		if _, err := MetadataFromFS(fsys, "missing.png"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("MetadataFromFS(%T, missing.png) error = %v, want fs.ErrNotExist", fsys, err)
+		}
+		if _, err := MetadataFromFS(fsys, "images/a.png", WithMaxBytes(8)); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("MetadataFromFS(%T) over the limit error = %v, want ErrTooLarge", fsys, err)
+		}
+	}
+}
+
+func TestMetadata_PartialResult(t *testing.T) {
+	// A segment with an impossible length after the frame header
+	jpeg := createJPEGWithSOF(0xC0, 8, 0x11)
+	jpeg = append(jpeg[:len(jpeg)-2:len(jpeg)-2], 0xFF, 0xE1, 0x00, 0x01)
+	md, err := MetadataFromBytes(jpeg)
+	if !errors.Is(err, ErrPartial) || !errors.Is(err, formats.ErrInvalidData) {
+		t.Fatalf("MetadataFromBytes() error = %v, want ErrPartial and ErrInvalidData", err)
+	}
+	if md == nil || md.Format != FormatJPEG || md.Width != 32 || md.Height != 16 {
+		t.Errorf("Partial metadata = %+v, want a 32x16 JPEG", md)
+	}
+
+	// A chunk claiming more data than the file holds, after IHDR
+	png := createMinimalPNG()
+	crafted := append(png[:33:33], 0x00, 0x10, 0x00, 0x00, 't', 'E', 'X', 't')
+	md, err = MetadataFromBytes(crafted)
+	if !errors.Is(err, ErrPartial) {
+		t.Fatalf("MetadataFromBytes() error = %v, want ErrPartial", err)
+	}
+	if md == nil || md.Width != 100 || md.ColorSpace != ColorSpaceRGB {
+		t.Errorf("Partial metadata = %+v, want a 100px RGB PNG", md)
+	}
+
+	// Strict mode reports truncation as partial too
+	md, err = MetadataFromBytes(png[:len(png)-12], WithStrict(true))
+	if !errors.Is(err, ErrPartial) || md == nil || md.Width != 100 {
+		t.Errorf("Strict truncated PNG = %v, %v, want partial metadata", md, err)
+	}
+
+	// Parsers that fail outright return no metadata
+	md, err = MetadataFromBytes([]byte("GIF89a"))
+	if err == nil || errors.Is(err, ErrPartial) || md != nil {
+		t.Errorf("MetadataFromBytes() = %v, %v, want only an error", md, err)
+	}
+}
+
+func TestMetadataFromURL_DataURI(t *testing.T) {
+	png := createMinimalPNG()
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{"base64", "data:image/png;base64," + encoded},
+		{"unpadded base64", "data:image/png;base64," + strings.TrimRight(encoded, "=")},
+		{"percent-encoded base64", "data:image/png;base64," + url.QueryEscape(encoded)},
+		{"URL-safe base64", "data:image/png;base64," + base64.RawURLEncoding.EncodeToString(png)},
+		{"no media type", "data:;base64," + encoded},
+		{"percent-encoded bytes", "DATA:image/png," + url.PathEscape(string(png))},
+	}
+
+	for _, tt := range tests {
+		md, err := MetadataFromURL(tt.uri)
+		if err != nil || md.Format != FormatPNG || md.Width != 100 {
+			t.Errorf("%s: MetadataFromURL() = %v, %v, want a 100px PNG", tt.name, md, err)
+		}
+	}
+
+	svg := `data:image/svg+xml,%3Csvg xmlns="http://www.w3.org/2000/svg" width="24" height="12"/%3E`
+	if md, err := MetadataFromURL(svg); err != nil || md.Format != FormatSVG || md.Width != 24 {
+		t.Errorf("SVG data URI = %v, %v, want a 24px SVG", md, err)
+	}
+
+	if _, err := MetadataFromURL("data:image/png;base64,"+encoded, WithMaxBytes(16)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Oversized data URI error = %v, want ErrTooLarge", err)
+	}
+	for _, bad := range []string{"data:image/png;base64", "data:image/png;base64,!!!!", "data:image/;base64," + encoded} {
+		if _, err := MetadataFromURL(bad); !errors.Is(err, ErrInvalidSource) {
+			t.Errorf("MetadataFromURL(%.30q) error = %v, want ErrInvalidSource", bad, err)
+		}
+	}
+}
+
+func TestMetadata_PNGPalette(t *testing.T) {
+	plte := []byte{255, 0, 0, 0, 255, 0, 0, 0, 255}
+	md, err := MetadataFromBytes(createPNGWithChunk("PLTE", plte))
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Additional["PaletteSize"] != 3 {
+		t.Errorf("PaletteSize = %v, want 3", md.Additional["PaletteSize"])
+	}
+	palette, ok := md.Additional["Palette"].([][3]uint8)
+	if !ok || len(palette) != 3 || palette[0] != [3]uint8{255, 0, 0} || palette[2] != [3]uint8{0, 0, 255} {
+		t.Errorf("Palette = %v, want red, green, blue", md.Additional["Palette"])
+	}
+
+	// A length that is not a multiple of 3 is ignored unless strict
+	bad := createPNGWithChunk("PLTE", plte[:8])
+	md, err = MetadataFromBytes(bad)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if _, ok := md.Additional["PaletteSize"]; ok {
+		t.Errorf("PaletteSize = %v for an 8-byte PLTE", md.Additional["PaletteSize"])
+	}
+	if _, err := MetadataFromBytes(bad, WithStrict(true)); !errors.Is(err, formats.ErrInvalidData) {
+		t.Errorf("MetadataFromBytes() strict error = %v, want ErrInvalidData", err)
+	}
+}
+
+func TestMetadata_GIFPalette(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 255, 0, 255},
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{0, 0, 255, 255},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := MetadataFromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	got, ok := md.Additional["Palette"].([][3]uint8)
+	if !ok || len(got) != 4 || got[0] != [3]uint8{255, 0, 0} || got[3] != [3]uint8{0, 0, 255} {
+		t.Errorf("Palette = %v, want the 4 encoded colors", md.Additional["Palette"])
+	}
+	if md.Additional["TransparentIndex"] != 2 {
+		t.Errorf("TransparentIndex = %v, want 2", md.Additional["TransparentIndex"])
+	}
+
+	// WithoutPalette seeks past the table and still finds the blocks after it
+	md, err = MetadataFromBytes(buf.Bytes(), WithoutPalette())
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(WithoutPalette()) error = %v", err)
+	}
+	if _, ok := md.Additional["Palette"]; ok {
+		t.Error("Palette reported with WithoutPalette()")
+	}
+	if md.Additional["FrameCount"] != 1 || md.Additional["TransparentIndex"] != 2 {
+		t.Errorf("FrameCount/TransparentIndex = %v/%v, want 1/2", md.Additional["FrameCount"], md.Additional["TransparentIndex"])
+	}
+}
+
+// encodeTestPNG encodes a small RGBA image with the standard library, so
+// every chunk carries a valid CRC
+func encodeTestPNG(t testing.TB) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// createJPEGWithSOFAndEXIF places an EXIF APP1 segment before the frame header
+func createJPEGWithSOFAndEXIF(tiff []byte) []byte {
+	jpeg := createJPEGWithSOF(0xC0, 8, 0x11)
+	app1 := jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+	return append(append(jpeg[:2:2], app1...), jpeg[2:]...)
+}
+
+func TestValidate(t *testing.T) {
+	pngData := encodeTestPNG(t)
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, encodePaletted(4, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 1)}})
+
+	valid := map[string][]byte{
+		"PNG":       pngData,
+		"GIF":       gifBuf.Bytes(),
+		"JPEG":      createJPEGWithSOF(0xC0, 8, 0x22, 0x11, 0x11),
+		"JPEG+EXIF": createJPEGWithSOFAndEXIF(tiff),
+	}
+	for name, data := range valid {
+		if err := Validate(bytes.NewReader(data)); err != nil {
+			t.Errorf("Validate(%s) error = %v", name, err)
+		}
+	}
+
+	// A flipped bit in the image data only shows up in the CRC
+	corrupt := append([]byte{}, pngData...)
+	idat := bytes.Index(corrupt, []byte("IDAT"))
+	corrupt[idat+6] ^= 0x01
+	if _, err := MetadataFromBytes(corrupt); err != nil {
+		t.Errorf("MetadataFromBytes() on a corrupt IDAT error = %v", err)
+	}
+
+	// The Exif IFD pointer reaches past the EXIF block
+	badTIFF := buildTIFF(&testIFD{entries: []testEntry{longEntry(0x8769, 0x10000)}})
+	badEXIF := createJPEGWithSOFAndEXIF(badTIFF)
+	if _, err := MetadataFromBytes(badEXIF); err != nil {
+		t.Errorf("MetadataFromBytes() on a bad IFD pointer error = %v", err)
+	}
+
+	invalid := map[string][]byte{
+		"PNG with bad CRC":        corrupt,
+		"truncated PNG":           pngData[:len(pngData)-20],
+		"truncated JPEG":          valid["JPEG"][:len(valid["JPEG"])-2],
+		"JPEG with bad IFD":       badEXIF,
+		"JPEG with overlong EXIF": createJPEGWithSOFAndEXIF(buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, strings.Repeat("x", 32))}})[:30]),
+	}
+	for name, data := range invalid {
+		if err := Validate(bytes.NewReader(data)); !errors.Is(err, formats.ErrInvalidData) {
+			t.Errorf("Validate(%s) error = %v, want ErrInvalidData", name, err)
+		}
+	}
+
+	if err := Validate(strings.NewReader("not an image")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Validate(text) error = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestValidate_SelfReferencingIFD(t *testing.T) {
+	inputs := map[string][]byte{
+		"self-referencing": createJPEGWithSOFAndEXIF(selfReferencingTIFF()),
+		"fan-out":          createJPEGWithSOFAndEXIF(fanOutTIFF()),
+	}
+	// within runs check and fails the test if it takes more than a second
+	within := func(name string, check func() error) error {
+		done := make(chan error, 1)
+		go func() { done <- check() }()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+			t.Fatalf("%s did not return within a second", name)
+			return nil
+		}
+	}
+
+	for name, data := range inputs {
+		err := within("Validate("+name+")", func() error { return Validate(bytes.NewReader(data)) })
+		if !errors.Is(err, formats.ErrInvalidData) {
+			t.Errorf("Validate(%s) error = %v, want ErrInvalidData", name, err)
+		}
+		err = within("WithStrict("+name+")", func() error {
+			_, err := MetadataFromBytes(data, WithStrict(true))
+			return err
+		})
+		if !errors.Is(err, formats.ErrInvalidData) {
+			t.Errorf("MetadataFromBytes(%s, WithStrict) error = %v, want ErrInvalidData", name, err)
+		}
+		err = within("WithLogger("+name+")", func() error {
+			_, err := MetadataFromBytes(data, WithLogger(func(string, ...interface{}) {}))
+			return err
+		})
+		if err != nil {
+			t.Errorf("MetadataFromBytes(%s, WithLogger) error = %v", name, err)
+		}
+	}
+}
+
+// FuzzValidate checks that neither validation nor extraction panics on
+// mutated inputs of every format
+func FuzzValidate(f *testing.F) {
+	var gifBuf bytes.Buffer
+	gif.Encode(&gifBuf, encodePaletted(4, 4), nil)
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6)}})
+
+	seeds := [][]byte{
+		createMinimalJPEG(),
+		createJPEGWithSOFAndEXIF(tiff),
+		createJPEGWithSOFAndEXIF(selfReferencingTIFF()),
+		createJPEGWithSOFAndEXIF(fanOutTIFF()),
+		createMinimalPNG(),
+		encodeTestPNG(f),
+		gifBuf.Bytes(),
+		createMinimalWebP(),
+		createWebP(vp8xChunk(0x10, 300, 200)),
+		createMinimalBMP(),
+		createMinimalAVIF("avif"),
+		createMinimalTGA(),
+		createMinimalDDS(0x4, "DXT1", 0, 0),
+		buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0100, 8), shortEntry(0x0101, 8)}, next: &testIFD{}}),
+		[]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="24" height="12"/>`),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Validate(bytes.NewReader(data))
+		if md, err := MetadataFromBytes(data); err == nil {
+			md.Thumbnail()
+			md.FlatMap()
+		}
+	})
+}
+
+func TestWithCRCCheck(t *testing.T) {
+	data := encodeTestPNG(t)
+
+	md, err := MetadataFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := md.Additional["CRCValid"]; ok {
+		t.Error("CRCValid set without WithCRCCheck")
+	}
+
+	md, err = MetadataFromBytes(data, WithCRCCheck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Additional["CRCValid"] != true {
+		t.Errorf("CRCValid = %v, want true", md.Additional["CRCValid"])
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[bytes.Index(corrupt, []byte("IDAT"))+6] ^= 0x01
+	corrupt[bytes.Index(corrupt, []byte("IHDR"))+17] ^= 0x01 // stored CRC
+	md, err = MetadataFromBytes(corrupt, WithCRCCheck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Additional["CRCValid"] != false {
+		t.Errorf("CRCValid = %v, want false", md.Additional["CRCValid"])
+	}
+	if got, _ := md.Additional["CRCMismatches"].([]string); strings.Join(got, ",") != "IHDR,IDAT" {
+		t.Errorf("CRCMismatches = %v, want [IHDR IDAT]", got)
+	}
+	if md.Width != 8 || md.Height != 4 {
+		t.Errorf("dimensions = %dx%d, want 8x4", md.Width, md.Height)
+	}
+
+	_, err = MetadataFromBytes(corrupt, WithCRCCheck(), WithStrict(true))
+	if !errors.Is(err, formats.ErrInvalidData) || !strings.Contains(err.Error(), "IHDR") {
+		t.Errorf("strict error = %v, want IHDR CRC mismatch", err)
+	}
+}
+
+// TestConcurrentExtraction runs extractions of mixed formats in parallel
+// and compares each result with a sequential run. Run it with -race.
+func TestConcurrentExtraction(t *testing.T) {
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 6)}})
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, encodePaletted(4, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{
+		createMinimalJPEG(),
+		createJPEGWithSOFAndEXIF(tiff),
+		createMinimalPNG(),
+		encodeTestPNG(t),
+		gifBuf.Bytes(),
+		createWebP(vp8xChunk(0x10, 300, 200)),
+		createMinimalBMP(),
+		createMinimalTGA(),
+	}
+
+	type summary struct {
+		format        Format
+		width, height int
+		exif          string
+	}
+	summarize := func(data []byte, opts ...Option) (summary, error) {
+		md, err := MetadataFromBytes(data, opts...)
+		if err != nil {
+			return summary{}, err
+		}
+		s := summary{format: md.Format, width: md.Width, height: md.Height}
+		s.exif, _ = md.EXIFString("Make")
+		return s, nil
+	}
+
+	want := make([]summary, len(inputs))
+	for i, data := range inputs {
+		s, err := summarize(data)
+		if err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+		want[i] = s
+	}
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				i := (w + n) % len(inputs)
+				got, err := summarize(inputs[i], WithCRCCheck())
+				if err == nil && got != want[i] {
+					err = errors.New("result differs from the sequential run")
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMetadata_Truncated(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	var jpegBuf, pngBuf, gifBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifBuf, encodePaletted(16, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		cut  int // bytes removed from the end
+	}{
+		{"JPEG", jpegBuf.Bytes(), 2},
+		{"JPEG in scan data", jpegBuf.Bytes(), 20},
+		{"PNG", pngBuf.Bytes(), 12},
+		{"GIF", gifBuf.Bytes(), 1},
+		{"GIF in image data", gifBuf.Bytes(), 6},
+		{"WebP", createWebP(vp8xChunk(0x10, 300, 200), webpChunk("ANIM", make([]byte, 6))), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := md.Additional["Truncated"]; ok {
+				t.Error("complete file reported as truncated")
+			}
+
+			cut := tt.data[:len(tt.data)-tt.cut]
+			md, err = MetadataFromBytes(cut)
+			if err != nil {
+				t.Fatalf("truncated file error = %v", err)
+			}
+			if md.Additional["Truncated"] != true || md.Width == 0 {
+				t.Errorf("Truncated = %v, Width = %d, want true and the image width", md.Additional["Truncated"], md.Width)
+			}
+
+			md, err = MetadataFromBytes(cut, WithStrict(true))
+			if !errors.Is(err, ErrPartial) || !errors.Is(err, formats.ErrInvalidData) || md == nil {
+				t.Errorf("strict error = %v, want ErrPartial and ErrInvalidData with metadata", err)
+			}
+		})
+	}
+}
+
+func TestMetadata_AnimationFields(t *testing.T) {
+	frame := encodePaletted(4, 4)
+	var gifBuf bytes.Buffer
+	err := gif.EncodeAll(&gifBuf, &gif.GIF{
+		Image:     []*image.Paletted{frame, frame, frame},
+		Delay:     []int{10, 25, 5}, // hundredths of a second
+		LoopCount: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// APNG: acTL with two frames played once, and their fcTL delays
+	fctl := func(num, den uint16) []byte {
+		data := make([]byte, 26)
+		binary.BigEndian.PutUint16(data[20:], num)
+		binary.BigEndian.PutUint16(data[22:], den)
+		return data
+	}
+	apng := createPNGWithChunk("acTL", []byte{0, 0, 0, 2, 0, 0, 0, 1})
+	apng = insertPNGChunk(apng, "fcTL", fctl(1, 3))
+	apng = insertPNGChunk(apng, "fcTL", fctl(50, 0))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		animated bool
+		frames   int
+		duration time.Duration
+		loops    int
+	}{
+		{"GIF", gifBuf.Bytes(), true, 3, 400 * time.Millisecond, 3},
+		{"APNG", apng, true, 2, 833 * time.Millisecond, 1},
+		{"still PNG", createMinimalPNG(), false, 1, 0, 0},
+		{"JPEG", createJPEGWithSOF(0xC0, 8, 0x11), false, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if md.IsAnimated != tt.animated || md.FrameCount != tt.frames || md.Duration != tt.duration || md.LoopCount != tt.loops {
+				t.Errorf("IsAnimated, FrameCount, Duration, LoopCount = %v, %d, %v, %d, want %v, %d, %v, %d",
+					md.IsAnimated, md.FrameCount, md.Duration, md.LoopCount, tt.animated, tt.frames, tt.duration, tt.loops)
+			}
+		})
+	}
+}
+
+func TestMetadata_AlphaAndChannels(t *testing.T) {
+	rgba := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	rgba.Set(0, 0, color.NRGBA{R: 255, A: 128})
+	var rgbaPNG bytes.Buffer
+	if err := png.Encode(&rgbaPNG, rgba); err != nil {
+		t.Fatal(err)
+	}
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Transparent, color.Black})
+	var transparentGIF, indexedPNG bytes.Buffer
+	if err := gif.Encode(&transparentGIF, paletted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&indexedPNG, paletted); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		alpha    bool
+		channels int
+	}{
+		{"grayscale JPEG", createJPEGWithSOF(0xC0, 8, 0x11), false, 1},
+		{"RGB JPEG", createJPEGWithSOF(0xC0, 8, 0x22, 0x11, 0x11), false, 3},
+		{"CMYK JPEG", createJPEGWithSOF(0xC2, 8, 0x11, 0x11, 0x11, 0x11), false, 4},
+		{"RGBA PNG", rgbaPNG.Bytes(), true, 4},
+		{"indexed PNG with tRNS", indexedPNG.Bytes(), true, 1},
+		{"GIF with transparent index", transparentGIF.Bytes(), true, 1},
+		{"WebP with alpha", createWebP(vp8xChunk(0x10, 300, 200)), true, 4},
+		{"WebP without alpha", createWebP(vp8xChunk(0x00, 300, 200)), false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if md.HasAlpha != tt.alpha || md.Channels != tt.channels {
+				t.Errorf("HasAlpha, Channels = %v, %d, want %v, %d", md.HasAlpha, md.Channels, tt.alpha, tt.channels)
+			}
+		})
+	}
+}
+
+func TestMetadata_DPIFields(t *testing.T) {
+	exif := func(unit uint16) []byte {
+		tiff := buildTIFF(&testIFD{entries: []testEntry{
+			rationalEntry(0x011A, 300, 1),
+			rationalEntry(0x011B, 600, 2),
+			shortEntry(0x0128, unit),
+		}})
+		return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+	}
+
+	jfif := []byte{'J', 'F', 'I', 'F', 0, 1, 2, 2, 0, 0x76, 0, 0x3B, 0, 0}
+	dpcm := append(append([]byte{0xFF, 0xD8}, jpegSegment(0xE0, jfif)...), createMinimalJPEG()[20:]...)
+
+	bmp := createMinimalBMP()
+	binary.LittleEndian.PutUint32(bmp[38:], 2835)
+	binary.LittleEndian.PutUint32(bmp[42:], 11811)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		dpiX, dpiY float64
+	}{
+		{"JFIF", createMinimalJPEG(), 72, 72},
+		{"EXIF over JFIF", createJPEGWithSegments(exif(2)), 300, 300},
+		{"EXIF in centimeters", createJPEGWithSegments(exif(3)), 762, 762},
+		{"JFIF dpcm", dpcm, 299.72, 149.86},
+		{"BMP", bmp, 72.009, 299.9994},
+		{"PNG without pHYs", createMinimalPNG(), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(md.DPIX-tt.dpiX) > 0.001 || math.Abs(md.DPIY-tt.dpiY) > 0.001 {
+				t.Errorf("DPI = %vx%v, want %vx%v", md.DPIX, md.DPIY, tt.dpiX, tt.dpiY)
+			}
+		})
+	}
+}
+
+func TestMetadata_OrientationField(t *testing.T) {
+	tiff := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0112, 6)}})
+	md, err := MetadataFromBytes(createJPEGWithSOFAndEXIF(tiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Orientation != OrientationRotate90 || md.Orientation.String() != "Rotate 90 CW" {
+		t.Errorf("Orientation = %d (%v), want 6 (Rotate 90 CW)", md.Orientation, md.Orientation)
+	}
+	if w, h := md.DisplayDimensions(); w != 16 || h != 32 {
+		t.Errorf("DisplayDimensions() = %dx%d, want 16x32", w, h)
+	}
+
+	// Out-of-range values are not trusted
+	tiff = buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0112, 9)}})
+	if md, err = MetadataFromBytes(createJPEGWithSOFAndEXIF(tiff)); err != nil {
+		t.Fatal(err)
+	}
+	if md.Orientation != OrientationUnknown {
+		t.Errorf("Orientation = %d, want unknown", md.Orientation)
+	}
+
+	if md, err = MetadataFromBytes(createMinimalPNG()); err != nil || md.Orientation != OrientationUnknown {
+		t.Errorf("PNG Orientation = %d, %v, want unknown", md.Orientation, err)
+	}
+
+	names := map[Orientation]string{
+		OrientationUnknown:   "Unknown",
+		OrientationNormal:    "Horizontal (normal)",
+		OrientationTranspose: "Mirror horizontal and rotate 270 CW",
+		OrientationRotate270: "Rotate 270 CW",
+		Orientation(42):      "Unknown",
+	}
+	for o, want := range names {
+		if got := o.String(); got != want {
+			t.Errorf("Orientation(%d).String() = %q, want %q", int(o), got, want)
+		}
+	}
+}
+
+// BenchmarkScanFile measures segment and block scanning against a file,
+// where every small read or seek can become a system call
+func BenchmarkScanFile(b *testing.B) {
+	// A JPEG with many small APPn segments ahead of the frame header
+	var segments [][]byte
+	for i := 0; i < 2000; i++ {
+		segments = append(segments, jpegSegment(0xE3, make([]byte, 40)))
+	}
+	jpegData := append([]byte{0xFF, 0xD8}, bytes.Join(segments, nil)...)
+	jpegData = append(jpegData, createJPEGWithSOF(0xC0, 8, 0x11)[2:]...)
+
+	// An animated GIF with many frames
+	frame := encodePaletted(4, 4)
+	anim := &gif.GIF{}
+	for i := 0; i < 500; i++ {
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 2)
+	}
+	var gifBuf bytes.Buffer
+	if err := gif.EncodeAll(&gifBuf, anim); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name string
+		data []byte
+	}{
+		{"JPEG", jpegData},
+		{"GIF", gifBuf.Bytes()},
+	} {
+		path := filepath.Join(b.TempDir(), "image")
+		if err := os.WriteFile(path, bm.data, 0o644); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bm.data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := MetadataFromFile(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDimensionsOnly compares full extraction with WithoutEXIF on a
+// JPEG whose EXIF block is large, as camera files with maker notes are
+func BenchmarkDimensionsOnly(b *testing.B) {
+	exifIFD := &testIFD{entries: []testEntry{
+		asciiEntry(0x9003, "2024:03:09 14:05:30"),
+		rationalEntry(0x829D, 28, 10),
+		undefinedEntry(0x927C, make([]byte, 48<<10)), // MakerNote
+	}}
+	tiff := buildTIFF(&testIFD{entries: []testEntry{
+		asciiEntry(0x010F, "Canon"),
+		asciiEntry(0x0110, "Canon EOS R5"),
+		shortEntry(0x0112, 1),
+		{tag: 0x8769, sub: exifIFD},
+	}})
+	data := createJPEGWithSOFAndEXIF(tiff)
+	path := filepath.Join(b.TempDir(), "camera.jpg")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Full", nil},
+		{"WithoutEXIF", []Option{WithoutEXIF()}},
+		{"LazyEXIF", []Option{WithLazyEXIF()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := MetadataFromFile(path, bm.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadata_TIFFPages(t *testing.T) {
+	rgbBits := testEntry{tag: 0x0102, typ: 3, count: 3, data: []byte{8, 0, 8, 0, 8, 0}}
+	fax := &testIFD{entries: []testEntry{
+		longEntry(0x0100, 1728), longEntry(0x0101, 2200), shortEntry(0x0103, 4), shortEntry(0x0106, 0),
+	}}
+	fax.next = &testIFD{entries: []testEntry{shortEntry(0x0100, 16), shortEntry(0x0101, 16), shortEntry(0x0102, 4)}}
+	tiff := buildTIFF(&testIFD{
+		entries: []testEntry{
+			shortEntry(0x0100, 64), shortEntry(0x0101, 32), rgbBits, shortEntry(0x0103, 5),
+			shortEntry(0x0106, 2), asciiEntry(0x010F, "Scanner Co"), shortEntry(0x0115, 3),
+		},
+		next: fax,
+	})
+
+	md, err := MetadataFromBytes(tiff)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes() error = %v", err)
+	}
+	if md.Format != FormatTIFF || md.Width != 64 || md.Height != 32 || md.ColorSpace != ColorSpaceRGB || md.ColorDepth != 24 {
+		t.Errorf("TIFF = %v %dx%d %v %d-bit, want TIFF 64x32 RGB 24-bit", md.Format, md.Width, md.Height, md.ColorSpace, md.ColorDepth)
+	}
+	if md.Additional["PageCount"] != 3 || md.Additional["CompressionName"] != "LZW" {
+		t.Errorf("PageCount = %v, CompressionName = %v, want 3, LZW", md.Additional["PageCount"], md.Additional["CompressionName"])
+	}
+	want := []TIFFPage{
+		{Width: 64, Height: 32, BitsPerSample: 8},
+		{Width: 1728, Height: 2200, BitsPerSample: 1},
+		{Width: 16, Height: 16, BitsPerSample: 4},
+	}
+	pages, _ := md.Additional["Pages"].([]TIFFPage)
+	if len(pages) != len(want) {
+		t.Fatalf("Pages = %v, want %v", pages, want)
+	}
+	for i := range want {
+		if pages[i] != want[i] {
+			t.Errorf("Pages[%d] = %+v, want %+v", i, pages[i], want[i])
+		}
+	}
+	if md.EXIF["Make"] != "Scanner Co" {
+		t.Errorf("EXIF Make = %v, want Scanner Co", md.EXIF["Make"])
+	}
+	if _, ok := md.EXIF["IFD1"]; ok {
+		t.Error("second page reported as an EXIF IFD1")
+	}
+
+	// A next-IFD offset pointing back at the first IFD ends the walk
+	cyclic := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0100, 8), shortEntry(0x0101, 8)}})
+	binary.LittleEndian.PutUint32(cyclic[8+2+2*12:], 8)
+	md, err = MetadataFromBytes(cyclic)
+	if err != nil || md.Additional["PageCount"] != 1 {
+		t.Errorf("Cyclic TIFF = %v, %v, want one page", md, err)
+	}
+
+	// Big-endian files are detected too
+	if DetectFormatFromBytes([]byte("MM\x00\x2A\x00\x00\x00\x08")) != FormatTIFF {
+		t.Error("big-endian TIFF not detected")
+	}
+}
+
+func TestMetadata_RAW(t *testing.T) {
+	// NEF and ARW keep a thumbnail in IFD0 and the sensor data in a SubIFD
+	sensor := &testIFD{entries: []testEntry{
+		shortEntry(0x00FE, 0), shortEntry(0x0100, 6048), shortEntry(0x0101, 4024),
+		shortEntry(0x0102, 14), shortEntry(0x0106, 32803),
+	}}
+	camera := func(make string, extra ...testEntry) []byte {
+		return buildTIFF(&testIFD{entries: append([]testEntry{
+			shortEntry(0x00FE, 1), shortEntry(0x0100, 160), shortEntry(0x0101, 120),
+			asciiEntry(0x010F, make), asciiEntry(0x0110, "Test Camera"), shortEntry(0x0112, 6),
+			pointerEntry(0x014A, sensor),
+		}, extra...)})
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want Format
+	}{
+		{"NEF", camera("NIKON CORPORATION"), FormatNEF},
+		{"ARW", camera("SONY"), FormatARW},
+		{"DNG", camera("Canon", byteEntry(0xC612, []byte{1, 4, 0, 0})), FormatDNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := MetadataFromBytes(tt.data)
+			if err != nil {
+				t.Fatalf("MetadataFromBytes() error = %v", err)
+			}
+			if md.Format != tt.want || md.Width != 6048 || md.Height != 4024 {
+				t.Errorf("got %v %dx%d, want %v 6048x4024", md.Format, md.Width, md.Height, tt.want)
+			}
+			if md.Orientation != OrientationRotate90 || md.EXIF["Model"] != "Test Camera" {
+				t.Errorf("Orientation = %v, Model = %v", md.Orientation, md.EXIF["Model"])
+			}
+			if _, ok := md.Additional["PageCount"]; ok {
+				t.Error("RAW renditions reported as pages")
+			}
+
+			// Detection reads only the header, which is a plain TIFF one
+			if got, err := DetectFormat(bytes.NewReader(tt.data)); err != nil || got != FormatTIFF {
+				t.Errorf("DetectFormat() = %v, %v, want TIFF", got, err)
+			}
+			if got := DetectFormatFromBytes(tt.data); got != FormatTIFF {
+				t.Errorf("DetectFormatFromBytes() = %v, want TIFF", got)
+			}
+		})
+	}
+
+	// A Nikon TIFF without SubIFDs stays a TIFF
+	scan := buildTIFF(&testIFD{entries: []testEntry{shortEntry(0x0100, 8), shortEntry(0x0101, 8), asciiEntry(0x010F, "Nikon")}})
+	if md, err := MetadataFromBytes(scan); err != nil || md.Format != FormatTIFF {
+		t.Errorf("Nikon TIFF = %v, %v, want TIFF", md, err)
+	}
+
+	// CR2 is marked in the header and describes the full-size image in IFD0
+	ifd0 := &testIFD{
+		entries: []testEntry{shortEntry(0x0100, 5472), shortEntry(0x0101, 3648), asciiEntry(0x010F, "Canon"), shortEntry(0x0112, 1)},
+		next:    &testIFD{entries: []testEntry{shortEntry(0x0100, 160), shortEntry(0x0101, 120)}},
+	}
+	cr2 := writeTestIFD([]byte{'I', 'I', 42, 0, 16, 0, 0, 0, 'C', 'R', 2, 0, 0, 0, 0, 0}, ifd0)
+	if DetectFormatFromBytes(cr2) != FormatCR2 {
+		t.Errorf("DetectFormatFromBytes(CR2) = %v", DetectFormatFromBytes(cr2))
+	}
+	md, err := MetadataFromBytes(cr2)
+	if err != nil || md.Format != FormatCR2 || md.Width != 5472 || md.Height != 3648 || md.EXIF["Make"] != "Canon" {
+		t.Errorf("CR2 = %+v, %v, want CR2 5472x3648 by Canon", md, err)
+	}
+}
+
+func TestMetadata_RAWPreview(t *testing.T) {
+	preview := createJPEGWithSOF(0xC0, 8, 0x11, 0x11, 0x11)
+	sensor := &testIFD{entries: []testEntry{shortEntry(0x0100, 6048), shortEntry(0x0101, 4024), shortEntry(0x0103, 32767)}}
+
+	// The preview lands right after the TIFF structure, whose size does
+	// not depend on the offsets written into it
+	build := func(offset uint32) []byte {
+		thumb := &testIFD{entries: []testEntry{longEntry(0x0201, offset), longEntry(0x0202, 16)}}
+		return buildTIFF(&testIFD{
+			entries: []testEntry{
+				shortEntry(0x0100, 160), shortEntry(0x0101, 120), asciiEntry(0x010F, "SONY"), pointerEntry(0x014A, sensor),
+				longEntry(0x0201, offset), longEntry(0x0202, uint32(len(preview))),
+			},
+			next: thumb,
+		})
+	}
+	arw := append(build(uint32(len(build(0)))), preview...)
+
+	md, err := MetadataFromBytes(arw)
+	if err != nil || md.Format != FormatARW {
+		t.Fatalf("MetadataFromBytes() = %v, %v, want ARW", md, err)
+	}
+	got, err := md.Preview(bytes.NewReader(arw))
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if !bytes.Equal(got, preview) {
+		t.Errorf("Preview() = %d bytes, want the %d-byte IFD0 preview", len(got), len(preview))
+	}
+
+	// CR2 keeps its preview in a single JPEG strip; the raw IFD without
+	// ImageWidth is lossless sensor data, not a preview
+	cr2IFD := func(offset uint32) *testIFD {
+		return &testIFD{
+			entries: []testEntry{
+				shortEntry(0x0100, 32), shortEntry(0x0101, 16), shortEntry(0x0103, 6),
+				longEntry(0x0111, offset), longEntry(0x0117, uint32(len(preview))),
+			},
+			next: &testIFD{entries: []testEntry{shortEntry(0x0103, 6), longEntry(0x0111, 0), longEntry(0x0117, offset)}},
+		}
+	}
+	header := []byte{'I', 'I', 42, 0, 16, 0, 0, 0, 'C', 'R', 2, 0, 0, 0, 0, 0}
+	cr2 := writeTestIFD(append([]byte(nil), header...), cr2IFD(0))
+	cr2 = append(writeTestIFD(append([]byte(nil), header...), cr2IFD(uint32(len(cr2)))), preview...)
+	md, err = MetadataFromBytes(cr2)
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(CR2) error = %v", err)
+	}
+	if got, err := md.Preview(bytes.NewReader(cr2)); err != nil || !bytes.Equal(got, preview) {
+		t.Errorf("CR2 Preview() = %d bytes, %v, want the strip", len(got), err)
+	}
+
+	// A preview running past the end of the file is skipped for the
+	// smaller one that fits
+	md, err = MetadataFromBytes(arw[:len(arw)-1])
+	if err != nil {
+		t.Fatalf("MetadataFromBytes(truncated) error = %v", err)
+	}
+	if got, err := md.Preview(bytes.NewReader(arw)); err != nil || len(got) != 16 {
+		t.Errorf("truncated Preview() = %d bytes, %v, want the 16-byte thumbnail", len(got), err)
+	}
+
+	md, _ = MetadataFromBytes(preview)
+	if _, err := md.Preview(bytes.NewReader(nil)); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("JPEG Preview() error = %v, want ErrNoPreview", err)
+	}
+}
+
+var registerTestFormats sync.Once
+
+func TestRegisterFormat(t *testing.T) {
+	xpng := []byte("\x89PNG\r\n\x1a\nXPNG")
+	parse := func(width int) func(io.ReadSeeker) (*formats.Result, error) {
+		return func(r io.ReadSeeker) (*formats.Result, error) {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return &formats.Result{Width: width, Height: 1, ColorSpace: "RGB", ColorDepth: 24}, nil
+		}
+	}
+	registerTestFormats.Do(func() {
+		RegisterFormat("IMXT", []byte("IMXT"), parse(7))
+		// A private marker after the PNG signature is more specific than PNG
+		RegisterFormat("XPNG", xpng, parse(9))
+	})
+
+	custom := []byte("IMXT\x00\x01")
+	if got := DetectFormatFromBytes(custom); got != "IMXT" {
+		t.Errorf("DetectFormatFromBytes() = %q, want IMXT", got)
+	}
+	md, err := MetadataFromBytes(custom)
+	if err != nil || md.Format != "IMXT" || md.Width != 7 || md.FileSize != int64(len(custom)) {
+		t.Errorf("MetadataFromBytes() = %+v, %v, want IMXT 7x1", md, err)
+	}
+
+	if md, err := MetadataFromBytes(xpng); err != nil || md.Format != "XPNG" || md.Width != 9 {
+		t.Errorf("MetadataFromBytes(XPNG) = %+v, %v, want XPNG", md, err)
+	}
+	if got := DetectFormatFromBytes(encodeTestPNG(t)); got != FormatPNG {
+		t.Errorf("plain PNG detected as %q", got)
+	}
+
+	// The built-in formats go through the same registry
+	var registered []string
+	for _, sig := range formats.Signatures() {
+		registered = append(registered, sig.Format)
+	}
+	for _, want := range []string{"JPEG", "PNG", "TIFF", "TGA", "IMXT"} {
+		if !strings.Contains(strings.Join(registered, ","), want) {
+			t.Errorf("Signatures() = %v, missing %s", registered, want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering IMXT twice did not panic")
+		}
+	}()
+	RegisterFormat("IMXT", []byte("IMXT2"), parse(1))
+}
+
+func TestWithLogger(t *testing.T) {
+	type event struct {
+		msg    string
+		detail []interface{}
+	}
+	collect := func(data []byte) []event {
+		t.Helper()
+		var events []event
+		logger := func(msg string, detail ...interface{}) {
+			events = append(events, event{msg, detail})
+		}
+		if _, err := MetadataFromBytes(data, WithLogger(logger)); err != nil {
+			t.Fatalf("MetadataFromBytes() error = %v", err)
+		}
+		return events
+	}
+	has := func(events []event, msg, value string) bool {
+		for _, e := range events {
+			if e.msg == msg && strings.Contains(fmt.Sprint(e.detail...), value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The Make value sits past the IFD and is cut off
+	tiff := buildTIFF(&testIFD{entries: []testEntry{asciiEntry(0x010F, "Example Camera Co.")}})
+	tiff = tiff[:len(tiff)-8]
+	iptc := []byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00\x00\x00\x00\x04junk")
+	jpeg := createJPEGWithSegments(
+		jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...)),
+		jpegSegment(0xED, iptc),
+		jpegSegment(0xE5, []byte("vendor")),
+	)
+	events := collect(jpeg)
+	if !has(events, "truncated EXIF IFD", "overruns") {
+		t.Errorf("JPEG events = %v, want a truncated IFD", events)
+	}
+	if !has(events, "skipped JPEG segment", "APP13") {
+		t.Errorf("JPEG events = %v, want the APP13 segment skipped", events)
+	}
+	if !has(events, "unknown JPEG segment", "APP5") {
+		t.Errorf("JPEG events = %v, want APP5 reported", events)
+	}
+
+	webp := createWebP(vp8xChunk(0, 16, 16), webpChunk("FOUR", []byte{1, 2}))
+	if events := collect(webp); !has(events, "unknown WebP chunk", "FOUR") {
+		t.Errorf("WebP events = %v, want the FOUR chunk reported", events)
+	}
+
+	// Well-formed input reports nothing
+	if events := collect(encodeTestPNG(t)); len(events) != 0 {
+		t.Errorf("PNG events = %v, want none", events)
+	}
+}
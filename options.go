@@ -0,0 +1,192 @@
+package imx
+
+import (
+	"net/http"
+
+	"imx/formats"
+)
+
+// Option configures a metadata extraction.
+type Option func(*options)
+
+// options holds the settings collected from Option values.
+type options struct {
+	maxBytes        int64
+	maxChunk        int64
+	fetchLimit      int64
+	httpClient      *http.Client
+	formatHint      Format
+	skipEXIF        bool
+	groupEXIF       bool
+	lazyEXIF        bool
+	strict          bool
+	verifyCRC       bool
+	uniqueColors    bool
+	stopAtImageData bool
+	skipPalette     bool
+	logger          Logger
+}
+
+func newOptions(opts []Option) options {
+	o := options{httpClient: defaultHTTPClient, fetchLimit: defaultFetchLimit}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// parserOptions returns the settings the format parsers act on for an
+// input of the given size.
+func (o options) parserOptions(size int64) formats.Options {
+	return formats.Options{
+		SkipEXIF:             o.skipEXIF,
+		GroupEXIF:            o.groupEXIF,
+		DeferEXIF:            o.lazyEXIF && !o.skipEXIF,
+		Strict:               o.strict,
+		VerifyCRC:            o.verifyCRC,
+		EstimateUniqueColors: o.uniqueColors,
+		StopAtImageData:      o.stopAtImageData,
+		SkipPalette:          o.skipPalette,
+		InputSize:            size,
+		MaxChunkSize:         o.maxChunk,
+		Logger:               o.logger,
+	}
+}
+
+// WithMaxBytes rejects inputs larger than n bytes with ErrTooLarge. Streams
+// and downloads stop reading once the limit is passed. Zero or a negative n
+// means no limit.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithMaxChunkSize bounds how large a single chunk or segment, such as a
+// PNG text chunk, a JPEG APP segment, a WebP EXIF chunk or an AVIF meta
+// box, may be before the input is rejected with formats.ErrInvalidData.
+// The default is formats.DefaultMaxChunkSize.
+func WithMaxChunkSize(n int64) Option {
+	return func(o *options) {
+		o.maxChunk = n
+	}
+}
+
+// WithFetchLimit caps how many bytes MetadataFromURL downloads. Metadata
+// normally sits near the start of the file, so only that prefix is fetched
+// and parsed; the default is 4 MiB. Zero or a negative n downloads the
+// whole body.
+func WithFetchLimit(n int64) Option {
+	return func(o *options) {
+		o.fetchLimit = n
+	}
+}
+
+// WithHTTPClient sets the client MetadataFromURL uses instead of the default
+// client with a 15 second timeout.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		if c != nil {
+			o.httpClient = c
+		}
+	}
+}
+
+// WithFormatHint names the format to parse the input as when it cannot be
+// detected from its signature, e.g. a TGA file without the 2.0 footer.
+// A detected format always takes precedence over the hint.
+func WithFormatHint(f Format) Option {
+	return func(o *options) {
+		o.formatHint = f
+	}
+}
+
+// WithoutEXIF skips decoding EXIF data. ImageMetadata.EXIF is left empty
+// and Thumbnail reports ErrNoThumbnail.
+func WithoutEXIF() Option {
+	return func(o *options) {
+		o.skipEXIF = true
+	}
+}
+
+// WithGroupedEXIF groups ImageMetadata.EXIF by the directory each tag
+// came from, as exiftool does: EXIF["IFD0"], EXIF["ExifIFD"], EXIF["GPS"],
+// EXIF["Interop"] and EXIF["IFD1"] are sub-maps. The EXIF accessors work on
+// either form, and FlatEXIF returns the default flat view.
+func WithGroupedEXIF() Option {
+	return func(o *options) {
+		o.groupEXIF = true
+	}
+}
+
+// WithLazyEXIF defers decoding EXIF until it is first used, for callers
+// that often need only the dimensions. The raw EXIF block of a JPEG, PNG or
+// WebP file is kept, and LoadEXIF decodes it; the EXIF accessors, FlatMap,
+// EXIFFormatted and Thumbnail call LoadEXIF themselves. Until then EXIF is
+// empty, and Orientation and an EXIF resolution are unset. Other formats
+// decode EXIF during extraction as usual.
+func WithLazyEXIF() Option {
+	return func(o *options) {
+		o.lazyEXIF = true
+	}
+}
+
+// WithCRCCheck verifies the CRC-32 of every PNG chunk, reading the image
+// data that is otherwise skipped. The result is reported as
+// Additional["CRCValid"], and the types of chunks that failed as
+// Additional["CRCMismatches"]. Combined with WithStrict the first mismatch
+// is an error. Other formats are unaffected.
+func WithCRCCheck() Option {
+	return func(o *options) {
+		o.verifyCRC = true
+	}
+}
+
+// WithUniqueColors reports how many palette entries an indexed PNG or GIF
+// actually uses in Additional["UniqueColors"]. For PNG this is the PLTE
+// entry count; for GIF a bounded amount of image data is decoded.
+func WithUniqueColors() Option {
+	return func(o *options) {
+		o.uniqueColors = true
+	}
+}
+
+// WithStopAtImageData ends PNG parsing at the first IDAT chunk instead of
+// seeking through the image data to IEND. Chunks before the image data are
+// still read, but eXIf and text chunks placed after it are missed.
+func WithStopAtImageData() Option {
+	return func(o *options) {
+		o.stopAtImageData = true
+	}
+}
+
+// WithoutPalette skips the GIF global color table instead of returning its
+// entries in Additional["Palette"].
+func WithoutPalette() Option {
+	return func(o *options) {
+		o.skipPalette = true
+	}
+}
+
+// WithStrict makes truncated or inconsistent data an error instead of
+// returning whatever metadata was read before the problem. An image whose
+// dimensions cannot be determined is also rejected.
+func WithStrict(strict bool) Option {
+	return func(o *options) {
+		o.strict = strict
+	}
+}
+
+// WithLogger reports data the parsers skip instead of failing on, such as
+// an APP13 segment whose IPTC record does not decode, a truncated EXIF IFD
+// or an unknown WebP chunk. Each event comes with key-value details, so a
+// *slog.Logger's Debug method can be passed as is:
+//
+//	md, err := imx.MetadataFromFile(path, imx.WithLogger(slog.Default().Debug))
+//
+// Without a logger such events are dropped.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
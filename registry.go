@@ -0,0 +1,36 @@
+package imx
+
+import (
+	"bytes"
+	"io"
+
+	"imx/formats"
+)
+
+// RegisterFormat adds a custom image format. Inputs starting with magic are
+// reported as name by the Detect functions, and the Metadata functions
+// extract them with parser, which seeks r itself and fills the
+// formats.Result fields it knows. The built-in formats are registered the
+// same way, through formats.Register.
+//
+// Longer magics are tried first, so a magic that extends a built-in one,
+// such as a PNG signature followed by a private marker, takes precedence.
+// Like image.RegisterFormat it is meant to be called from an init function.
+// It panics if name is already registered, if magic is empty or longer than
+// formats.MaxHeaderSize, or if parser is nil.
+func RegisterFormat(name Format, magic []byte, parser func(io.ReadSeeker) (*formats.Result, error)) {
+	if parser == nil {
+		panic("imx: RegisterFormat needs a parser")
+	}
+	magic = append([]byte(nil), magic...)
+	formats.Register(string(name),
+		func(r io.ReadSeeker, _ formats.Options) (*formats.Result, error) {
+			return parser(r)
+		},
+		formats.Signature{
+			Length: len(magic),
+			Match: func(window []byte) bool {
+				return bytes.HasPrefix(window, magic)
+			},
+		})
+}
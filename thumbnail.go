@@ -0,0 +1,66 @@
+package imx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Thumbnail returns the thumbnail embedded in the EXIF data, typically a
+// small JPEG, without decoding the full image. It returns ErrNoThumbnail
+// when the image has no thumbnail directory (IFD1).
+func (md *ImageMetadata) Thumbnail() ([]byte, error) {
+	ifd1, ok := md.FlatEXIF()["IFD1"].(map[string]interface{})
+	if !ok || md.rawEXIF == nil {
+		return nil, ErrNoThumbnail
+	}
+
+	offset, okOffset := exifUint(ifd1["ThumbnailOffset"])
+	length, okLength := exifUint(ifd1["ThumbnailLength"])
+	if !okOffset || !okLength || length == 0 {
+		return nil, ErrNoThumbnail
+	}
+
+	// Offsets are relative to the start of the TIFF header
+	end := offset + length
+	if end > uint64(len(md.rawEXIF)) {
+		return nil, fmt.Errorf("imx: thumbnail range %d-%d exceeds %d-byte EXIF data", offset, end, len(md.rawEXIF))
+	}
+
+	thumb := make([]byte, length)
+	copy(thumb, md.rawEXIF[offset:end])
+	return thumb, nil
+}
+
+// Preview returns the largest JPEG preview embedded in a camera RAW file,
+// usually full size and far cheaper to decode than the sensor data. r must
+// read the file md was extracted from; *os.File and *bytes.Reader both
+// implement io.ReaderAt. It returns ErrNoPreview when the file has none.
+func (md *ImageMetadata) Preview(r io.ReaderAt) ([]byte, error) {
+	offset, okOffset := md.AdditionalInt("PreviewOffset")
+	length, okLength := md.AdditionalInt("PreviewLength")
+	if !okOffset || !okLength || length <= 0 {
+		return nil, ErrNoPreview
+	}
+
+	preview := make([]byte, length)
+	if _, err := r.ReadAt(preview, offset); err != nil {
+		return nil, fmt.Errorf("imx: reading %d-byte preview at offset %d: %w", length, offset, err)
+	}
+	if !bytes.HasPrefix(preview, []byte{0xFF, 0xD8}) {
+		return nil, fmt.Errorf("imx: preview at offset %d is not a JPEG", offset)
+	}
+	return preview, nil
+}
+
+// exifUint converts an unsigned integer EXIF value to uint64.
+func exifUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -1,5 +1,11 @@
 package imx
 
+import (
+	"time"
+
+	"imx/formats"
+)
+
 // Format represents a supported image format.
 type Format string
 
@@ -10,6 +16,23 @@ const (
 	FormatGIF     Format = "GIF"
 	FormatWebP    Format = "WebP"
 	FormatBMP     Format = "BMP"
+	FormatAVIF    Format = "AVIF"
+	FormatJXL     Format = "JXL"
+	FormatSVG     Format = "SVG"
+	FormatICO     Format = "ICO"
+	FormatTGA     Format = "TGA"
+	FormatPSD     Format = "PSD"
+	FormatPNM     Format = "PNM"
+	FormatHDR     Format = "HDR"
+	FormatDDS     Format = "DDS"
+	FormatJP2     Format = "JP2"
+	FormatQOI     Format = "QOI"
+	FormatPCX     Format = "PCX"
+	FormatTIFF    Format = "TIFF"
+	FormatCR2     Format = "CR2" // Canon RAW
+	FormatNEF     Format = "NEF" // Nikon RAW
+	FormatARW     Format = "ARW" // Sony RAW
+	FormatDNG     Format = "DNG" // Adobe Digital Negative
 )
 
 // ColorSpace captures the color representation used by an image.
@@ -20,12 +43,78 @@ const (
 	ColorSpaceRGB            ColorSpace = "RGB"
 	ColorSpaceRGBA           ColorSpace = "RGBA"
 	ColorSpaceCMYK           ColorSpace = "CMYK"
+	ColorSpaceYCCK           ColorSpace = "YCCK"
 	ColorSpaceGrayscale      ColorSpace = "Grayscale"
 	ColorSpaceGrayscaleAlpha ColorSpace = "GrayscaleAlpha"
 	ColorSpaceIndexed        ColorSpace = "Indexed"
+	ColorSpaceLab            ColorSpace = "Lab"
+)
+
+// Orientation is the EXIF orientation of the stored pixels, 1 to 8, or
+// OrientationUnknown.
+type Orientation int
+
+const (
+	OrientationUnknown          Orientation = 0
+	OrientationNormal           Orientation = 1
+	OrientationMirrorHorizontal Orientation = 2
+	OrientationRotate180        Orientation = 3
+	OrientationMirrorVertical   Orientation = 4
+	OrientationTranspose        Orientation = 5 // mirror horizontal, rotate 270 CW
+	OrientationRotate90         Orientation = 6 // rotate 90 CW to display
+	OrientationTransverse       Orientation = 7 // mirror horizontal, rotate 90 CW
+	OrientationRotate270        Orientation = 8 // rotate 270 CW to display
 )
 
+// orientationNames follows exiftool's wording, as the OrientationDescription
+// EXIF entry does.
+var orientationNames = [...]string{
+	"Unknown",
+	"Horizontal (normal)",
+	"Mirror horizontal",
+	"Rotate 180",
+	"Mirror vertical",
+	"Mirror horizontal and rotate 270 CW",
+	"Rotate 90 CW",
+	"Mirror horizontal and rotate 90 CW",
+	"Rotate 270 CW",
+}
+
+// String describes the orientation, e.g. "Rotate 90 CW".
+func (o Orientation) String() string {
+	if o < 0 || int(o) >= len(orientationNames) {
+		return "Unknown"
+	}
+	return orientationNames[o]
+}
+
+// Rational is an unsigned EXIF RATIONAL value. Single RATIONAL tags appear in
+// the EXIF map as Rational and multi-valued ones as []Rational.
+type Rational = formats.Rational
+
+// SRational is a signed EXIF SRATIONAL value.
+type SRational = formats.SRational
+
+// Undefined is the raw value of an EXIF UNDEFINED tag with no known text
+// decoding, such as CFAPattern. Hex and Size describe it, and it
+// encodes to JSON as {"size": n, "hex": "..."}.
+type Undefined = formats.Undefined
+
+// TIFFPage describes one page of a multi-page TIFF, as listed in
+// Additional["Pages"].
+type TIFFPage = formats.TIFFPage
+
+// Logger receives the diagnostic events enabled with WithLogger: a short
+// message followed by alternating keys and values.
+type Logger = formats.Logger
+
 // ImageMetadata contains comprehensive metadata extracted from an image file.
+//
+// HasAlpha reports an alpha channel or transparency of any kind, including
+// a PNG tRNS chunk or a GIF transparent index. Channels counts the color
+// channels of ColorSpace, alpha included; indexed images have one.
+// DPIX and DPIY are the resolution in dots per inch, 0 when unknown.
+// Orientation comes from the EXIF tag, or the JPEG XL header.
 type ImageMetadata struct {
 	Format        Format                 `json:"format"`
 	Width         int                    `json:"width"`
@@ -34,6 +123,25 @@ type ImageMetadata struct {
 	ColorDepth    int                    `json:"colorDepth"`
 	ColorSpace    ColorSpace             `json:"colorSpace"`
 	HasICCProfile bool                   `json:"hasICCProfile"`
+	HasAlpha      bool                   `json:"hasAlpha"`
+	Channels      int                    `json:"channels"`
+	DPIX          float64                `json:"dpiX"`
+	DPIY          float64                `json:"dpiY"`
+	Orientation   Orientation            `json:"orientation"`
 	EXIF          map[string]interface{} `json:"exif,omitempty"`
 	Additional    map[string]interface{} `json:"additional,omitempty"`
+
+	// Animation, reported for GIF, WebP, APNG and the AVIF and JXL
+	// sequence flags. Still images have one frame; a LoopCount of 0 means
+	// the animation repeats forever.
+	IsAnimated bool          `json:"isAnimated"`
+	FrameCount int           `json:"frameCount"`
+	Duration   time.Duration `json:"duration"`
+	LoopCount  int           `json:"loopCount"`
+
+	// rawEXIF is the TIFF block behind EXIF, kept for Thumbnail
+	rawEXIF []byte
+
+	// lazyEXIF decodes rawEXIF on first use under WithLazyEXIF
+	lazyEXIF *lazyEXIF
 }
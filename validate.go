@@ -0,0 +1,30 @@
+package imx
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Validate checks that r holds a structurally sound image, for use as a
+// gate before accepting untrusted uploads. Unlike the Metadata functions,
+// which return whatever they could read, it fails on the first
+// inconsistency: a truncated file, a JPEG segment or PNG chunk overrunning
+// the data, a PNG chunk CRC mismatch, an EXIF IFD offset past the end of
+// its block, or unknown dimensions.
+//
+// Formats without a strict parser are only checked for detectable
+// dimensions. The error wraps ErrUnsupportedFormat or formats.ErrInvalidData.
+func Validate(r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+
+	opts := options{strict: true, verifyCRC: true}
+	_, err = metadataFromSeeker(context.Background(), r, size, opts)
+	return err
+}